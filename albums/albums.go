@@ -1,58 +1,172 @@
 package albums
 
 import (
-	"context"
+	"fmt"
 	"log"
+	"path"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/ksuayan/go-tracks/artists"
 	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/musicbrainz"
+	"github.com/ksuayan/go-tracks/utils"
 )
 
-// Update Album in the database and return the album ID
-func UpdateAlbums(db *mongo.Database, track map[string] interface {} ) (string, error) {
+// Update Album in the database and return the album ID.
+// Albums are identified by their MusicBrainz release ID (or release group ID)
+// when the track carries one. Otherwise the identity is the normalized title
+// (without any "Disc 1" suffix), the resolved album artist and the year.
+// The album artist is the parsed album artist tag, "Various Artists" for
+// compilations, or else the track's main artists.
+func UpdateAlbums(db *mongo.Database, track map[string]interface{}, mb *musicbrainz.Client) (string, error) {
 
-	album := track["album"].(string)
-	artistID := track["artistID"].(string)
-	albumArtist := track["albumArtist"].(string)
+	album := StripDiscSuffix(track["album"].(string))
+	albumArtistTag := track["albumArtist"].(string)
 	coverArtHash := track["coverArtHash"].(string)
+	year := utils.SafeGetInt(track, "year")
+	compilation := IsCompilation(track)
+	ids := musicbrainz.IDsFromTrack(track)
+	// Tracks in disc subfolders remember the album folder above them
+	dir := ""
+	if subDir := utils.SafeGetString(track, "subDir"); utils.AlbumDir(subDir) != subDir {
+		dir = path.Join(utils.SafeGetString(track, "rootDir"), utils.AlbumDir(subDir))
+	}
 
-	if albumArtist == "" {
-		albumArtist = artistID
+	credits, err := albumCredits(db, track, albumArtistTag, compilation, ids, mb)
+	if err != nil {
+		return "", err
 	}
+	if len(credits) == 0 {
+		return "", fmt.Errorf("no album artist for %s", album)
+	}
+	albumArtistID := credits[0].ArtistID
+	albumArtist := artists.CreditedName(credits)
 
 	albumsCollection := db.Collection("albums")
-	albumFilter := bson.M{"name": album, "albumArtist": albumArtist}
-	albumUpdate := bson.M{
-		"$set": bson.M{
-			"name":        album,
-			"albumArtist":    albumArtist,
-			"coverArtHash": coverArtHash,
-		},
+	albumFilter, err := albumFilter(albumsCollection, NormalizeTitle(album), albumArtistID, year, dir, ids)
+	if err != nil {
+		return "", err
 	}
 
-	albumOpts := options.Update().SetUpsert(true)
-	albumRes, err := albumsCollection.UpdateOne(context.Background(), albumFilter, albumUpdate, albumOpts)
+	set := bson.M{
+		"name":          album,
+		"nameKey":       NormalizeTitle(album),
+		"albumArtist":   albumArtist,
+		"albumArtistID": albumArtistID,
+		"artistCredits": credits,
+		"compilation":   compilation,
+	}
+	if year > 0 {
+		set["year"] = year
+	}
+	// Keep art found on other tracks (or fetched) when this one has none
+	if coverArtHash != "" {
+		set["coverArtHash"] = coverArtHash
+	}
+	if ids.ReleaseID != "" {
+		set["musicbrainz.releaseId"] = ids.ReleaseID
+	}
+	if ids.ReleaseGroupID != "" {
+		set["musicbrainz.releaseGroupId"] = ids.ReleaseGroupID
+	}
+	if len(ids.AlbumArtistIDs) > 0 {
+		set["musicbrainz.albumArtistIds"] = ids.AlbumArtistIDs
+	}
+
+	update := bson.M{"$set": set}
+	if dir != "" {
+		update["$addToSet"] = bson.M{"dirs": dir}
+	}
+	albumID, upserted, err := mongodb.UpsertID(albumsCollection, albumFilter, update)
 	if err != nil {
 		return "", err
 	}
-
-	var albumID string
-	if albumRes.UpsertedID != nil {
-		albumID = mongodb.ToHex(albumRes.UpsertedID.(primitive.ObjectID))
+	if upserted {
 		log.Printf("Upserted Album: %s\n", album)
-	} else {
-		var existingAlbum bson.M
-		err := albumsCollection.FindOne(context.Background(), albumFilter).Decode(&existingAlbum)
-		if err != nil {
-			return "", err
-		}
-		albumID = mongodb.ToHex(existingAlbum["_id"].(primitive.ObjectID))
-		// fmt.Printf("Existing Album: %s\n", album)
 	}
 
 	return albumID, nil
 }
+
+func albumCredits(db *mongo.Database, track map[string]interface{}, albumArtist string, compilation bool, ids musicbrainz.IDs, mb *musicbrainz.Client) ([]artists.Credit, error) {
+	switch {
+	case compilation:
+		credits := []artists.Credit{{Name: VariousArtists, MBID: VariousArtistsMBID, Role: artists.RoleMain}}
+		return artists.ResolveCredits(db, credits, mb)
+	case albumArtist != "":
+		credits := artists.ParseCredits(artists.CreditSource{
+			Artist:   albumArtist,
+			SortName: utils.FindTagValue(utils.TrackTags(track), "albumartistsort", "album_artist-sort", "sort_album_artist"),
+			MBIDs:    ids.AlbumArtistIDs,
+		})
+		return artists.ResolveCredits(db, credits, mb)
+	}
+
+	trackCredits, _ := track["artistCredits"].([]artists.Credit)
+	var credits []artists.Credit
+	for _, credit := range trackCredits {
+		if credit.Role == artists.RoleMain {
+			credits = append(credits, credit)
+		}
+	}
+	return credits, nil
+}
+
+// albumFilter picks the identity used to upsert an album: release MBID first,
+// then release group MBID, then title, album artist and year. An album that
+// was matched by title before its MBIDs were known is adopted rather than
+// duplicated, as is an undated album once a dated track turns up. Undated
+// tracks join an album of the same title and artist whatever its year.
+// Failing those, tracks in disc subfolders (CD1/, CD2/, ...) adopt an album
+// of the same title from the same album folder, so that discs tagged
+// slightly differently stay together.
+func albumFilter(albumsCollection *mongo.Collection, nameKey string, albumArtistID primitive.ObjectID, year int, dir string, ids musicbrainz.IDs) (bson.M, error) {
+	byName := bson.M{"nameKey": nameKey, "albumArtistID": albumArtistID}
+
+	var byID bson.M
+	switch {
+	case ids.ReleaseID != "":
+		byID = bson.M{"musicbrainz.releaseId": ids.ReleaseID}
+	case ids.ReleaseGroupID != "":
+		byID = bson.M{"musicbrainz.releaseGroupId": ids.ReleaseGroupID}
+	}
+	if byID != nil {
+		found, err := mongodb.Exists(albumsCollection, byID)
+		if err != nil || found {
+			return byID, err
+		}
+		byName["musicbrainz.releaseId"] = bson.M{"$exists": false}
+	}
+
+	filters := []bson.M{byName}
+	if year > 0 {
+		dated := withField(byName, "year", year)
+		undated := withField(byName, "year", bson.M{"$in": bson.A{0, nil}})
+		filters = []bson.M{dated, undated}
+	}
+	if dir != "" {
+		byDir := bson.M{"nameKey": nameKey, "dirs": dir}
+		if byID != nil {
+			byDir["musicbrainz.releaseId"] = bson.M{"$exists": false}
+		}
+		filters = append(filters, byDir)
+	}
+	for _, filter := range filters {
+		found, err := mongodb.Exists(albumsCollection, filter)
+		if err != nil || found {
+			return filter, err
+		}
+	}
+	return filters[0], nil
+}
+
+func withField(filter bson.M, key string, value interface{}) bson.M {
+	copied := bson.M{key: value}
+	for k, v := range filter {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,42 @@
+package albums
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/coverart"
+	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/musicbrainz"
+)
+
+// FetchMissingCoverArt returns the album's cover art hash, fetching the
+// front cover from the Cover Art Archive when no track of the album had
+// embedded or sidecar art. It returns "" if the album has no art and mb is
+// nil or the album has no MBIDs.
+func FetchMissingCoverArt(db *mongo.Database, mb *musicbrainz.Client, albumID, outputDir, size string) (string, error) {
+	albumsCollection := db.Collection("albums")
+	filter := bson.M{"_id": mongodb.SafeObjectIDFromHex(albumID)}
+
+	var album struct {
+		CoverArtHash string          `bson:"coverArtHash"`
+		MusicBrainz  musicbrainz.IDs `bson:"musicbrainz"`
+	}
+	if err := albumsCollection.FindOne(context.Background(), filter).Decode(&album); err != nil {
+		return "", err
+	}
+	if album.CoverArtHash != "" {
+		return album.CoverArtHash, nil
+	}
+	if mb == nil || (album.MusicBrainz.ReleaseID == "" && album.MusicBrainz.ReleaseGroupID == "") {
+		return "", nil
+	}
+
+	hash, _, err := coverart.FetchCoverArtArchive(db, mb, album.MusicBrainz.ReleaseID, album.MusicBrainz.ReleaseGroupID, outputDir, size)
+	if err != nil {
+		return "", err
+	}
+	_, err = albumsCollection.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"coverArtHash": hash}})
+	return hash, err
+}
@@ -0,0 +1,212 @@
+package albums
+
+import (
+	"context"
+	"log"
+	"path"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// Disc is one disc of an album listing, with its tracks in order.
+type Disc struct {
+	Number   int
+	Subtitle string
+	Tracks   []ListedTrack
+}
+
+// ListedTrack is a track in an album listing.
+type ListedTrack struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	Disc   int                `bson:"disc"`
+	Track  int                `bson:"track"`
+	Title  string             `bson:"title"`
+	Artist string             `bson:"artist"`
+	Length time.Duration      `bson:"length"`
+	// FileName orders tracks without track numbers
+	FileName     string `bson:"fileName"`
+	DiscSubtitle string `bson:"discSubtitle"`
+}
+
+// BoxSet groups albums released together, detected as different albums
+// filed in disc subfolders of one folder ("Box/CD1 - Album A",
+// "Box/CD2 - Album B"). Albums are ordered by their disc folder.
+type BoxSet struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	Name    string             `bson:"name"`
+	RootDir string             `bson:"rootDir"`
+	Dir     string             `bson:"dir"`
+	Albums  []BoxSetAlbum      `bson:"albums"`
+}
+
+// BoxSetAlbum is an album of a box set with the disc folders it spans.
+type BoxSetAlbum struct {
+	AlbumID primitive.ObjectID `bson:"albumId"`
+	Name    string             `bson:"name"`
+	Discs   []int              `bson:"discs"`
+}
+
+// Listing returns an album's tracks grouped into discs, ordered by disc and
+// track number. Tracks without a disc number are on disc 1.
+func Listing(db *mongo.Database, albumID primitive.ObjectID) ([]Disc, error) {
+	ctx := context.Background()
+	cursor, err := db.Collection("tracks").Find(ctx, bson.M{"albumID": albumID})
+	if err != nil {
+		return nil, err
+	}
+	var listed []ListedTrack
+	if err := cursor.All(ctx, &listed); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(listed, func(i, j int) bool {
+		a, b := listed[i], listed[j]
+		if max(a.Disc, 1) != max(b.Disc, 1) {
+			return max(a.Disc, 1) < max(b.Disc, 1)
+		}
+		if a.Track != b.Track {
+			return a.Track < b.Track
+		}
+		return a.FileName < b.FileName
+	})
+	var discs []Disc
+	for _, track := range listed {
+		number := max(track.Disc, 1)
+		if len(discs) == 0 || discs[len(discs)-1].Number != number {
+			discs = append(discs, Disc{Number: number})
+		}
+		disc := &discs[len(discs)-1]
+		if disc.Subtitle == "" {
+			disc.Subtitle = track.DiscSubtitle
+		}
+		disc.Tracks = append(disc.Tracks, track)
+	}
+	return discs, nil
+}
+
+// UpdateBoxSets finds folders whose disc subfolders hold more than one album
+// and records them in the `boxsets` collection, linking each album through
+// `boxSetID`. Box sets that no longer exist are removed. It returns the
+// number of box sets found.
+func UpdateBoxSets(db *mongo.Database) (int, error) {
+	ctx := context.Background()
+
+	filter := bson.M{"albumID": bson.M{"$exists": true}}
+	opts := options.Find().SetProjection(bson.M{"rootDir": 1, "subDir": 1, "albumID": 1, "album": 1})
+	cursor, err := db.Collection("tracks").Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var found []struct {
+		RootDir string             `bson:"rootDir"`
+		SubDir  string             `bson:"subDir"`
+		AlbumID primitive.ObjectID `bson:"albumID"`
+		Album   string             `bson:"album"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return 0, err
+	}
+
+	type boxKey struct{ rootDir, dir string }
+	boxes := make(map[boxKey]map[primitive.ObjectID]*BoxSetAlbum)
+	for _, track := range found {
+		disc, _, ok := utils.ParseDiscFolder(path.Base(track.SubDir))
+		if !ok {
+			continue
+		}
+		key := boxKey{track.RootDir, utils.AlbumDir(track.SubDir)}
+		if boxes[key] == nil {
+			boxes[key] = make(map[primitive.ObjectID]*BoxSetAlbum)
+		}
+		album := boxes[key][track.AlbumID]
+		if album == nil {
+			album = &BoxSetAlbum{AlbumID: track.AlbumID, Name: StripDiscSuffix(track.Album)}
+			boxes[key][track.AlbumID] = album
+		}
+		if !containsInt(album.Discs, disc) {
+			album.Discs = append(album.Discs, disc)
+		}
+	}
+
+	var boxSetIDs []primitive.ObjectID
+	for key, byAlbum := range boxes {
+		// One album over several disc folders is a multi-disc album
+		if len(byAlbum) < 2 {
+			continue
+		}
+		var boxAlbums []BoxSetAlbum
+		var albumIDs []primitive.ObjectID
+		for _, album := range byAlbum {
+			sort.Ints(album.Discs)
+			boxAlbums = append(boxAlbums, *album)
+			albumIDs = append(albumIDs, album.AlbumID)
+		}
+		sort.Slice(boxAlbums, func(i, j int) bool { return boxAlbums[i].Discs[0] < boxAlbums[j].Discs[0] })
+
+		name := path.Base(key.dir)
+		if key.dir == "" {
+			name = path.Base(key.rootDir)
+		}
+		boxSetID, upserted, err := mongodb.UpsertID(db.Collection("boxsets"),
+			bson.M{"rootDir": key.rootDir, "dir": key.dir},
+			bson.M{"$set": bson.M{"name": name, "albums": boxAlbums}})
+		if err != nil {
+			return 0, err
+		}
+		if upserted {
+			log.Printf("Upserted Box Set: %s\n", name)
+		}
+		objectID := mongodb.SafeObjectIDFromHex(boxSetID)
+		boxSetIDs = append(boxSetIDs, objectID)
+
+		_, err = db.Collection("albums").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": albumIDs}}, bson.M{"$set": bson.M{"boxSetID": objectID}})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Unlink albums from box sets that no longer exist and remove those
+	if boxSetIDs == nil {
+		boxSetIDs = []primitive.ObjectID{}
+	}
+	stale := bson.M{"_id": bson.M{"$nin": boxSetIDs}}
+	if _, err := db.Collection("boxsets").DeleteMany(ctx, stale); err != nil {
+		return 0, err
+	}
+	_, err = db.Collection("albums").UpdateMany(ctx,
+		bson.M{"boxSetID": bson.M{"$exists": true, "$nin": boxSetIDs}},
+		bson.M{"$unset": bson.M{"boxSetID": ""}})
+	if err != nil {
+		return 0, err
+	}
+	return len(boxSetIDs), nil
+}
+
+// BoxSets returns every box set, sorted by name.
+func BoxSets(db *mongo.Database) ([]BoxSet, error) {
+	ctx := context.Background()
+	cursor, err := db.Collection("boxsets").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var boxSets []BoxSet
+	err = cursor.All(ctx, &boxSets)
+	return boxSets, err
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package albums
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/musicbrainz"
+)
+
+// EnrichAlbum merges MusicBrainz release (or release group) data into the
+// album's `musicbrainz` sub-document. It returns the release so the caller
+// can enrich the album's tracks from the same tracklist; the release is nil
+// when the album only has a release group MBID or no MBIDs at all.
+func EnrichAlbum(db *mongo.Database, mb *musicbrainz.Client, albumID string, ids musicbrainz.IDs) (*musicbrainz.Release, error) {
+	var release *musicbrainz.Release
+	set := bson.M{}
+
+	switch {
+	case ids.ReleaseID != "":
+		var err error
+		release, err = mb.LookupRelease(ids.ReleaseID)
+		if err != nil {
+			return nil, err
+		}
+		setRelease(set, release)
+	case ids.ReleaseGroupID != "":
+		group, err := mb.LookupReleaseGroup(ids.ReleaseGroupID)
+		if err != nil {
+			return nil, err
+		}
+		setReleaseGroup(set, group)
+	default:
+		return nil, nil
+	}
+	set["musicbrainz.enrichedAt"] = time.Now()
+
+	_, err := db.Collection("albums").UpdateOne(context.Background(),
+		bson.M{"_id": mongodb.SafeObjectIDFromHex(albumID)},
+		bson.M{"$set": set})
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+func setRelease(set bson.M, release *musicbrainz.Release) {
+	label, catalogNumber := release.Label()
+	trackCount := 0
+	for _, medium := range release.Media {
+		trackCount += medium.TrackCount
+	}
+
+	set["musicbrainz.releaseId"] = release.ID
+	set["musicbrainz.title"] = release.Title
+	set["musicbrainz.date"] = release.Date
+	set["musicbrainz.country"] = release.Country
+	set["musicbrainz.status"] = release.Status
+	set["musicbrainz.barcode"] = release.Barcode
+	set["musicbrainz.label"] = label
+	set["musicbrainz.catalogNumber"] = catalogNumber
+	set["musicbrainz.artistCredit"] = release.ArtistCredit
+	set["musicbrainz.albumArtist"] = musicbrainz.CreditedName(release.ArtistCredit)
+	set["musicbrainz.discCount"] = len(release.Media)
+	set["musicbrainz.trackCount"] = trackCount
+	if release.ReleaseGroup != nil {
+		setReleaseGroup(set, release.ReleaseGroup)
+	}
+}
+
+func setReleaseGroup(set bson.M, group *musicbrainz.ReleaseGroup) {
+	set["musicbrainz.releaseGroupId"] = group.ID
+	set["musicbrainz.releaseGroup"] = bson.M{
+		"title":            group.Title,
+		"primaryType":      group.PrimaryType,
+		"secondaryTypes":   group.SecondaryTypes,
+		"firstReleaseDate": group.FirstReleaseDate,
+	}
+	if _, ok := set["musicbrainz.title"]; !ok {
+		set["musicbrainz.title"] = group.Title
+		set["musicbrainz.artistCredit"] = group.ArtistCredit
+		set["musicbrainz.albumArtist"] = musicbrainz.CreditedName(group.ArtistCredit)
+	}
+}
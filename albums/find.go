@@ -0,0 +1,39 @@
+package albums
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/mongodb"
+)
+
+// ArtistFilter matches albums crediting an artist as an album artist.
+func ArtistFilter(artistID string) bson.M {
+	return bson.M{"artistCredits.artistId": mongodb.SafeObjectIDFromHex(artistID)}
+}
+
+// FindByArtist returns the albums crediting the artist, plus the albums
+// containing tracks the artist is credited on (e.g. as a featured artist).
+func FindByArtist(db *mongo.Database, artistID string) ([]bson.M, error) {
+	objectID := mongodb.SafeObjectIDFromHex(artistID)
+	appearsOn, err := db.Collection("tracks").Distinct(context.Background(), "albumID", bson.M{"$or": []bson.M{
+		{"artistID": objectID},
+		{"artistCredits.artistId": objectID},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"$or": []bson.M{ArtistFilter(artistID), {"_id": bson.M{"$in": appearsOn}}}}
+	cursor, err := db.Collection("albums").Find(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	var found []bson.M
+	if err := cursor.All(context.Background(), &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
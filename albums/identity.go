@@ -0,0 +1,49 @@
+package albums
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+const (
+	// VariousArtists is the album artist credited on compilations.
+	VariousArtists = "Various Artists"
+	// VariousArtistsMBID is MusicBrainz's special "Various Artists" artist.
+	VariousArtistsMBID = "89ad4ac3-39f7-470e-963a-56509c546377"
+)
+
+// discSuffix matches disc indicators appended to album titles, as in
+// "Album (Disc 1)", "Album [CD 2/3]" or "Album - Disk 2 of 2".
+var discSuffix = regexp.MustCompile(`(?i)[\s\-–:,]*[(\[]?\s*(?:disc|disk|cd)\s*\.?\s*\d+(?:\s*(?:of|/)\s*\d+)?\s*[)\]]?\s*$`)
+
+// StripDiscSuffix removes a trailing disc indicator from an album title so
+// that every disc of a set shares one title.
+func StripDiscSuffix(title string) string {
+	stripped := strings.TrimSpace(discSuffix.ReplaceAllString(title, ""))
+	if stripped == "" {
+		return strings.TrimSpace(title)
+	}
+	return stripped
+}
+
+// NormalizeTitle returns the key used to identify an album by title.
+func NormalizeTitle(title string) string {
+	return utils.FoldKey(StripDiscSuffix(title))
+}
+
+// IsCompilation reports whether a track belongs to a various-artists
+// compilation, from the iTunes/Picard compilation flag (TCMP, cpil,
+// COMPILATION) or an album artist of "Various Artists".
+func IsCompilation(track map[string]interface{}) bool {
+	switch strings.ToLower(utils.FindTagValue(utils.TrackTags(track), "compilation", "tcmp", "itunescompilation")) {
+	case "1", "true", "yes":
+		return true
+	}
+	switch utils.FoldKey(utils.SafeGetString(track, "albumArtist")) {
+	case "various artists", "various", "va":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package albums
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/artists"
+	"github.com/ksuayan/go-tracks/mongodb"
+)
+
+// Migrate re-files every track under the album given by the current identity
+// rules. Albums that were split by an ObjectID album artist, by track artist
+// on compilations or by disc suffixes are merged, keeping the cover art and
+// MusicBrainz data of the albums they replace. Albums left without tracks
+// are deleted and the stats of the rest recomputed. It returns the number of
+// tracks moved and albums removed.
+func Migrate(db *mongo.Database) (int, int, error) {
+	ctx := context.Background()
+	tracksCollection := db.Collection("tracks")
+
+	cursor, err := tracksCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	moved := 0
+	carried := make(map[[2]primitive.ObjectID]bool)
+	for cursor.Next(ctx) {
+		var track map[string]interface{}
+		if err := cursor.Decode(&track); err != nil {
+			return moved, 0, err
+		}
+		for _, key := range []string{"album", "albumArtist", "coverArtHash"} {
+			if _, ok := track[key].(string); !ok {
+				track[key] = ""
+			}
+		}
+		oldAlbumID, _ := track["albumID"].(primitive.ObjectID)
+
+		// Credits are re-resolved since older tracks predate them
+		artistID, err := artists.UpdateArtists(db, track, nil)
+		if err != nil {
+			log.Printf("Error updating artist for track %v: %v\n", track["_id"], err)
+			continue
+		}
+		track["artistID"] = artistID
+
+		albumID, err := UpdateAlbums(db, track, nil)
+		if err != nil {
+			log.Printf("Error updating album for track %v: %v\n", track["_id"], err)
+			continue
+		}
+		newAlbumID := mongodb.SafeObjectIDFromHex(albumID)
+
+		_, err = tracksCollection.UpdateOne(ctx, bson.M{"_id": track["_id"]}, bson.M{"$set": bson.M{
+			"albumID":       newAlbumID,
+			"artistID":      mongodb.SafeObjectIDFromHex(artistID),
+			"artistCredits": track["artistCredits"],
+		}})
+		if err != nil {
+			return moved, 0, err
+		}
+
+		if oldAlbumID != newAlbumID {
+			moved++
+			pair := [2]primitive.ObjectID{oldAlbumID, newAlbumID}
+			if !oldAlbumID.IsZero() && !carried[pair] {
+				carried[pair] = true
+				if err := carryOver(db, oldAlbumID, newAlbumID); err != nil {
+					log.Printf("Error carrying over album %s: %v\n", oldAlbumID.Hex(), err)
+				}
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return moved, 0, err
+	}
+
+	removed, err := removeEmptyAlbums(db)
+	if err != nil {
+		return moved, removed, err
+	}
+	_, err = UpdateStats(db)
+	return moved, removed, err
+}
+
+// carryOver copies cover art and MusicBrainz data from a replaced album to
+// its successor when the successor has none.
+func carryOver(db *mongo.Database, from, to primitive.ObjectID) error {
+	ctx := context.Background()
+	albumsCollection := db.Collection("albums")
+
+	var old bson.M
+	if err := albumsCollection.FindOne(ctx, bson.M{"_id": from}).Decode(&old); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	if hash, _ := old["coverArtHash"].(string); hash != "" {
+		_, err := albumsCollection.UpdateOne(ctx,
+			bson.M{"_id": to, "coverArtHash": bson.M{"$in": bson.A{"", nil}}},
+			bson.M{"$set": bson.M{"coverArtHash": hash}})
+		if err != nil {
+			return err
+		}
+	}
+	if mb, ok := releaseData(old); ok {
+		_, err := albumsCollection.UpdateOne(ctx,
+			bson.M{"_id": to, "musicbrainz.releaseId": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"musicbrainz": mb}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseData returns the MusicBrainz data of a decoded album when it has
+// been matched to a release. Embedded documents decode as primitive.M or
+// as plain maps depending on the target, so both are accepted.
+func releaseData(album map[string]interface{}) (map[string]interface{}, bool) {
+	var mb map[string]interface{}
+	switch m := album["musicbrainz"].(type) {
+	case map[string]interface{}:
+		mb = m
+	case primitive.M:
+		mb = m
+	}
+	if id, _ := mb["releaseId"].(string); id == "" {
+		return nil, false
+	}
+	return mb, true
+}
+
+// removeEmptyAlbums deletes albums no track points to, along with their
+// match queue entries.
+func removeEmptyAlbums(db *mongo.Database) (int, error) {
+	ctx := context.Background()
+	used, err := db.Collection("tracks").Distinct(ctx, "albumID", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	empty := bson.M{"_id": bson.M{"$nin": used}}
+	res, err := db.Collection("albums").DeleteMany(ctx, empty)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.Collection("matchqueue").DeleteMany(ctx, empty); err != nil {
+		return int(res.DeletedCount), err
+	}
+	return int(res.DeletedCount), nil
+}
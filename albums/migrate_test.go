@@ -0,0 +1,62 @@
+package albums
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// decode round-trips a document through BSON into out, as reading it from
+// the albums collection would.
+func decode(t *testing.T, doc interface{}, out interface{}) {
+	t.Helper()
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleaseDataSurvivesMerge(t *testing.T) {
+	album := bson.M{
+		"name": "Kind of Blue",
+		"musicbrainz": bson.M{
+			"releaseId":  "e5c1a4a3-0d5c-4c5e-9d4c-7f2a1b3c4d5e",
+			"title":      "Kind of Blue",
+			"label":      "Columbia",
+			"trackCount": 5,
+		},
+	}
+
+	var asM bson.M
+	decode(t, album, &asM)
+	var asMap map[string]interface{}
+	decode(t, album, &asMap)
+
+	for name, old := range map[string]map[string]interface{}{"bson.M": asM, "map": asMap} {
+		mb, ok := releaseData(old)
+		if !ok {
+			t.Errorf("%s: release data of a matched album not found", name)
+			continue
+		}
+		if mb["releaseId"] != "e5c1a4a3-0d5c-4c5e-9d4c-7f2a1b3c4d5e" || mb["label"] != "Columbia" {
+			t.Errorf("%s: got %v, want the album's release data", name, mb)
+		}
+	}
+}
+
+func TestReleaseDataNeedsARelease(t *testing.T) {
+	for _, album := range []bson.M{
+		{"name": "Unmatched"},
+		{"name": "Tagged only", "musicbrainz": bson.M{"enrichedAt": "2024-01-01"}},
+		{"name": "Empty ID", "musicbrainz": bson.M{"releaseId": ""}},
+	} {
+		var old bson.M
+		decode(t, album, &old)
+		if mb, ok := releaseData(old); ok {
+			t.Errorf("%s: got release data %v, want none", album["name"], mb)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package albums
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Stats are the aggregates kept on each album under "stats", recomputed from
+// the album's tracks.
+type Stats struct {
+	TrackCount    int            `bson:"trackCount"`
+	DiscCount     int            `bson:"discCount"`
+	Discs         []DiscStats    `bson:"discs"`
+	Duration      time.Duration  `bson:"duration"`
+	YearMin       int            `bson:"yearMin,omitempty"`
+	YearMax       int            `bson:"yearMax,omitempty"`
+	Genres        []string       `bson:"genres"`
+	Formats       []string       `bson:"formats"`
+	BitDepths     []int          `bson:"bitDepths"`
+	SampleRates   []int          `bson:"sampleRates"`
+	Size          int64          `bson:"size"`
+	MissingTracks []MissingTrack `bson:"missingTracks"`
+	MissingDiscs  []int          `bson:"missingDiscs"`
+	Incomplete    bool           `bson:"incomplete"`
+	MixedQuality  bool           `bson:"mixedQuality"`
+	UpdatedAt     time.Time      `bson:"updatedAt"`
+}
+
+// DiscStats describes one disc of an album.
+type DiscStats struct {
+	Number     int    `bson:"number"`
+	Subtitle   string `bson:"subtitle,omitempty"`
+	TrackCount int    `bson:"trackCount"`
+	TrackTotal int    `bson:"trackTotal,omitempty"`
+}
+
+// MissingTrack is a track number absent from an album whose track total is
+// known.
+type MissingTrack struct {
+	Disc  int `bson:"disc"`
+	Track int `bson:"track"`
+}
+
+// statsTrack is the part of a track document the aggregates are built from.
+type statsTrack struct {
+	AlbumID       primitive.ObjectID   `bson:"albumID"`
+	Track         int                  `bson:"track"`
+	TrackTotal    int                  `bson:"trackTotal"`
+	Disc          int                  `bson:"disc"`
+	DiscTotal     int                  `bson:"discTotal"`
+	DiscSubtitle  string               `bson:"discSubtitle"`
+	Year          int                  `bson:"year"`
+	Genre         string               `bson:"genre"`
+	Genres        []string             `bson:"genres"`
+	GenreIDs      []primitive.ObjectID `bson:"genreIDs"`
+	FileExtension string               `bson:"fileExtension"`
+	Codec         string               `bson:"codec"`
+	BitDepth      int                  `bson:"bitDepth"`
+	Samplerate    int                  `bson:"samplerate"`
+	Length        time.Duration        `bson:"length"`
+	Size          int64                `bson:"size"`
+	RootDir       string               `bson:"rootDir"`
+	SubDir        string               `bson:"subDir"`
+	FileName      string               `bson:"fileName"`
+	CueTrack      int                  `bson:"cueTrack"`
+}
+
+// Summary is an album listed in a report, with its aggregates.
+type Summary struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Name        string             `bson:"name"`
+	AlbumArtist string             `bson:"albumArtist"`
+	Year        int                `bson:"year"`
+	Stats       Stats              `bson:"stats"`
+}
+
+// UpdateStats recomputes the aggregates of the given albums, or of every
+// album when none are given, and returns the number of albums updated.
+func UpdateStats(db *mongo.Database, albumIDs ...primitive.ObjectID) (int, error) {
+	ctx := context.Background()
+
+	filter := bson.M{"albumID": bson.M{"$exists": true}}
+	if len(albumIDs) > 0 {
+		filter = bson.M{"albumID": bson.M{"$in": albumIDs}}
+	}
+	opts := options.Find().SetSort(bson.M{"albumID": 1})
+	cursor, err := db.Collection("tracks").Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	var current []statsTrack
+	flush := func() error {
+		if len(current) == 0 {
+			return nil
+		}
+		albumID := current[0].AlbumID
+		stats := computeStats(current)
+		genreIDs := albumGenreIDs(current)
+		current = current[:0]
+		_, err := db.Collection("albums").UpdateOne(ctx, bson.M{"_id": albumID}, bson.M{"$set": bson.M{
+			"stats":    stats,
+			"genreIDs": genreIDs,
+		}})
+		if err != nil {
+			return err
+		}
+		updated++
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var track statsTrack
+		if err := cursor.Decode(&track); err != nil {
+			log.Printf("Error decoding track for album stats: %v\n", err)
+			continue
+		}
+		if len(current) > 0 && current[0].AlbumID != track.AlbumID {
+			if err := flush(); err != nil {
+				return updated, err
+			}
+		}
+		current = append(current, track)
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, err
+	}
+	return updated, flush()
+}
+
+// computeStats builds album aggregates from its tracks. Tracks without a
+// disc number count as disc 1. Missing tracks are only reported for discs
+// that carry a track total, and missing discs only when a disc total is set.
+func computeStats(tracks []statsTrack) Stats {
+	stats := Stats{TrackCount: len(tracks), UpdatedAt: time.Now()}
+
+	genres := make(map[string]bool)
+	formats := make(map[string]bool)
+	bitDepths := make(map[int]bool)
+	sampleRates := make(map[int]bool)
+	present := make(map[int]map[int]bool)
+	discs := make(map[int]*DiscStats)
+	trackTotals := make(map[int]int)
+	discTotal := 0
+	files := make(map[string]bool)
+
+	for _, track := range tracks {
+		stats.Duration += track.Length
+		// Tracks split from one file by a CUE sheet share its size
+		file := track.RootDir + "/" + track.SubDir + "/" + track.FileName
+		if track.CueTrack == 0 || !files[file] {
+			files[file] = true
+			stats.Size += track.Size
+		}
+		if track.Year > 0 {
+			if stats.YearMin == 0 || track.Year < stats.YearMin {
+				stats.YearMin = track.Year
+			}
+			stats.YearMax = max(stats.YearMax, track.Year)
+		}
+		for _, genre := range trackGenres(track) {
+			genres[genre] = true
+		}
+		if format := trackFormat(track); format != "" {
+			formats[format] = true
+		}
+		if track.BitDepth > 0 {
+			bitDepths[track.BitDepth] = true
+		}
+		if track.Samplerate > 0 {
+			sampleRates[track.Samplerate] = true
+		}
+
+		disc := max(track.Disc, 1)
+		if present[disc] == nil {
+			present[disc] = make(map[int]bool)
+			discs[disc] = &DiscStats{Number: disc}
+		}
+		discs[disc].TrackCount++
+		if discs[disc].Subtitle == "" {
+			discs[disc].Subtitle = track.DiscSubtitle
+		}
+		if track.Track > 0 {
+			present[disc][track.Track] = true
+		}
+		trackTotals[disc] = max(trackTotals[disc], track.TrackTotal)
+		discTotal = max(discTotal, track.DiscTotal)
+	}
+
+	stats.DiscCount = len(present)
+	for _, disc := range sortedKeys(present) {
+		discs[disc].TrackTotal = trackTotals[disc]
+		stats.Discs = append(stats.Discs, *discs[disc])
+	}
+	for disc, total := range trackTotals {
+		for n := 1; n <= total; n++ {
+			if !present[disc][n] {
+				stats.MissingTracks = append(stats.MissingTracks, MissingTrack{Disc: disc, Track: n})
+			}
+		}
+	}
+	sort.Slice(stats.MissingTracks, func(i, j int) bool {
+		a, b := stats.MissingTracks[i], stats.MissingTracks[j]
+		return a.Disc < b.Disc || (a.Disc == b.Disc && a.Track < b.Track)
+	})
+	for disc := 1; disc <= discTotal; disc++ {
+		if present[disc] == nil {
+			stats.MissingDiscs = append(stats.MissingDiscs, disc)
+		}
+	}
+
+	stats.Genres = sortedKeys(genres)
+	stats.Formats = sortedKeys(formats)
+	stats.BitDepths = sortedKeys(bitDepths)
+	stats.SampleRates = sortedKeys(sampleRates)
+	stats.Incomplete = len(stats.MissingTracks) > 0 || len(stats.MissingDiscs) > 0
+	stats.MixedQuality = len(stats.Formats) > 1 || len(stats.BitDepths) > 1 || len(stats.SampleRates) > 1
+	return stats
+}
+
+// trackGenres returns a track's canonical genres, or its genre tag for
+// tracks not yet linked to the genres collection.
+func trackGenres(track statsTrack) []string {
+	if len(track.Genres) > 0 {
+		return track.Genres
+	}
+	if genre := strings.TrimSpace(track.Genre); genre != "" {
+		return []string{genre}
+	}
+	return nil
+}
+
+// albumGenreIDs links an album to every genre of its tracks.
+func albumGenreIDs(tracks []statsTrack) []primitive.ObjectID {
+	genreIDs := []primitive.ObjectID{}
+	seen := make(map[primitive.ObjectID]bool)
+	for _, track := range tracks {
+		for _, genreID := range track.GenreIDs {
+			if !seen[genreID] {
+				seen[genreID] = true
+				genreIDs = append(genreIDs, genreID)
+			}
+		}
+	}
+	return genreIDs
+}
+
+// trackFormat names a track's format by codec, falling back to its file
+// extension for tracks scanned before codecs were recorded.
+func trackFormat(track statsTrack) string {
+	if track.Codec != "" {
+		return track.Codec
+	}
+	return strings.TrimPrefix(track.FileExtension, ".")
+}
+
+func sortedKeys[K string | int, V any](set map[K]V) []K {
+	keys := make([]K, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// FindIncomplete returns the albums with missing tracks or discs.
+func FindIncomplete(db *mongo.Database) ([]Summary, error) {
+	return findSummaries(db, bson.M{"stats.incomplete": true})
+}
+
+// FindMixedQuality returns the albums whose tracks differ in format, bit
+// depth or sample rate.
+func FindMixedQuality(db *mongo.Database) ([]Summary, error) {
+	return findSummaries(db, bson.M{"stats.mixedQuality": true})
+}
+
+func findSummaries(db *mongo.Database, filter bson.M) ([]Summary, error) {
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "albumArtist", Value: 1}, {Key: "name", Value: 1}})
+	cursor, err := db.Collection("albums").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var summaries []Summary
+	err = cursor.All(ctx, &summaries)
+	return summaries, err
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ksuayan/go-tracks/coverart"
+)
+
+// Resized covers are between these sizes, in pixels.
+const (
+	minCoverSize = 16
+	maxCoverSize = 2048
+)
+
+// coverHash matches cover art hashes, which are hex file hashes.
+var coverHash = regexp.MustCompile(`^[0-9a-f]{8,128}$`)
+
+// getCover serves GET /covers/{hash}, optionally scaled down with ?size=.
+// Covers are content-addressed, so they never change and can be cached
+// forever. Resized variants are kept in an LRU disk cache.
+func (s *Server) getCover(w http.ResponseWriter, r *http.Request) {
+	size := 0
+	if value := r.URL.Query().Get("size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < minCoverSize || n > maxCoverSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("size must be between %d and %d", minCoverSize, maxCoverSize))
+			return
+		}
+		size = n
+	}
+	s.serveCover(w, r, strings.TrimSuffix(r.PathValue("hash"), ".jpg"), size)
+}
+
+// serveCover sends a cover by hash, scaled down to size if it isn't 0.
+func (s *Server) serveCover(w http.ResponseWriter, r *http.Request, hash string, size int) {
+	if !coverHash.MatchString(hash) || s.config.CoverDir == "" {
+		writeError(w, http.StatusNotFound, "cover not found")
+		return
+	}
+
+	etag := `"` + hash + `"`
+	if size > 0 {
+		etag = fmt.Sprintf(`"%s-%d"`, hash, size)
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	path, err := coverart.GetCoverArtPathFromHash(s.config.CoverDir, hash)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "cover not found")
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, "cover not found")
+		return
+	}
+	if size > 0 {
+		original := path
+		key := fmt.Sprintf("%s/%s-%d.jpg", hash[:2], hash, size)
+		path, err = s.covers.GetOrCreate(key, func(w io.Writer) error {
+			return coverart.ResizeJPEG(w, original, size)
+		})
+		if err != nil {
+			writeServerError(w, fmt.Errorf("resizing cover %s: %v", hash, err))
+			return
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	// Covers are kept as extracted, so not every original is a JPEG
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
@@ -0,0 +1,687 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/graphql"
+	"github.com/ksuayan/go-tracks/tracks"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// maxQueryBytes bounds the size of a posted GraphQL request.
+const maxQueryBytes = 1 << 20
+
+// graphqlQuery serves POST /graphql, and GET /graphql?query= with
+// optional variables (JSON) and operationName.
+func (s *Server) graphqlQuery(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBytes)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "bad GraphQL request: "+err.Error())
+			return
+		}
+	} else {
+		query := r.URL.Query()
+		req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+		if value := query.Get("variables"); value != "" {
+			if err := json.Unmarshal([]byte(value), &req.Variables); err != nil {
+				writeError(w, http.StatusBadRequest, "bad variables: "+err.Error())
+				return
+			}
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+	writeJSON(w, r, s.schema.Execute(r.Context(), req))
+}
+
+// graphqlSchema serves GET /graphql/schema: the schema in the GraphQL
+// schema language, for client tooling.
+func (s *Server) graphqlSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(s.schema.String()))
+}
+
+// newSchema builds the GraphQL schema. Relationships follow the stored
+// references (albumID, artistID, albumArtistID, artistCredits and
+// coverArtHash) and are batch-loaded: a field is resolved for every object
+// at its depth with one query.
+func (s *Server) newSchema() (*graphql.Schema, error) {
+	pageInfo := &graphql.Object{Name: "PageInfo", Fields: []*graphql.Field{
+		{Name: "hasNextPage", Type: "Boolean!"},
+		{Name: "hasPreviousPage", Type: "Boolean!"},
+		{Name: "startCursor", Type: "String"},
+		{Name: "endCursor", Type: "String"},
+	}}
+	coverArt := &graphql.Object{Name: "CoverArt", Description: "An image, identified by the hash of its content.", Fields: []*graphql.Field{
+		{Name: "hash", Type: "String!"},
+		{Name: "url", Type: "String!", Description: "URL of the image, scaled down to size pixels if given.",
+			Args: []*graphql.Arg{{Name: "size", Type: "Int"}}, Resolve: coverURL},
+		{Name: "source", Type: "String", Description: "Where the image was found: embedded (in the audio file), sidecar (an image file next to it) or caa (the Cover Art Archive).",
+			Batch: s.loadCoverSources},
+	}}
+
+	track := &graphql.Object{Name: "Track", Fields: []*graphql.Field{
+		{Name: "id", Type: "ID!", Resolve: docValue("_id")},
+		{Name: "title", Type: "String"},
+		{Name: "artistName", Type: "String", Description: "The credited artist, as tagged.", Resolve: docValue("artist")},
+		{Name: "albumName", Type: "String", Resolve: docValue("album")},
+		{Name: "albumArtist", Type: "String"},
+		{Name: "year", Type: "Int"},
+		{Name: "disc", Type: "Int"},
+		{Name: "track", Type: "Int"},
+		{Name: "genre", Type: "String"},
+		{Name: "genres", Type: "[String!]"},
+		{Name: "codec", Type: "String"},
+		{Name: "format", Type: "String", Description: "File extension, such as flac.", Resolve: trackFormat},
+		{Name: "bitrate", Type: "Int"},
+		{Name: "samplerate", Type: "Int"},
+		{Name: "bitDepth", Type: "Int"},
+		{Name: "channels", Type: "Int"},
+		{Name: "duration", Type: "Float", Description: "Length in seconds.", Resolve: seconds("length")},
+		{Name: "size", Type: "Float", Description: "File size in bytes."},
+		{Name: "path", Type: "String", Resolve: trackPath},
+		{Name: "added", Type: "String", Resolve: docValue("creationDate")},
+		{Name: "playCount", Type: "Int"},
+		{Name: "lastPlayed", Type: "String"},
+		{Name: "hasLyrics", Type: "Boolean"},
+		{Name: "streamUrl", Type: "String!", Resolve: streamURL},
+		{Name: "coverArt", Type: "CoverArt", Resolve: coverOf},
+	}}
+	album := &graphql.Object{Name: "Album", Fields: []*graphql.Field{
+		{Name: "id", Type: "ID!", Resolve: docValue("_id")},
+		{Name: "name", Type: "String!"},
+		{Name: "artistName", Type: "String", Description: "The credited album artist.", Resolve: docValue("albumArtist")},
+		{Name: "year", Type: "Int"},
+		{Name: "compilation", Type: "Boolean"},
+		{Name: "genres", Type: "[String!]", Resolve: docValue("stats.genres")},
+		{Name: "formats", Type: "[String!]", Resolve: docValue("stats.formats")},
+		{Name: "trackCount", Type: "Int", Resolve: docValue("stats.trackCount")},
+		{Name: "discCount", Type: "Int", Resolve: docValue("stats.discCount")},
+		{Name: "duration", Type: "Float", Description: "Length in seconds.", Resolve: seconds("stats.duration")},
+		{Name: "incomplete", Type: "Boolean", Resolve: docValue("stats.incomplete")},
+		{Name: "mixedQuality", Type: "Boolean", Resolve: docValue("stats.mixedQuality")},
+		{Name: "musicbrainzReleaseId", Type: "String", Resolve: docValue("musicbrainz.releaseId")},
+		{Name: "coverArt", Type: "CoverArt", Resolve: coverOf},
+	}}
+	artist := &graphql.Object{Name: "Artist", Fields: []*graphql.Field{
+		{Name: "id", Type: "ID!", Resolve: docValue("_id")},
+		{Name: "name", Type: "String!"},
+		{Name: "sortName", Type: "String"},
+		{Name: "aliases", Type: "[String!]"},
+		{Name: "musicbrainzId", Type: "String", Resolve: docValue("musicbrainz.id")},
+	}}
+
+	// Relationships, added once all the types exist
+	track.Fields = append(track.Fields,
+		&graphql.Field{Name: "album", Type: "Album", Batch: s.loadRefs("albums", "albumID")},
+		&graphql.Field{Name: "artist", Type: "Artist", Description: "The main credited artist.", Batch: s.loadRefs("artists", "artistID")},
+	)
+	album.Fields = append(album.Fields,
+		&graphql.Field{Name: "artist", Type: "Artist", Description: "The main album artist.", Batch: s.loadRefs("artists", "albumArtistID")},
+		&graphql.Field{Name: "tracks", Type: "[Track!]!", Description: "The tracklist, by disc and track number.",
+			Batch: s.loadChildren("tracks", []string{"albumID"}, bson.D{{Key: "disc", Value: 1}, {Key: "track", Value: 1}, {Key: "fileName", Value: 1}, {Key: "cueTrack", Value: 1}})},
+	)
+	artist.Fields = append(artist.Fields,
+		&graphql.Field{Name: "albums", Type: "[Album!]!", Description: "Albums crediting the artist as an album artist, by year.",
+			Batch: s.loadChildren("albums", []string{"artistCredits.artistId"}, bson.D{{Key: "year", Value: 1}, {Key: "nameKey", Value: 1}})},
+		&graphql.Field{Name: "tracks", Type: "[Track!]!", Description: "Tracks crediting the artist, including as a featured artist.",
+			Args:  []*graphql.Arg{{Name: "first", Type: "Int", Default: 100}},
+			Batch: s.loadChildren("tracks", []string{"artistID", "artistCredits.artistId"}, bson.D{{Key: "year", Value: 1}, {Key: "album", Value: 1}, {Key: "disc", Value: 1}, {Key: "track", Value: 1}})},
+	)
+
+	trackConnection, trackEdge := connectionTypes("Track")
+	albumConnection, albumEdge := connectionTypes("Album")
+	artistConnection, artistEdge := connectionTypes("Artist")
+	trackFilterInput := &graphql.Input{Name: "TrackFilter", Fields: []*graphql.Arg{
+		{Name: "artist", Type: "ID"},
+		{Name: "album", Type: "ID"},
+		{Name: "genre", Type: "String", Description: "Genre name or alias, including subgenres."},
+		{Name: "year", Type: "String", Description: "A year, or a range such as 1955..1965."},
+		{Name: "codec", Type: "String"},
+		{Name: "format", Type: "String"},
+		{Name: "folder", Type: "String", Description: "Prefix of the folder under the library root."},
+		{Name: "hasLyrics", Type: "Boolean"},
+		{Name: "q", Type: "String", Description: "Text in the title."},
+		{Name: "query", Type: "String", Description: "A query such as codec:flac samplerate>48k path:Jazz/*."},
+	}}
+	albumFilterInput := &graphql.Input{Name: "AlbumFilter", Fields: []*graphql.Arg{
+		{Name: "artist", Type: "ID"},
+		{Name: "genre", Type: "String"},
+		{Name: "year", Type: "String"},
+		{Name: "incomplete", Type: "Boolean"},
+		{Name: "mixedQuality", Type: "Boolean"},
+		{Name: "q", Type: "String", Description: "Text in the name."},
+	}}
+	artistFilterInput := &graphql.Input{Name: "ArtistFilter", Fields: []*graphql.Arg{
+		{Name: "q", Type: "String", Description: "Text in the name or an alias."},
+	}}
+	pageArgs := func(filter, sortDefault string) []*graphql.Arg {
+		return []*graphql.Arg{
+			{Name: "first", Type: "Int", Default: defaultLimit},
+			{Name: "after", Type: "String", Description: "Cursor of the edge to start after."},
+			{Name: "sort", Type: "String", Default: sortDefault, Description: "Comma-separated fields, - for descending."},
+			{Name: "filter", Type: filter},
+		}
+	}
+
+	query := &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{Name: "track", Type: "Track", Args: []*graphql.Arg{{Name: "id", Type: "ID!"}}, Resolve: s.findByID("tracks")},
+		{Name: "tracks", Type: "TrackConnection!", Args: pageArgs("TrackFilter", "albumArtist,album,disc,track"),
+			Resolve: s.connection("tracks", trackSort, func(query url.Values) (bson.M, error) { return s.trackFilter(query) })},
+		{Name: "album", Type: "Album", Args: []*graphql.Arg{{Name: "id", Type: "ID!"}}, Resolve: s.findByID("albums")},
+		{Name: "albums", Type: "AlbumConnection!", Args: pageArgs("AlbumFilter", "albumArtist,year,name"),
+			Resolve: s.connection("albums", albumSort, s.albumFilter)},
+		{Name: "artist", Type: "Artist", Args: []*graphql.Arg{{Name: "id", Type: "ID!"}}, Resolve: s.findByID("artists")},
+		{Name: "artists", Type: "ArtistConnection!", Args: pageArgs("ArtistFilter", "sortName"),
+			Resolve: s.connection("artists", artistSort, func(query url.Values) (bson.M, error) { return artistFilter(query), nil })},
+		{Name: "coverArt", Type: "CoverArt", Args: []*graphql.Arg{{Name: "hash", Type: "String!"}}, Resolve: s.findCover},
+	}}
+
+	return graphql.NewSchema(query,
+		track, album, artist, coverArt,
+		trackConnection, trackEdge, albumConnection, albumEdge, artistConnection, artistEdge, pageInfo,
+		trackFilterInput, albumFilterInput, artistFilterInput,
+	)
+}
+
+// connectionTypes returns the connection and edge types of paginated
+// lists of a type.
+func connectionTypes(node string) (*graphql.Object, *graphql.Object) {
+	edge := &graphql.Object{Name: node + "Edge", Fields: []*graphql.Field{
+		{Name: "cursor", Type: "String!"},
+		{Name: "node", Type: node + "!"},
+	}}
+	connection := &graphql.Object{Name: node + "Connection", Fields: []*graphql.Field{
+		{Name: "edges", Type: "[" + node + "Edge!]!", Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			c := source.(*connection)
+			edges := make([]map[string]interface{}, len(c.nodes))
+			for i, node := range c.nodes {
+				edges[i] = map[string]interface{}{"cursor": c.cursors[i], "node": node}
+			}
+			return edges, nil
+		}},
+		{Name: "nodes", Type: "[" + node + "!]!", Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*connection).nodes, nil
+		}},
+		{Name: "pageInfo", Type: "PageInfo!", Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*connection).pageInfo, nil
+		}},
+		{Name: "totalCount", Type: "Int!", Description: "Number of matches across all pages.", Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*connection).count(ctx)
+		}},
+	}}
+	return connection, edge
+}
+
+// connection is a page of a paginated list.
+type connection struct {
+	nodes    []map[string]interface{}
+	cursors  []string
+	pageInfo map[string]interface{}
+	count    func(context.Context) (int64, error)
+}
+
+// connection resolves a paginated list of a collection, with the same
+// filters and sort fields as its REST list.
+func (s *Server) connection(collection string, sortable map[string]string, filterOf func(url.Values) (bson.M, error)) graphql.ResolveFunc {
+	return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		first, _ := args["first"].(int)
+		if first < 0 || first > maxLimit {
+			return nil, fmt.Errorf("first must be between 0 and %d", maxLimit)
+		}
+		sortBy, _ := args["sort"].(string)
+		order, err := parseSort(sortBy, sortable)
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{}
+		if fields, ok := args["filter"].(map[string]interface{}); ok {
+			for name, value := range fields {
+				query.Set(name, fmt.Sprint(value))
+			}
+		}
+		filter, err := filterOf(query)
+		if err != nil {
+			return nil, err
+		}
+		after, _ := args["after"].(string)
+		return s.findConnection(ctx, collection, filter, order, first, after)
+	}
+}
+
+// findConnection finds up to first documents in sort order after a cursor.
+// Cursors hold the sort values of their document, so pages stay consistent
+// when documents are added or removed before them.
+func (s *Server) findConnection(ctx context.Context, collection string, filter bson.M, sort bson.D, first int, after string) (*connection, error) {
+	coll := s.db.Collection(collection)
+	query := filter
+	if after != "" {
+		values, err := decodeCursor(after, len(sort))
+		if err != nil {
+			return nil, err
+		}
+		query = and([]bson.M{filter, afterFilter(sort, values)})
+	}
+	opts := options.Find().SetSort(sort).SetLimit(int64(first) + 1)
+	if collection == "tracks" {
+		opts.SetProjection(trackProjection)
+	}
+	cursor, err := coll.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	found := []map[string]interface{}{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	hasNext := len(found) > first
+	if hasNext {
+		found = found[:first]
+	}
+	c := &connection{
+		nodes:    found,
+		pageInfo: map[string]interface{}{"hasNextPage": hasNext, "hasPreviousPage": after != ""},
+		count: func(ctx context.Context) (int64, error) {
+			return coll.CountDocuments(ctx, filter)
+		},
+	}
+	for _, doc := range found {
+		cursor, err := encodeCursor(sort, doc)
+		if err != nil {
+			return nil, err
+		}
+		c.cursors = append(c.cursors, cursor)
+	}
+	if len(c.cursors) > 0 {
+		c.pageInfo["startCursor"] = c.cursors[0]
+		c.pageInfo["endCursor"] = c.cursors[len(c.cursors)-1]
+	}
+	return c, nil
+}
+
+func encodeCursor(sort bson.D, doc map[string]interface{}) (string, error) {
+	values := bson.A{}
+	for _, key := range sort {
+		values = append(values, fieldAt(doc, key.Key))
+	}
+	data, err := bson.Marshal(bson.M{"v": values})
+	return base64.RawURLEncoding.EncodeToString(data), err
+}
+
+func decodeCursor(cursor string, keys int) ([]interface{}, error) {
+	var decoded struct {
+		V bson.A `bson:"v"`
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || bson.Unmarshal(data, &decoded) != nil || len(decoded.V) != keys {
+		return nil, fmt.Errorf("bad cursor; cursors only work with the sort they came from")
+	}
+	return decoded.V, nil
+}
+
+// afterFilter matches the documents that sort after the given sort values,
+// with missing values sorting first as they do in MongoDB.
+func afterFilter(sort bson.D, values []interface{}) bson.M {
+	var or []bson.M
+	for i, key := range sort {
+		var beyond bson.M
+		value := values[i]
+		descending := key.Value == -1
+		switch {
+		case !descending && value == nil:
+			beyond = bson.M{key.Key: bson.M{"$ne": nil}}
+		case !descending:
+			beyond = bson.M{key.Key: bson.M{"$gt": value}}
+		case value == nil:
+			// Nothing sorts after a missing value in descending order
+			continue
+		default:
+			beyond = bson.M{"$or": bson.A{bson.M{key.Key: bson.M{"$lt": value}}, bson.M{key.Key: nil}}}
+		}
+		var parts []bson.M
+		for _, previous := range sort[:i] {
+			parts = append(parts, bson.M{previous.Key: values[len(parts)]})
+		}
+		or = append(or, and(append(parts, beyond)))
+	}
+	return bson.M{"$or": or}
+}
+
+// findByID resolves a document by its id argument.
+func (s *Server) findByID(collection string) graphql.ResolveFunc {
+	return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		id, err := primitive.ObjectIDFromHex(args["id"].(string))
+		if err != nil {
+			return nil, fmt.Errorf("bad id")
+		}
+		opts := options.FindOne()
+		if collection == "tracks" {
+			opts.SetProjection(trackProjection)
+		}
+		var doc map[string]interface{}
+		err = s.db.Collection(collection).FindOne(ctx, bson.M{"_id": id}, opts).Decode(&doc)
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return doc, err
+	}
+}
+
+// loadRefs batch-loads the documents referenced by the ID at key of each
+// source, such as the albums of tracks from their albumID.
+func (s *Server) loadRefs(collection, key string) graphql.BatchFunc {
+	return func(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		var ids []primitive.ObjectID
+		seen := make(map[primitive.ObjectID]bool)
+		for _, source := range sources {
+			if id, ok := source.(map[string]interface{})[key].(primitive.ObjectID); ok && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		byID := make(map[primitive.ObjectID]map[string]interface{})
+		if len(ids) > 0 {
+			cursor, err := s.db.Collection(collection).Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+			if err != nil {
+				return nil, err
+			}
+			var found []map[string]interface{}
+			if err := cursor.All(ctx, &found); err != nil {
+				return nil, err
+			}
+			for _, doc := range found {
+				byID[doc["_id"].(primitive.ObjectID)] = doc
+			}
+		}
+		out := make([]interface{}, len(sources))
+		for i, source := range sources {
+			id, _ := source.(map[string]interface{})[key].(primitive.ObjectID)
+			if doc, ok := byID[id]; ok {
+				out[i] = doc
+			}
+		}
+		return out, nil
+	}
+}
+
+// loadChildren batch-loads the documents referring to each source by one of
+// keys, such as the tracks of albums by their albumID, in sort order. A
+// first argument limits how many each source gets; see loadFirstChildren.
+func (s *Server) loadChildren(collection string, keys []string, sort bson.D) graphql.BatchFunc {
+	return func(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		limit, limited := args["first"].(int)
+		if limited && (limit < 0 || limit > maxLimit) {
+			return nil, fmt.Errorf("first must be between 0 and %d", maxLimit)
+		}
+		var ids []primitive.ObjectID
+		positions := make(map[primitive.ObjectID][]int)
+		for i, source := range sources {
+			id := source.(map[string]interface{})["_id"].(primitive.ObjectID)
+			if positions[id] == nil {
+				ids = append(ids, id)
+			}
+			positions[id] = append(positions[id], i)
+		}
+		groups := make([][]map[string]interface{}, len(sources))
+		if limited {
+			if err := s.loadFirstChildren(ctx, collection, keys, sort, ids, limit, func(id primitive.ObjectID, doc map[string]interface{}) {
+				for _, i := range positions[id] {
+					groups[i] = append(groups[i], doc)
+				}
+			}); err != nil {
+				return nil, err
+			}
+			return childGroups(groups), nil
+		}
+
+		var or []bson.M
+		for _, key := range keys {
+			or = append(or, bson.M{key: bson.M{"$in": ids}})
+		}
+		opts := options.Find().SetSort(sort)
+		if collection == "tracks" {
+			opts.SetProjection(trackProjection)
+		}
+		cursor, err := s.db.Collection(collection).Find(ctx, bson.M{"$or": or}, opts)
+		if err != nil {
+			return nil, err
+		}
+		var found []map[string]interface{}
+		if err := cursor.All(ctx, &found); err != nil {
+			return nil, err
+		}
+
+		for _, doc := range found {
+			matched := make(map[primitive.ObjectID]bool)
+			for _, key := range keys {
+				for _, value := range valuesAt(doc, strings.Split(key, ".")) {
+					id, ok := value.(primitive.ObjectID)
+					if !ok || matched[id] {
+						continue
+					}
+					matched[id] = true
+					for _, i := range positions[id] {
+						groups[i] = append(groups[i], doc)
+					}
+				}
+			}
+		}
+		return childGroups(groups), nil
+	}
+}
+
+// loadFirstChildren finds the first limit documents referring to each of
+// ids in one aggregation: a pipeline per ID, joined with $unionWith, that
+// matches, sorts and limits its documents so that MongoDB only returns
+// those. Each document is passed to add with the ID it was found for.
+func (s *Server) loadFirstChildren(ctx context.Context, collection string, keys []string, sort bson.D, ids []primitive.ObjectID, limit int, add func(primitive.ObjectID, map[string]interface{})) error {
+	if limit == 0 || len(ids) == 0 {
+		return nil
+	}
+	stages := func(id primitive.ObjectID) bson.A {
+		var or bson.A
+		for _, key := range keys {
+			or = append(or, bson.M{key: id})
+		}
+		pipeline := bson.A{
+			bson.M{"$match": bson.M{"$or": or}},
+			bson.M{"$sort": sort},
+			bson.M{"$limit": limit},
+		}
+		if collection == "tracks" {
+			pipeline = append(pipeline, bson.M{"$project": trackProjection})
+		}
+		return append(pipeline, bson.M{"$addFields": bson.M{"_parent": id}})
+	}
+	pipeline := stages(ids[0])
+	for _, id := range ids[1:] {
+		pipeline = append(pipeline, bson.M{"$unionWith": bson.M{"coll": collection, "pipeline": stages(id)}})
+	}
+	cursor, err := s.db.Collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var found []map[string]interface{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return err
+	}
+	for _, doc := range found {
+		id, _ := doc["_parent"].(primitive.ObjectID)
+		delete(doc, "_parent")
+		add(id, doc)
+	}
+	return nil
+}
+
+// childGroups returns the batch result of loadChildren, with empty lists
+// rather than nulls for sources without children.
+func childGroups(groups [][]map[string]interface{}) []interface{} {
+	out := make([]interface{}, len(groups))
+	for i, group := range groups {
+		if group == nil {
+			group = []map[string]interface{}{}
+		}
+		out[i] = group
+	}
+	return out
+}
+
+// findCover resolves a cover by its hash argument.
+func (s *Server) findCover(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	var doc map[string]interface{}
+	err := s.db.Collection("coverart").FindOne(ctx, bson.M{"hash": args["hash"]}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return doc, err
+}
+
+// loadCoverSources batch-loads where covers came from.
+func (s *Server) loadCoverSources(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	var hashes []string
+	for _, source := range sources {
+		hashes = append(hashes, utils.SafeGetString(source.(map[string]interface{}), "hash"))
+	}
+	cursor, err := s.db.Collection("coverart").Find(ctx, bson.M{"hash": bson.M{"$in": hashes}})
+	if err != nil {
+		return nil, err
+	}
+	var found []map[string]interface{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]string)
+	for _, doc := range found {
+		byHash[utils.SafeGetString(doc, "hash")] = utils.SafeGetString(doc, "source")
+	}
+	out := make([]interface{}, len(sources))
+	for i, hash := range hashes {
+		if source, ok := byHash[hash]; ok && source != "" {
+			out[i] = source
+		}
+	}
+	return out, nil
+}
+
+// coverOf resolves the cover of a track or album.
+func coverOf(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	if hash := utils.SafeGetString(source.(map[string]interface{}), "coverArtHash"); hash != "" {
+		return map[string]interface{}{"hash": hash}, nil
+	}
+	return nil, nil
+}
+
+func coverURL(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	url := "/covers/" + utils.SafeGetString(source.(map[string]interface{}), "hash")
+	if size, ok := args["size"].(int); ok {
+		if size < minCoverSize || size > maxCoverSize {
+			return nil, fmt.Errorf("size must be between %d and %d", minCoverSize, maxCoverSize)
+		}
+		url += fmt.Sprintf("?size=%d", size)
+	}
+	return url, nil
+}
+
+func streamURL(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	return "/tracks/" + hexID(source.(map[string]interface{})["_id"]) + "/stream", nil
+}
+
+func trackFormat(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	if extension := utils.SafeGetString(source.(map[string]interface{}), "fileExtension"); extension != "" {
+		return strings.ToLower(strings.TrimPrefix(extension, ".")), nil
+	}
+	return nil, nil
+}
+
+func trackPath(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	return tracks.FilePath(source.(map[string]interface{})), nil
+}
+
+// docValue resolves the value at a dotted path of a document.
+func docValue(path string) graphql.ResolveFunc {
+	return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return fieldAt(source.(map[string]interface{}), path), nil
+	}
+}
+
+// seconds resolves a stored duration at a dotted path as seconds.
+func seconds(path string) graphql.ResolveFunc {
+	return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		value := fieldAt(source.(map[string]interface{}), path)
+		if value == nil {
+			return nil, nil
+		}
+		return time.Duration(utils.SafeGetInt64(map[string]interface{}{"value": value}, "value")).Seconds(), nil
+	}
+}
+
+// fieldAt returns the value at a dotted path of a document.
+func fieldAt(doc map[string]interface{}, path string) interface{} {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := asDoc(value)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// valuesAt returns the values at a path of a document, through arrays.
+func valuesAt(value interface{}, path []string) []interface{} {
+	if items, ok := asArray(value); ok {
+		var values []interface{}
+		for _, item := range items {
+			values = append(values, valuesAt(item, path)...)
+		}
+		return values
+	}
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+	doc, ok := asDoc(value)
+	if !ok {
+		return nil
+	}
+	return valuesAt(doc[path[0]], path[1:])
+}
+
+// asDoc returns an embedded document, however it was decoded.
+func asDoc(value interface{}) (map[string]interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, true
+	case primitive.M:
+		return m, true
+	case primitive.D:
+		return m.Map(), true
+	}
+	return nil, false
+}
+
+func asArray(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case primitive.A:
+		return v, true
+	}
+	return nil, false
+}
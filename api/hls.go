@@ -0,0 +1,134 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksuayan/go-tracks/tracks"
+	"github.com/ksuayan/go-tracks/transcode"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// hlsSegment is the length of HLS segments. A track's segments are cut
+// from one encode, started by the first request and cached as they are
+// finished.
+const hlsSegment = 10 * time.Second
+
+const hlsContentType = "application/vnd.apple.mpegurl"
+
+// hlsMaster serves GET /tracks/{id}/hls/master.m3u8, listing a variant
+// playlist per HLS profile.
+func (s *Server) hlsMaster(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := s.findAudio(w, r); !ok {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, p := range transcode.HLSProfiles {
+		// MPEG-TS adds roughly 10% to the audio bitrate
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"mp4a.40.2\"\n", p.Bitrate*1100)
+		fmt.Fprintf(&b, "%s/index.m3u8\n", p.Name)
+	}
+	writePlaylist(w, b.String())
+}
+
+// hlsMedia serves GET /tracks/{id}/hls/{profile}/index.m3u8: a VOD
+// playlist of the track's segments, worked out from its length.
+func (s *Server) hlsMedia(w http.ResponseWriter, r *http.Request) {
+	track, _, ok := s.findAudio(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := transcode.FindHLS(r.PathValue("profile")); !ok {
+		writeError(w, http.StatusNotFound, "unknown HLS profile")
+		return
+	}
+	length := time.Duration(utils.SafeGetInt64(track, "length"))
+	if length <= 0 {
+		writeError(w, http.StatusUnprocessableEntity, "track length unknown")
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(hlsSegment.Seconds())))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for n := 0; time.Duration(n)*hlsSegment < length; n++ {
+		duration := min(hlsSegment, length-time.Duration(n)*hlsSegment)
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%d.ts\n", duration.Seconds(), n)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	writePlaylist(w, b.String())
+}
+
+// hlsSegmentFile serves GET /tracks/{id}/hls/{profile}/{segment}, waiting
+// for the encode of the track to reach the segment if needed.
+func (s *Server) hlsSegmentFile(w http.ResponseWriter, r *http.Request) {
+	track, path, ok := s.findAudio(w, r)
+	if !ok {
+		return
+	}
+	profile, ok := transcode.FindHLS(r.PathValue("profile"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown HLS profile")
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(r.PathValue("segment"), ".ts"))
+	length := time.Duration(utils.SafeGetInt64(track, "length"))
+	offset := time.Duration(n) * hlsSegment
+	if err != nil || n < 0 || offset >= length {
+		writeError(w, http.StatusNotFound, "segment not found")
+		return
+	}
+	hash := utils.SafeGetString(track, "fileHash")
+	if len(hash) < 2 {
+		writeError(w, http.StatusUnprocessableEntity, "track has no file hash")
+		return
+	}
+
+	start, _ := tracks.Segment(track)
+	key := fmt.Sprintf("%s/%s", hash[:2], hash)
+	if cueTrack := utils.SafeGetInt(track, "cueTrack"); cueTrack > 0 {
+		key += fmt.Sprintf("-%d", cueTrack)
+	}
+	job := transcode.Job{
+		Path:     path,
+		Start:    start,
+		End:      start + length,
+		Profile:  profile,
+		CacheKey: fmt.Sprintf("%s/%s", key, profile.Name),
+	}
+	segment, err := s.transcoder.Segment(r.Context(), s.hlsCache, job, hlsSegment, n)
+	if err != nil {
+		if r.Context().Err() == nil {
+			writeServerError(w, fmt.Errorf("encoding HLS segment %d of %s: %v", n, job.CacheKey, err))
+		}
+		return
+	}
+
+	file, err := os.Open(segment)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", profile.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+func writePlaylist(w http.ResponseWriter, playlist string) {
+	w.Header().Set("Content-Type", hlsContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(playlist))
+}
@@ -0,0 +1,544 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/albums"
+	"github.com/ksuayan/go-tracks/genres"
+	"github.com/ksuayan/go-tracks/playlists"
+	"github.com/ksuayan/go-tracks/query"
+	"github.com/ksuayan/go-tracks/search"
+	"github.com/ksuayan/go-tracks/tracks"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// Sortable fields per collection, by the name used in ?sort=.
+var (
+	trackSort = map[string]string{
+		"title": "title", "artist": "artist", "album": "album", "albumArtist": "albumArtist",
+		"year": "year", "disc": "disc", "track": "track", "length": "length",
+		"added": "creationDate", "bitrate": "bitrate", "samplerate": "samplerate",
+	}
+	albumSort = map[string]string{
+		"name": "nameKey", "albumArtist": "albumArtist", "year": "year",
+		"tracks": "stats.trackCount", "duration": "stats.duration",
+	}
+	artistSort = map[string]string{"name": "nameKey", "sortName": "sortName"}
+)
+
+// trackProjection leaves out the raw ffprobe output, which can be large.
+var trackProjection = bson.M{"ffprobe": 0}
+
+// listTracks serves GET /tracks, filtered by ?artist=, ?album= (IDs),
+// ?genre= (including subgenres), ?year= (1959 or 1955..1965), ?codec=,
+// ?format=, ?folder= (subDir prefix), ?hasLyrics=, ?q= (title) and ?query=
+// (the query language, as in codec:flac samplerate>48k).
+func (s *Server) listTracks(w http.ResponseWriter, r *http.Request) {
+	p, err := parseList(r, trackSort, "albumArtist,album,disc,track")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := s.trackFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	found, total, err := findPage(r.Context(), s.db.Collection("tracks"), filter, p, trackProjection)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	for _, track := range found {
+		trackJSON(track)
+	}
+	writeJSON(w, r, Page{Items: found, Total: total, Offset: p.offset, Limit: p.limit})
+}
+
+// trackFilter builds the filter of the track list parameters.
+func (s *Server) trackFilter(params url.Values) (bson.M, error) {
+	var parts []bson.M
+	if value := params.Get("artist"); value != "" {
+		if _, err := primitive.ObjectIDFromHex(value); err != nil {
+			return nil, fmt.Errorf("bad artist id")
+		}
+		parts = append(parts, tracks.ArtistFilter(value))
+	}
+	if value := params.Get("album"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad album id")
+		}
+		parts = append(parts, bson.M{"albumID": id})
+	}
+	if value := params.Get("genre"); value != "" {
+		filter, err := s.genreFilter(value)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, filter)
+	}
+	if value := params.Get("year"); value != "" {
+		filter, err := yearFilter(value)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, filter)
+	}
+	if value := params.Get("codec"); value != "" {
+		parts = append(parts, bson.M{"codec": exactly(value)})
+	}
+	if value := params.Get("format"); value != "" {
+		parts = append(parts, bson.M{"fileExtension": exactly("." + strings.TrimPrefix(value, "."))})
+	}
+	if value := params.Get("folder"); value != "" {
+		parts = append(parts, bson.M{"subDir": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value)}})
+	}
+	if value := params.Get("hasLyrics"); value != "" {
+		want, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad hasLyrics %q", value)
+		}
+		if want {
+			parts = append(parts, bson.M{"hasLyrics": true})
+		} else {
+			parts = append(parts, bson.M{"hasLyrics": bson.M{"$ne": true}})
+		}
+	}
+	if value := params.Get("q"); value != "" {
+		parts = append(parts, bson.M{"title": containing(value)})
+	}
+	if value := params.Get("query"); value != "" {
+		filter, err := query.Filter(value)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, filter)
+	}
+	return and(parts), nil
+}
+
+// getTrack serves GET /tracks/{id}.
+func (s *Server) getTrack(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var track map[string]interface{}
+	err := s.db.Collection("tracks").FindOne(r.Context(), bson.M{"_id": id}, options.FindOne().SetProjection(trackProjection)).Decode(&track)
+	if err != nil {
+		writeFindError(w, err, "track")
+		return
+	}
+	writeJSON(w, r, trackJSON(track))
+}
+
+// listAlbums serves GET /albums, filtered by ?artist= (ID), ?genre=,
+// ?year=, ?incomplete=true, ?mixedQuality=true and ?q= (name).
+func (s *Server) listAlbums(w http.ResponseWriter, r *http.Request) {
+	p, err := parseList(r, albumSort, "albumArtist,year,name")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := s.albumFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	found, total, err := findPage(r.Context(), s.db.Collection("albums"), filter, p, nil)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	for _, album := range found {
+		withCoverURL(album)
+	}
+	writeJSON(w, r, Page{Items: found, Total: total, Offset: p.offset, Limit: p.limit})
+}
+
+// albumFilter builds the filter of the album list parameters.
+func (s *Server) albumFilter(query url.Values) (bson.M, error) {
+	var parts []bson.M
+	if value := query.Get("artist"); value != "" {
+		if _, err := primitive.ObjectIDFromHex(value); err != nil {
+			return nil, fmt.Errorf("bad artist id")
+		}
+		parts = append(parts, albums.ArtistFilter(value))
+	}
+	if value := query.Get("genre"); value != "" {
+		filter, err := s.genreFilter(value)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, filter)
+	}
+	if value := query.Get("year"); value != "" {
+		filter, err := yearFilter(value)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, filter)
+	}
+	for param, path := range map[string]string{"incomplete": "stats.incomplete", "mixedQuality": "stats.mixedQuality"} {
+		if value := query.Get(param); value != "" {
+			want, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s %q", param, value)
+			}
+			parts = append(parts, bson.M{path: want})
+		}
+	}
+	if value := query.Get("q"); value != "" {
+		parts = append(parts, bson.M{"name": containing(value)})
+	}
+	return and(parts), nil
+}
+
+// discJSON is a disc of an album's tracklist.
+type discJSON struct {
+	Number   int         `json:"number"`
+	Subtitle string      `json:"subtitle,omitempty"`
+	Tracks   []trackItem `json:"tracks"`
+}
+
+type trackItem struct {
+	ID       primitive.ObjectID `json:"id"`
+	Track    int                `json:"track,omitempty"`
+	Title    string             `json:"title"`
+	Artist   string             `json:"artist"`
+	Duration float64            `json:"duration"` // seconds
+}
+
+// getAlbum serves GET /albums/{id}: the album with its tracklist by disc.
+func (s *Server) getAlbum(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var album map[string]interface{}
+	if err := s.db.Collection("albums").FindOne(r.Context(), bson.M{"_id": id}).Decode(&album); err != nil {
+		writeFindError(w, err, "album")
+		return
+	}
+	listing, err := albums.Listing(s.db, id)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	discs := []discJSON{}
+	for _, disc := range listing {
+		d := discJSON{Number: disc.Number, Subtitle: disc.Subtitle, Tracks: []trackItem{}}
+		for _, track := range disc.Tracks {
+			d.Tracks = append(d.Tracks, trackItem{
+				ID:       track.ID,
+				Track:    track.Track,
+				Title:    track.Title,
+				Artist:   track.Artist,
+				Duration: track.Length.Seconds(),
+			})
+		}
+		discs = append(discs, d)
+	}
+	album["discs"] = discs
+	writeJSON(w, r, withCoverURL(album))
+}
+
+// listArtists serves GET /artists, filtered by ?q= (name).
+func (s *Server) listArtists(w http.ResponseWriter, r *http.Request) {
+	p, err := parseList(r, artistSort, "sortName")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	found, total, err := findPage(r.Context(), s.db.Collection("artists"), artistFilter(r.URL.Query()), p, nil)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, r, Page{Items: found, Total: total, Offset: p.offset, Limit: p.limit})
+}
+
+// artistFilter builds the filter of the artist list parameters.
+func artistFilter(query url.Values) bson.M {
+	if value := query.Get("q"); value != "" {
+		return bson.M{"$or": bson.A{bson.M{"name": containing(value)}, bson.M{"aliases": containing(value)}}}
+	}
+	return bson.M{}
+}
+
+// getArtist serves GET /artists/{id}: the artist with their albums,
+// including those they only appear on, by year.
+func (s *Server) getArtist(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var artist map[string]interface{}
+	if err := s.db.Collection("artists").FindOne(r.Context(), bson.M{"_id": id}).Decode(&artist); err != nil {
+		writeFindError(w, err, "artist")
+		return
+	}
+	found, err := albums.FindByArtist(s.db, id.Hex())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := utils.SafeGetInt(found[i], "year"), utils.SafeGetInt(found[j], "year")
+		if a != b {
+			return a < b
+		}
+		return utils.SafeGetString(found[i], "name") < utils.SafeGetString(found[j], "name")
+	})
+	if found == nil {
+		found = []bson.M{}
+	}
+	for _, album := range found {
+		withCoverURL(album)
+	}
+	artist["albums"] = found
+	writeJSON(w, r, artist)
+}
+
+type genreJSON struct {
+	ID         primitive.ObjectID  `json:"id"`
+	Name       string              `json:"name"`
+	Parent     string              `json:"parent,omitempty"`
+	ParentID   *primitive.ObjectID `json:"parentId,omitempty"`
+	Aliases    []string            `json:"aliases,omitempty"`
+	TrackCount int                 `json:"trackCount"`
+}
+
+// listGenres serves GET /genres: every genre with its parent and the number
+// of tracks linked to it directly.
+func (s *Server) listGenres(w http.ResponseWriter, r *http.Request) {
+	all, err := genres.All(s.db)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	counts, err := genres.TrackCounts(s.db)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	items := []genreJSON{}
+	for _, genre := range all {
+		item := genreJSON{
+			ID:         genre.ID,
+			Name:       genre.Name,
+			Parent:     genre.Parent,
+			Aliases:    genre.Aliases,
+			TrackCount: counts[genre.ID],
+		}
+		if !genre.ParentID.IsZero() {
+			item.ParentID = &genre.ParentID
+		}
+		items = append(items, item)
+	}
+	writeJSON(w, r, Page{Items: items, Total: int64(len(items)), Limit: int64(len(items))})
+}
+
+type playlistJSON struct {
+	ID         primitive.ObjectID       `json:"id"`
+	Name       string                   `json:"name"`
+	Smart      bool                     `json:"smart"`
+	Source     string                   `json:"source,omitempty"`
+	TrackCount int                      `json:"trackCount"`
+	Unresolved int                      `json:"unresolved,omitempty"`
+	Rules      *playlists.Rule          `json:"rules,omitempty"`
+	Tracks     []map[string]interface{} `json:"tracks,omitempty"`
+}
+
+// listPlaylists serves GET /playlists.
+func (s *Server) listPlaylists(w http.ResponseWriter, r *http.Request) {
+	found, err := playlists.FindAll(s.db)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	items := []playlistJSON{}
+	for _, playlist := range found {
+		items = append(items, playlistJSON{
+			ID:         playlist.ID,
+			Name:       playlist.Name,
+			Smart:      playlist.IsSmart(),
+			Source:     playlist.Source,
+			TrackCount: len(playlist.TrackIDs),
+			Unresolved: playlist.Unresolved,
+		})
+	}
+	writeJSON(w, r, Page{Items: items, Total: int64(len(items)), Limit: int64(len(items))})
+}
+
+// getPlaylist serves GET /playlists/{id} with its tracks; smart playlists
+// get their current tracks, without storing them.
+func (s *Server) getPlaylist(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	playlist, err := playlists.Find(s.db, id.Hex())
+	if err != nil {
+		writeFindError(w, err, "playlist")
+		return
+	}
+	var trackDocs []map[string]interface{}
+	if playlist.IsSmart() {
+		trackDocs, err = playlists.FindTracks(s.db, playlist)
+	} else {
+		trackDocs, err = playlists.LoadTracks(s.db, playlist.TrackIDs)
+	}
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	for _, track := range trackDocs {
+		delete(track, "ffprobe")
+		trackJSON(track)
+	}
+	writeJSON(w, r, playlistJSON{
+		ID:         playlist.ID,
+		Name:       playlist.Name,
+		Smart:      playlist.IsSmart(),
+		Source:     playlist.Source,
+		TrackCount: len(trackDocs),
+		Unresolved: playlist.Unresolved,
+		Rules:      playlist.Rules,
+		Tracks:     trackDocs,
+	})
+}
+
+// search serves GET /search?q=: tracks, albums and artists matching every
+// word of q, best first, up to ?limit= (default 10) of each. ?type= (track,
+// album or artist) searches one kind. Tracks match on title, artist, album,
+// album artist, composer, genre and lyrics.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("bad limit %q", value))
+			return
+		}
+		limit = min(n, maxLimit)
+	}
+	opts := search.Options{Limit: limit}
+	if kind := r.URL.Query().Get("type"); kind != "" {
+		if kind != search.KindTrack && kind != search.KindAlbum && kind != search.KindArtist {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("bad type %q", kind))
+			return
+		}
+		opts.Kinds = []string{kind}
+	}
+
+	index, err := s.searchIndex()
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	hits := index.Search(q, opts)
+	docs, err := search.Load(s.db, hits)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	results := map[string][]map[string]interface{}{"tracks": {}, "albums": {}, "artists": {}}
+	for i, hit := range hits {
+		doc := docs[i]
+		if doc == nil {
+			continue
+		}
+		if hit.Kind == search.KindTrack {
+			trackJSON(doc)
+		}
+		doc["score"] = hit.Score
+		results[hit.Kind+"s"] = append(results[hit.Kind+"s"], doc)
+	}
+	writeJSON(w, r, results)
+}
+
+// genreFilter matches a genre, resolved through the taxonomy, and its
+// subgenres. Unknown genres match nothing.
+func (s *Server) genreFilter(name string) (bson.M, error) {
+	genre, err := genres.Find(s.db, s.config.Genres, name)
+	if err == mongo.ErrNoDocuments {
+		return genres.Filter([]primitive.ObjectID{}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids, err := genres.Descendants(s.db, genre.ID)
+	if err != nil {
+		return nil, err
+	}
+	return genres.Filter(ids), nil
+}
+
+// yearFilter parses "1959" or a range "1955..1965".
+func yearFilter(value string) (bson.M, error) {
+	from, to, isRange := strings.Cut(value, "..")
+	start, err := strconv.Atoi(from)
+	if err != nil {
+		return nil, fmt.Errorf("bad year %q", value)
+	}
+	if !isRange {
+		return bson.M{"year": start}, nil
+	}
+	end, err := strconv.Atoi(to)
+	if err != nil {
+		return nil, fmt.Errorf("bad year %q", value)
+	}
+	return bson.M{"year": bson.M{"$gte": start, "$lte": end}}, nil
+}
+
+// trackJSON adds the track's duration in seconds for clients, alongside
+// the stored nanosecond length, and its cover URL.
+func trackJSON(track map[string]interface{}) map[string]interface{} {
+	track["duration"] = time.Duration(utils.SafeGetInt64(track, "length")).Seconds()
+	return withCoverURL(track)
+}
+
+// withCoverURL adds the URL of a track or album cover.
+func withCoverURL(doc map[string]interface{}) map[string]interface{} {
+	if hash := utils.SafeGetString(doc, "coverArtHash"); hash != "" {
+		doc["coverUrl"] = "/covers/" + hash
+	}
+	return doc
+}
+
+func exactly(value string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"}
+}
+
+func containing(value string) primitive.Regex {
+	return primitive.Regex{Pattern: regexp.QuoteMeta(value), Options: "i"}
+}
+
+func and(parts []bson.M) bson.M {
+	switch len(parts) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return parts[0]
+	}
+	return bson.M{"$and": parts}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page sizes.
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Page is a page of a list endpoint's results.
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  int64       `json:"total"`
+	Offset int64       `json:"offset"`
+	Limit  int64       `json:"limit"`
+}
+
+// listParams are the paging and sorting parameters of a list request.
+type listParams struct {
+	offset int64
+	limit  int64
+	sort   bson.D
+}
+
+// parseList reads ?offset=, ?limit= and ?sort= (comma-separated names from
+// sortable, - for descending), falling back to defaultSort.
+func parseList(r *http.Request, sortable map[string]string, defaultSort string) (listParams, error) {
+	p := listParams{limit: defaultLimit}
+	query := r.URL.Query()
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("bad offset %q", value)
+		}
+		p.offset = n
+	}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("bad limit %q", value)
+		}
+		p.limit = min(n, maxLimit)
+	}
+
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = defaultSort
+	}
+	var err error
+	p.sort, err = parseSort(sortBy, sortable)
+	return p, err
+}
+
+// parseSort parses comma-separated names from sortable, - for descending,
+// into a sort ending in _id.
+func parseSort(sortBy string, sortable map[string]string) (bson.D, error) {
+	var sort bson.D
+	for _, name := range strings.Split(sortBy, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		order := 1
+		if strings.HasPrefix(name, "-") {
+			name, order = name[1:], -1
+		}
+		path, ok := sortable[name]
+		if !ok {
+			return nil, fmt.Errorf("can't sort by %q", name)
+		}
+		sort = append(sort, bson.E{Key: path, Value: order})
+	}
+	// A unique last key keeps pages stable
+	return append(sort, bson.E{Key: "_id", Value: 1}), nil
+}
+
+// findPage runs a paged query and returns the documents and total count.
+func findPage(ctx context.Context, coll *mongo.Collection, filter bson.M, p listParams, projection bson.M) ([]map[string]interface{}, int64, error) {
+	total, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := options.Find().SetSort(p.sort).SetSkip(p.offset).SetLimit(p.limit)
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	found := []map[string]interface{}{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, 0, err
+	}
+	return found, total, nil
+}
+
+// writeJSON writes v with an ETag of its content, answering 304 Not
+// Modified when the client already has it.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+// matchesETag reports whether an If-None-Match header lists the ETag.
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func writeServerError(w http.ResponseWriter, err error) {
+	log.Printf("API error: %v\n", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// writeFindError reports a failed lookup as 404 or 500.
+func writeFindError(w http.ResponseWriter, err error, what string) {
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, what+" not found")
+		return
+	}
+	writeServerError(w, err)
+}
+
+// pathID parses the {id} path value.
+func pathID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad id")
+		return id, false
+	}
+	return id, true
+}
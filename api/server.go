@@ -0,0 +1,198 @@
+// Package api serves the library over HTTP as JSON and GraphQL, and to
+// Subsonic clients.
+package api
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/diskcache"
+	"github.com/ksuayan/go-tracks/genres"
+	"github.com/ksuayan/go-tracks/graphql"
+	"github.com/ksuayan/go-tracks/search"
+	"github.com/ksuayan/go-tracks/transcode"
+)
+
+// Default cache size limits.
+const (
+	DefaultCoverCacheSize     = 512 << 20
+	DefaultTranscodeCacheSize = 10 << 30
+	DefaultHLSCacheSize       = 5 << 30
+	DefaultSearchRefresh      = 10 * time.Minute
+)
+
+// Config holds the server settings.
+type Config struct {
+	// Genres resolves genre names and aliases in filters.
+	Genres *genres.Taxonomy
+	// CoverDir is the cover art output directory of the scan.
+	CoverDir string
+	// CoverCacheDir holds resized covers, by default "resized" in CoverDir;
+	// CoverCacheSize bounds it in bytes.
+	CoverCacheDir  string
+	CoverCacheSize int64
+	// LibraryRoots are the directories audio may be streamed from.
+	LibraryRoots []string
+	// DefaultProfile and ClientProfiles (by client name) pick the
+	// transcoding profile of streams that don't ask for one.
+	DefaultProfile string
+	ClientProfiles map[string]string
+	// TranscodeConcurrency bounds running ffmpeg processes, by default one
+	// per CPU. Transcodes are cached in TranscodeCacheDir, if set, up to
+	// TranscodeCacheSize bytes.
+	TranscodeConcurrency int
+	TranscodeCacheDir    string
+	TranscodeCacheSize   int64
+	// HLSCacheDir holds encoded HLS segments, by default in the system
+	// temporary directory; HLSCacheSize bounds it in bytes.
+	HLSCacheDir  string
+	HLSCacheSize int64
+	// SearchRefresh is how old the search index may get before it's
+	// rebuilt, in the background, on the next search.
+	SearchRefresh time.Duration
+}
+
+// Server is the HTTP API over the library collections.
+type Server struct {
+	db         *mongo.Database
+	config     Config
+	mux        *http.ServeMux
+	covers     *diskcache.Cache
+	roots      []string
+	transcoder *transcode.Transcoder
+	hlsCache   *diskcache.Cache
+	schema     *graphql.Schema
+
+	searchMu   sync.Mutex
+	index      *search.Index
+	rebuilding bool
+}
+
+// New creates the API server.
+func New(db *mongo.Database, config Config) (*Server, error) {
+	if config.Genres == nil {
+		config.Genres, _ = genres.LoadTaxonomy("")
+	}
+	if config.CoverCacheDir == "" && config.CoverDir != "" {
+		config.CoverCacheDir = filepath.Join(config.CoverDir, "resized")
+	}
+	if config.CoverCacheSize == 0 {
+		config.CoverCacheSize = DefaultCoverCacheSize
+	}
+	if config.TranscodeConcurrency == 0 {
+		config.TranscodeConcurrency = runtime.NumCPU()
+	}
+	if config.TranscodeCacheSize == 0 {
+		config.TranscodeCacheSize = DefaultTranscodeCacheSize
+	}
+	if config.HLSCacheDir == "" {
+		config.HLSCacheDir = filepath.Join(os.TempDir(), "gt-hls")
+	}
+	if config.HLSCacheSize == 0 {
+		config.HLSCacheSize = DefaultHLSCacheSize
+	}
+	if config.SearchRefresh == 0 {
+		config.SearchRefresh = DefaultSearchRefresh
+	}
+	var err error
+	s := &Server{db: db, config: config, mux: http.NewServeMux()}
+	if config.CoverDir != "" {
+		if s.covers, err = diskcache.New(config.CoverCacheDir, config.CoverCacheSize); err != nil {
+			return nil, err
+		}
+	}
+	s.roots = resolveRoots(config.LibraryRoots)
+	for _, name := range config.ClientProfiles {
+		if _, err := transcode.Find(name); err != nil && name != "raw" {
+			return nil, err
+		}
+	}
+	if config.DefaultProfile != "" && config.DefaultProfile != "raw" {
+		if _, err := transcode.Find(config.DefaultProfile); err != nil {
+			return nil, err
+		}
+	}
+	var transcodes *diskcache.Cache
+	if config.TranscodeCacheDir != "" {
+		if transcodes, err = diskcache.New(config.TranscodeCacheDir, config.TranscodeCacheSize); err != nil {
+			return nil, err
+		}
+	}
+	s.transcoder = transcode.New(config.TranscodeConcurrency, transcodes)
+	if s.hlsCache, err = diskcache.New(config.HLSCacheDir, config.HLSCacheSize); err != nil {
+		return nil, err
+	}
+	if s.schema, err = s.newSchema(); err != nil {
+		return nil, err
+	}
+	s.routes()
+	// Build the search index ahead of the first search
+	go func() {
+		if _, err := s.searchIndex(); err != nil {
+			log.Printf("Error building search index: %v\n", err)
+		}
+	}()
+	return s, nil
+}
+
+// searchIndex returns the search index, building it on first use. Once it's
+// older than SearchRefresh it keeps being used while a new one is built.
+func (s *Server) searchIndex() (*search.Index, error) {
+	s.searchMu.Lock()
+	defer s.searchMu.Unlock()
+	if s.index == nil {
+		index, err := search.Build(s.db)
+		if err != nil {
+			return nil, err
+		}
+		s.index = index
+	} else if time.Since(s.index.Built()) > s.config.SearchRefresh && !s.rebuilding {
+		s.rebuilding = true
+		go func() {
+			index, err := search.Build(s.db)
+			s.searchMu.Lock()
+			defer s.searchMu.Unlock()
+			s.rebuilding = false
+			if err != nil {
+				log.Printf("Error rebuilding search index: %v\n", err)
+				return
+			}
+			s.index = index
+		}()
+	}
+	return s.index, nil
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /tracks", s.listTracks)
+	s.mux.HandleFunc("GET /tracks/{id}", s.getTrack)
+	s.mux.HandleFunc("GET /tracks/{id}/stream", s.streamTrack)
+	s.mux.HandleFunc("GET /tracks/{id}/hls/master.m3u8", s.hlsMaster)
+	s.mux.HandleFunc("GET /tracks/{id}/hls/{profile}/index.m3u8", s.hlsMedia)
+	s.mux.HandleFunc("GET /tracks/{id}/hls/{profile}/{segment}", s.hlsSegmentFile)
+	s.mux.HandleFunc("GET /albums", s.listAlbums)
+	s.mux.HandleFunc("GET /albums/{id}", s.getAlbum)
+	s.mux.HandleFunc("GET /artists", s.listArtists)
+	s.mux.HandleFunc("GET /artists/{id}", s.getArtist)
+	s.mux.HandleFunc("GET /genres", s.listGenres)
+	s.mux.HandleFunc("GET /playlists", s.listPlaylists)
+	s.mux.HandleFunc("GET /playlists/{id}", s.getPlaylist)
+	s.mux.HandleFunc("GET /search", s.search)
+	s.mux.HandleFunc("GET /covers/{hash}", s.getCover)
+	s.mux.HandleFunc("GET /graphql", s.graphqlQuery)
+	s.mux.HandleFunc("POST /graphql", s.graphqlQuery)
+	s.mux.HandleFunc("GET /graphql/schema", s.graphqlSchema)
+	s.mux.HandleFunc("GET /rest/{method}", s.subsonic)
+	s.mux.HandleFunc("POST /rest/{method}", s.subsonic)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/tracks"
+	"github.com/ksuayan/go-tracks/transcode"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// audioTypes maps audio file extensions to their MIME types, for those
+// the mime package doesn't know or gets wrong for playback.
+var audioTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".m4b":  "audio/mp4",
+	".mp4":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".aif":  "audio/aiff",
+	".aiff": "audio/aiff",
+	".ape":  "audio/x-ape",
+	".wv":   "audio/x-wavpack",
+	".wma":  "audio/x-ms-wma",
+	".dsf":  "audio/x-dsf",
+	".dff":  "audio/x-dff",
+}
+
+// AudioType returns the MIME type of an audio file extension.
+func AudioType(extension string) string {
+	extension = strings.ToLower(extension)
+	if t, ok := audioTypes[extension]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(extension); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// streamTrack serves GET /tracks/{id}/stream: the track's audio file with
+// range support for seeking, or a transcode of it (see streamProfile).
+// Tracks split from a single-file rip by a CUE sheet are cut from the file
+// with ffmpeg.
+func (s *Server) streamTrack(w http.ResponseWriter, r *http.Request) {
+	track, path, ok := s.findAudio(w, r)
+	if !ok {
+		return
+	}
+	profile, err := s.streamProfile(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.serveAudio(w, r, track, path, profile)
+}
+
+// serveAudio sends a track's audio, transcoded with profile if it isn't nil
+// and the file needs it.
+func (s *Server) serveAudio(w http.ResponseWriter, r *http.Request, track map[string]interface{}, path string, profile *transcode.Profile) {
+	if tracks.IsCueTrack(track) {
+		// CUE tracks always go through ffmpeg to be cut from their file
+		if profile == nil {
+			lossless := transcode.Lossless(filepath.Ext(path))
+			profile = &lossless
+		}
+		s.streamTranscoded(w, r, track, path, *profile)
+		return
+	}
+	if profile != nil && profile.Needed(utils.SafeGetString(track, "codec"), utils.SafeGetInt(track, "bitrate")) {
+		s.streamTranscoded(w, r, track, path, *profile)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "audio file not found")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", AudioType(filepath.Ext(path)))
+	if hash := utils.SafeGetString(track, "fileHash"); hash != "" {
+		w.Header().Set("ETag", `"`+hash+`"`)
+	}
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// Errors looking up a track's audio.
+var (
+	errNoAudio        = errors.New("audio file not found")
+	errOutsideLibrary = errors.New("track is outside the library")
+)
+
+// findAudio looks up the track of the {id} path value and the path of its
+// audio file, writing the error response if that fails.
+func (s *Server) findAudio(w http.ResponseWriter, r *http.Request) (map[string]interface{}, string, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return nil, "", false
+	}
+	track, path, err := s.lookupAudio(r.Context(), id)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		writeError(w, http.StatusNotFound, "track not found")
+	case err == errNoAudio:
+		writeError(w, http.StatusNotFound, err.Error())
+	case err == errOutsideLibrary:
+		writeError(w, http.StatusForbidden, err.Error())
+	case err != nil:
+		writeServerError(w, err)
+	default:
+		return track, path, true
+	}
+	return nil, "", false
+}
+
+// lookupAudio returns a track and the path of its audio file, which must be
+// inside the library roots.
+func (s *Server) lookupAudio(ctx context.Context, id primitive.ObjectID) (map[string]interface{}, string, error) {
+	var track map[string]interface{}
+	err := s.db.Collection("tracks").FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(trackProjection)).Decode(&track)
+	if err != nil {
+		return nil, "", err
+	}
+	path, err := s.libraryPath(tracks.FilePath(track))
+	if os.IsNotExist(err) {
+		return nil, "", errNoAudio
+	}
+	if err != nil {
+		log.Printf("Refusing to stream track %s: %v\n", id.Hex(), err)
+		return nil, "", errOutsideLibrary
+	}
+	return track, path, nil
+}
+
+// streamTranscoded serves a track through ffmpeg in a profile, from the
+// transcode cache when it is there. Live output is produced as it is sent,
+// so ranges are only supported once cached; ffmpeg is stopped if the client
+// goes away.
+func (s *Server) streamTranscoded(w http.ResponseWriter, r *http.Request, track map[string]interface{}, path string, profile transcode.Profile) {
+	start, end := tracks.Segment(track)
+	job := transcode.Job{
+		Path:     path,
+		Start:    start,
+		End:      end,
+		Profile:  profile,
+		CacheKey: transcode.CacheKey(utils.SafeGetString(track, "fileHash"), utils.SafeGetInt(track, "cueTrack"), profile),
+	}
+	w.Header().Set("Content-Type", profile.ContentType)
+
+	if cached, ok := s.transcoder.Cached(job.CacheKey); ok {
+		file, err := os.Open(cached)
+		if err == nil {
+			defer file.Close()
+			if info, err := file.Stat(); err == nil {
+				w.Header().Set("ETag", `"`+job.CacheKey+`"`)
+				http.ServeContent(w, r, "", info.ModTime(), file)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Accept-Ranges", "none")
+	// Errors are logged by the transcoder; the status is already sent
+	s.transcoder.Transcode(r.Context(), w, job)
+}
+
+// streamProfile picks the transcoding profile of a request: ?profile=
+// ("raw" for the original file), else the profile of the client named by
+// ?client= or the X-Client header, else the default. nil means the
+// original file.
+func (s *Server) streamProfile(r *http.Request) (*transcode.Profile, error) {
+	name := r.URL.Query().Get("profile")
+	if name == "" {
+		client := r.URL.Query().Get("client")
+		if client == "" {
+			client = r.Header.Get("X-Client")
+		}
+		name = s.config.ClientProfiles[client]
+	}
+	if name == "" {
+		name = s.config.DefaultProfile
+	}
+	if name == "" || name == "raw" {
+		return nil, nil
+	}
+	profile, err := transcode.Find(name)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// libraryPath resolves a track's file path, following symlinks, and checks
+// that it lies inside one of the library roots.
+func (s *Server) libraryPath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range s.roots {
+		if resolved == root || strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not under a library root", resolved)
+}
+
+// resolveRoots returns the library roots as absolute paths with symlinks
+// resolved, so that they compare with resolved file paths. Roots that
+// can't be resolved, such as unmounted volumes, are skipped.
+func resolveRoots(roots []string) []string {
+	var resolved []string
+	for _, root := range roots {
+		path, err := filepath.EvalSymlinks(root)
+		if err == nil {
+			path, err = filepath.Abs(path)
+		}
+		if err != nil {
+			log.Printf("Skipping library root %s: %v\n", root, err)
+			continue
+		}
+		resolved = append(resolved, filepath.Clean(path))
+	}
+	return resolved
+}
@@ -0,0 +1,869 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/albums"
+	"github.com/ksuayan/go-tracks/playlists"
+	"github.com/ksuayan/go-tracks/transcode"
+	"github.com/ksuayan/go-tracks/users"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// The Subsonic API version implemented, and how the server names itself.
+const (
+	subsonicVersion = "1.16.1"
+	subsonicType    = "go-tracks"
+	serverVersion   = "0.1.0"
+)
+
+// Subsonic error codes.
+const (
+	subsonicGeneric      = 0
+	subsonicMissingParam = 10
+	subsonicBadAuth      = 40
+	subsonicNotFound     = 70
+)
+
+func (e *subsonicError) Error() string {
+	return e.Message
+}
+
+func subsonicFail(code int, format string, args ...interface{}) *subsonicError {
+	return &subsonicError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// subsonicHandler fills in the response to a Subsonic method.
+type subsonicHandler func(r *http.Request, res *subsonicResponse) error
+
+func (s *Server) subsonicHandlers() map[string]subsonicHandler {
+	return map[string]subsonicHandler{
+		"ping":                      func(*http.Request, *subsonicResponse) error { return nil },
+		"getLicense":                s.subsonicLicense,
+		"getOpenSubsonicExtensions": s.subsonicExtensions,
+		"getMusicFolders":           s.subsonicMusicFolders,
+		"getArtists":                s.subsonicArtists,
+		"getArtist":                 s.subsonicArtist,
+		"getAlbum":                  s.subsonicAlbum,
+		"getSong":                   s.subsonicSong,
+		"getAlbumList2":             s.subsonicAlbumList2,
+		"search3":                   s.subsonicSearch3,
+		"getPlaylists":              s.subsonicPlaylists,
+		"getPlaylist":               s.subsonicPlaylist,
+		"scrobble":                  s.subsonicScrobble,
+	}
+}
+
+// subsonic serves the Subsonic API under /rest/{method}[.view], for the
+// many players that speak it. Parameters come from the query or a POSTed
+// form; every request authenticates as a user of the users collection.
+// Errors are reported in the response body with status 200, as clients
+// expect.
+func (s *Server) subsonic(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	method := strings.TrimSuffix(r.PathValue("method"), ".view")
+	if err := s.subsonicAuth(r); err != nil {
+		s.writeSubsonic(w, r, nil, err)
+		return
+	}
+
+	switch method {
+	case "stream":
+		s.subsonicStream(w, r, true)
+		return
+	case "download":
+		s.subsonicStream(w, r, false)
+		return
+	case "getCoverArt":
+		s.subsonicCoverArt(w, r)
+		return
+	}
+	handler, ok := s.subsonicHandlers()[method]
+	if !ok {
+		s.writeSubsonic(w, r, nil, subsonicFail(subsonicNotFound, "unknown method %s", method))
+		return
+	}
+	res := newSubsonicResponse()
+	s.writeSubsonic(w, r, res, handler(r, res))
+}
+
+// subsonicAuth checks the u parameter's credentials: a token t, the MD5 of
+// the password and the salt s, or a password p.
+func (s *Server) subsonicAuth(r *http.Request) error {
+	name := r.FormValue("u")
+	if name == "" {
+		return subsonicFail(subsonicMissingParam, "required parameter u is missing")
+	}
+	token, salt, password := r.FormValue("t"), r.FormValue("s"), r.FormValue("p")
+	if (token == "" || salt == "") && password == "" {
+		return subsonicFail(subsonicMissingParam, "required authentication parameters are missing")
+	}
+	user, err := users.Find(s.db, name)
+	if err == mongo.ErrNoDocuments {
+		return subsonicFail(subsonicBadAuth, "wrong username or password")
+	}
+	if err != nil {
+		return err
+	}
+	if token != "" && salt != "" {
+		if user.CheckToken(token, salt) {
+			return nil
+		}
+	} else if user.CheckPassword(password) {
+		return nil
+	}
+	return subsonicFail(subsonicBadAuth, "wrong username or password")
+}
+
+func newSubsonicResponse() *subsonicResponse {
+	return &subsonicResponse{
+		XMLNS:         "http://subsonic.org/restapi",
+		Status:        "ok",
+		Version:       subsonicVersion,
+		Type:          subsonicType,
+		ServerVersion: serverVersion,
+		OpenSubsonic:  true,
+	}
+}
+
+// writeSubsonic sends a response, or err as a failed one, as XML or as
+// JSON with ?f=json.
+func (s *Server) writeSubsonic(w http.ResponseWriter, r *http.Request, res *subsonicResponse, err error) {
+	if err != nil {
+		var failure *subsonicError
+		if !errors.As(err, &failure) {
+			log.Printf("Error in Subsonic %s: %v\n", r.URL.Path, err)
+			failure = subsonicFail(subsonicGeneric, "internal error")
+		}
+		res = newSubsonicResponse()
+		res.Status = "failed"
+		res.Error = failure
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.FormValue("f") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]*subsonicResponse{"subsonic-response": res})
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) subsonicLicense(r *http.Request, res *subsonicResponse) error {
+	res.License = &license{Valid: true}
+	return nil
+}
+
+func (s *Server) subsonicExtensions(r *http.Request, res *subsonicResponse) error {
+	res.OpenSubsonicExtensions = []openSubsonicExtension{{Name: "formPost", Versions: []int{1}}}
+	return nil
+}
+
+// subsonicMusicFolders lists the library roots.
+func (s *Server) subsonicMusicFolders(r *http.Request, res *subsonicResponse) error {
+	folders := &musicFolders{MusicFolder: []musicFolder{}}
+	for i, root := range s.roots {
+		folders.MusicFolder = append(folders.MusicFolder, musicFolder{ID: i + 1, Name: filepath.Base(root)})
+	}
+	res.MusicFolders = folders
+	return nil
+}
+
+// subsonicArtists lists album artists, indexed by the first letter of their
+// sort name.
+func (s *Server) subsonicArtists(r *http.Request, res *subsonicResponse) error {
+	ctx := r.Context()
+	counts, err := s.albumCounts(ctx)
+	if err != nil {
+		return err
+	}
+	cursor, err := s.db.Collection("artists").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "sortName", Value: 1}, {Key: "nameKey", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	var found []map[string]interface{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return err
+	}
+
+	byLetter := make(map[string][]artistID3)
+	for _, doc := range found {
+		artist := subsonicArtist(doc)
+		if artist.AlbumCount = counts[doc["_id"].(primitive.ObjectID)]; artist.AlbumCount == 0 {
+			continue
+		}
+		letter := indexLetter(artist.SortName)
+		byLetter[letter] = append(byLetter[letter], artist)
+	}
+	index := &artistsID3{IgnoredArticles: "The", Index: []artistIndex{}}
+	for _, letter := range sortedKeys(byLetter) {
+		index.Index = append(index.Index, artistIndex{Name: letter, Artist: byLetter[letter]})
+	}
+	res.Artists = index
+	return nil
+}
+
+// albumCounts counts the albums of each album artist.
+func (s *Server) albumCounts(ctx context.Context) (map[primitive.ObjectID]int, error) {
+	cursor, err := s.db.Collection("albums").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$albumArtistID", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Count int                `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := make(map[primitive.ObjectID]int, len(groups))
+	for _, g := range groups {
+		counts[g.ID] = g.Count
+	}
+	return counts, nil
+}
+
+// subsonicArtist serves getArtist: an artist with their albums by year.
+func (s *Server) subsonicArtist(r *http.Request, res *subsonicResponse) error {
+	id, err := subsonicID(r)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := s.db.Collection("artists").FindOne(r.Context(), bson.M{"_id": id}).Decode(&doc); err != nil {
+		return subsonicFindError(err, "artist")
+	}
+	found, err := s.findAlbums(r.Context(), albums.ArtistFilter(id.Hex()), options.Find().SetSort(bson.D{{Key: "year", Value: 1}, {Key: "nameKey", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	artist := &artistWithAlbums{artistID3: subsonicArtist(doc), Album: found}
+	artist.AlbumCount = len(found)
+	res.Artist = artist
+	return nil
+}
+
+// subsonicAlbum serves getAlbum: an album with its songs in order.
+func (s *Server) subsonicAlbum(r *http.Request, res *subsonicResponse) error {
+	id, err := subsonicID(r)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := s.db.Collection("albums").FindOne(r.Context(), bson.M{"_id": id}).Decode(&doc); err != nil {
+		return subsonicFindError(err, "album")
+	}
+	songs, err := s.findSongs(r.Context(), bson.M{"albumID": id}, options.Find().SetSort(bson.D{
+		{Key: "disc", Value: 1}, {Key: "track", Value: 1}, {Key: "fileName", Value: 1}, {Key: "cueTrack", Value: 1},
+	}))
+	if err != nil {
+		return err
+	}
+	res.Album = &albumWithSongs{albumID3: subsonicAlbum(doc), Song: songs}
+	return nil
+}
+
+// subsonicSong serves getSong.
+func (s *Server) subsonicSong(r *http.Request, res *subsonicResponse) error {
+	id, err := subsonicID(r)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := s.db.Collection("tracks").FindOne(r.Context(), bson.M{"_id": id}, options.FindOne().SetProjection(trackProjection)).Decode(&doc); err != nil {
+		return subsonicFindError(err, "song")
+	}
+	song := subsonicSong(doc)
+	res.Song = &song
+	return nil
+}
+
+// subsonicAlbumList2 serves getAlbumList2, in the orders of ?type=.
+// Starring and rating aren't supported, so starred and highest are empty.
+func (s *Server) subsonicAlbumList2(r *http.Request, res *subsonicResponse) error {
+	size, err := intParam(r, "size", 10)
+	if err != nil {
+		return err
+	}
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		return err
+	}
+	size = min(max(size, 1), maxLimit)
+	opts := options.Find().SetSkip(int64(offset)).SetLimit(int64(size))
+	ctx := r.Context()
+
+	var found []albumID3
+	switch listType := r.FormValue("type"); listType {
+	case "random":
+		found, err = s.aggregateAlbums(ctx, mongo.Pipeline{{{Key: "$sample", Value: bson.M{"size": size}}}})
+	case "newest":
+		found, err = s.findAlbums(ctx, bson.M{}, opts.SetSort(bson.D{{Key: "_id", Value: -1}}))
+	case "alphabeticalByName":
+		found, err = s.findAlbums(ctx, bson.M{}, opts.SetSort(bson.D{{Key: "nameKey", Value: 1}, {Key: "_id", Value: 1}}))
+	case "alphabeticalByArtist":
+		found, err = s.findAlbums(ctx, bson.M{}, opts.SetSort(bson.D{{Key: "albumArtist", Value: 1}, {Key: "year", Value: 1}, {Key: "_id", Value: 1}}))
+	case "byYear":
+		from, err1 := intParam(r, "fromYear", 0)
+		to, err2 := intParam(r, "toYear", 9999)
+		if err := errors.Join(err1, err2); err != nil {
+			return err
+		}
+		order := 1
+		if from > to {
+			from, to, order = to, from, -1
+		}
+		filter := bson.M{"year": bson.M{"$gte": from, "$lte": to}}
+		found, err = s.findAlbums(ctx, filter, opts.SetSort(bson.D{{Key: "year", Value: order}, {Key: "nameKey", Value: 1}}))
+	case "byGenre":
+		genre := r.FormValue("genre")
+		if genre == "" {
+			return subsonicFail(subsonicMissingParam, "required parameter genre is missing")
+		}
+		found, err = s.findAlbums(ctx, bson.M{"stats.genres": exactly(genre)}, opts.SetSort(bson.D{{Key: "nameKey", Value: 1}}))
+	case "frequent", "recent":
+		found, err = s.playedAlbums(ctx, listType == "frequent", offset, size)
+	case "starred", "highest":
+	case "":
+		return subsonicFail(subsonicMissingParam, "required parameter type is missing")
+	default:
+		return subsonicFail(subsonicGeneric, "unknown list type %s", listType)
+	}
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		found = []albumID3{}
+	}
+	res.AlbumList2 = &albumList2{Album: found}
+	return nil
+}
+
+// playedAlbums returns the albums whose tracks were played most, or most
+// recently.
+func (s *Server) playedAlbums(ctx context.Context, frequent bool, offset, size int) ([]albumID3, error) {
+	group, sortBy := bson.M{"_id": "$albumID", "plays": bson.M{"$sum": "$playCount"}}, "plays"
+	if !frequent {
+		group, sortBy = bson.M{"_id": "$albumID", "played": bson.M{"$max": "$lastPlayed"}}, "played"
+	}
+	cursor, err := s.db.Collection("tracks").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"playCount": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: group}},
+		{{Key: "$sort", Value: bson.D{{Key: sortBy, Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$skip", Value: offset}},
+		{{Key: "$limit", Value: size}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(groups))
+	for i, g := range groups {
+		ids[i] = g.ID
+	}
+	found, err := s.findAlbums(ctx, bson.M{"_id": bson.M{"$in": ids}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	position := make(map[string]int, len(ids))
+	for i, id := range ids {
+		position[id.Hex()] = i
+	}
+	sort.Slice(found, func(i, j int) bool { return position[found[i].ID] < position[found[j].ID] })
+	return found, nil
+}
+
+// subsonicSearch3 serves search3: artists, albums and songs whose name or
+// title contains the query. An empty query matches everything, which
+// clients use to sync the whole library page by page.
+func (s *Server) subsonicSearch3(r *http.Request, res *subsonicResponse) error {
+	query := strings.Trim(strings.TrimSpace(r.FormValue("query")), `"`)
+	ctx := r.Context()
+	var pages [3]struct{ count, offset int }
+	for i, kind := range []string{"artist", "album", "song"} {
+		var err1, err2 error
+		pages[i].count, err1 = intParam(r, kind+"Count", 20)
+		pages[i].offset, err2 = intParam(r, kind+"Offset", 0)
+		if err := errors.Join(err1, err2); err != nil {
+			return err
+		}
+		pages[i].count = min(max(pages[i].count, 0), maxLimit)
+	}
+	page := func(i int, sortBy string) *options.FindOptions {
+		return options.Find().SetSkip(int64(pages[i].offset)).SetLimit(int64(pages[i].count)).
+			SetSort(bson.D{{Key: sortBy, Value: 1}, {Key: "_id", Value: 1}})
+	}
+	filter := func(field string) bson.M {
+		if query == "" {
+			return bson.M{}
+		}
+		return bson.M{field: containing(query)}
+	}
+
+	result := &searchResult3{Artist: []artistID3{}, Album: []albumID3{}, Song: []child{}}
+	if pages[0].count > 0 {
+		cursor, err := s.db.Collection("artists").Find(ctx, filter("name"), page(0, "nameKey"))
+		if err != nil {
+			return err
+		}
+		var found []map[string]interface{}
+		if err := cursor.All(ctx, &found); err != nil {
+			return err
+		}
+		for _, doc := range found {
+			result.Artist = append(result.Artist, subsonicArtist(doc))
+		}
+	}
+	if pages[1].count > 0 {
+		found, err := s.findAlbums(ctx, filter("name"), page(1, "nameKey"))
+		if err != nil {
+			return err
+		}
+		result.Album = append(result.Album, found...)
+	}
+	if pages[2].count > 0 {
+		found, err := s.findSongs(ctx, filter("title"), page(2, "title"))
+		if err != nil {
+			return err
+		}
+		result.Song = append(result.Song, found...)
+	}
+	res.SearchResult3 = result
+	return nil
+}
+
+// subsonicPlaylists serves getPlaylists.
+func (s *Server) subsonicPlaylists(r *http.Request, res *subsonicResponse) error {
+	found, err := playlists.FindAll(s.db)
+	if err != nil {
+		return err
+	}
+	list := &playlistList{Playlist: []subsonicPlaylist{}}
+	for i := range found {
+		p := subsonicPlaylistOf(&found[i])
+		p.SongCount = len(found[i].TrackIDs)
+		list.Playlist = append(list.Playlist, p)
+	}
+	res.Playlists = list
+	return nil
+}
+
+// subsonicPlaylist serves getPlaylist; smart playlists get their current
+// tracks, without storing them.
+func (s *Server) subsonicPlaylist(r *http.Request, res *subsonicResponse) error {
+	id, err := subsonicID(r)
+	if err != nil {
+		return err
+	}
+	playlist, err := playlists.Find(s.db, id.Hex())
+	if err != nil {
+		return subsonicFindError(err, "playlist")
+	}
+	var trackDocs []map[string]interface{}
+	if playlist.IsSmart() {
+		trackDocs, err = playlists.FindTracks(s.db, playlist)
+	} else {
+		trackDocs, err = playlists.LoadTracks(s.db, playlist.TrackIDs)
+	}
+	if err != nil {
+		return err
+	}
+	result := &playlistWithSongs{subsonicPlaylist: subsonicPlaylistOf(playlist), Entry: []child{}}
+	for _, doc := range trackDocs {
+		song := subsonicSong(doc)
+		result.Entry = append(result.Entry, song)
+		result.Duration += song.Duration
+	}
+	result.SongCount = len(result.Entry)
+	res.Playlist = result
+	return nil
+}
+
+// subsonicScrobble serves scrobble, counting a play of each id. Reports of
+// what is playing now (submission=false) are accepted and ignored.
+func (s *Server) subsonicScrobble(r *http.Request, res *subsonicResponse) error {
+	if r.FormValue("submission") == "false" {
+		return nil
+	}
+	ids := r.Form["id"]
+	if len(ids) == 0 {
+		return subsonicFail(subsonicMissingParam, "required parameter id is missing")
+	}
+	times := r.Form["time"]
+	for i, value := range ids {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return subsonicFail(subsonicNotFound, "song not found")
+		}
+		played := time.Now()
+		if i < len(times) {
+			if ms, err := strconv.ParseInt(times[i], 10, 64); err == nil {
+				played = time.UnixMilli(ms)
+			}
+		}
+		result, err := s.db.Collection("tracks").UpdateOne(r.Context(), bson.M{"_id": id}, bson.M{
+			"$inc": bson.M{"playCount": 1},
+			"$max": bson.M{"lastPlayed": played},
+		})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return subsonicFail(subsonicNotFound, "song not found")
+		}
+	}
+	return nil
+}
+
+// subsonicStream serves stream, transcoded as ?format= and ?maxBitRate=
+// ask, and download, which always sends the original file.
+func (s *Server) subsonicStream(w http.ResponseWriter, r *http.Request, transcoded bool) {
+	id, err := subsonicID(r)
+	if err != nil {
+		s.writeSubsonic(w, r, nil, err)
+		return
+	}
+	track, path, err := s.lookupAudio(r.Context(), id)
+	switch {
+	case err == mongo.ErrNoDocuments || err == errNoAudio:
+		s.writeSubsonic(w, r, nil, subsonicFail(subsonicNotFound, "song not found"))
+		return
+	case err == errOutsideLibrary:
+		s.writeSubsonic(w, r, nil, subsonicFail(subsonicGeneric, "%v", err))
+		return
+	case err != nil:
+		s.writeSubsonic(w, r, nil, err)
+		return
+	}
+	var profile *transcode.Profile
+	if transcoded {
+		if profile, err = s.subsonicProfile(r); err != nil {
+			s.writeSubsonic(w, r, nil, subsonicFail(subsonicGeneric, "%v", err))
+			return
+		}
+	}
+	s.serveAudio(w, r, track, path, profile)
+}
+
+// subsonicFormats are the profiles for each Subsonic ?format=, from the
+// highest bitrate down.
+var subsonicFormats = map[string][]string{
+	"mp3":  {"mp3-320"},
+	"opus": {"opus128", "opus64"},
+	"ogg":  {"opus128", "opus64"},
+	"aac":  {"aac256"},
+}
+
+// subsonicProfile picks the transcoding profile of a stream: the best of
+// ?format= within ?maxBitRate=, else the profile of the client ?c=, else
+// the default. "raw" streams the original file.
+func (s *Server) subsonicProfile(r *http.Request) (*transcode.Profile, error) {
+	format := r.FormValue("format")
+	if format == "raw" {
+		return nil, nil
+	}
+	maxBitRate, err := strconv.Atoi(r.FormValue("maxBitRate"))
+	if err != nil {
+		maxBitRate = 0
+	}
+
+	var profile *transcode.Profile
+	if format == "" {
+		name := s.config.ClientProfiles[r.FormValue("c")]
+		if name == "" {
+			name = s.config.DefaultProfile
+		}
+		if name != "" && name != "raw" {
+			p, err := transcode.Find(name)
+			if err != nil {
+				return nil, err
+			}
+			profile = &p
+		}
+		if maxBitRate == 0 || (profile != nil && profile.Bitrate > 0 && profile.Bitrate <= maxBitRate) {
+			return profile, nil
+		}
+		// Honour the bitrate limit in the codec of the profile, or in the
+		// most widely supported one
+		format = "mp3"
+		if profile != nil {
+			format = profile.SourceCodec
+		}
+	}
+	names, ok := subsonicFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+	for _, name := range names {
+		p := transcode.Profiles[name]
+		if maxBitRate == 0 || p.Bitrate <= maxBitRate {
+			return &p, nil
+		}
+	}
+	p := transcode.Profiles[names[len(names)-1]]
+	return &p, nil
+}
+
+// subsonicCoverArt serves getCoverArt. Cover IDs are the cover hashes.
+func (s *Server) subsonicCoverArt(w http.ResponseWriter, r *http.Request) {
+	hash := r.FormValue("id")
+	if hash == "" {
+		s.writeSubsonic(w, r, nil, subsonicFail(subsonicMissingParam, "required parameter id is missing"))
+		return
+	}
+	size := 0
+	if n, err := strconv.Atoi(r.FormValue("size")); err == nil && n > 0 {
+		size = min(max(n, minCoverSize), maxCoverSize)
+	}
+	s.serveCover(w, r, hash, size)
+}
+
+// findAlbums finds albums as Subsonic albums.
+func (s *Server) findAlbums(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]albumID3, error) {
+	cursor, err := s.db.Collection("albums").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAlbums(ctx, cursor)
+}
+
+func (s *Server) aggregateAlbums(ctx context.Context, pipeline mongo.Pipeline) ([]albumID3, error) {
+	cursor, err := s.db.Collection("albums").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAlbums(ctx, cursor)
+}
+
+func decodeAlbums(ctx context.Context, cursor *mongo.Cursor) ([]albumID3, error) {
+	var found []map[string]interface{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	result := []albumID3{}
+	for _, doc := range found {
+		result = append(result, subsonicAlbum(doc))
+	}
+	return result, nil
+}
+
+// findSongs finds tracks as Subsonic songs.
+func (s *Server) findSongs(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]child, error) {
+	cursor, err := s.db.Collection("tracks").Find(ctx, filter, opts.SetProjection(trackProjection))
+	if err != nil {
+		return nil, err
+	}
+	var found []map[string]interface{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	result := []child{}
+	for _, doc := range found {
+		result = append(result, subsonicSong(doc))
+	}
+	return result, nil
+}
+
+func subsonicArtist(doc map[string]interface{}) artistID3 {
+	sortName := utils.SafeGetString(doc, "sortName")
+	if sortName == "" {
+		sortName = utils.SafeGetString(doc, "name")
+	}
+	artist := artistID3{
+		ID:       hexID(doc["_id"]),
+		Name:     utils.SafeGetString(doc, "name"),
+		SortName: sortName,
+	}
+	if mb, ok := subdoc(doc, "musicbrainz"); ok {
+		artist.MBID = utils.SafeGetString(mb, "id")
+	}
+	return artist
+}
+
+func subsonicAlbum(doc map[string]interface{}) albumID3 {
+	album := albumID3{
+		ID:       hexID(doc["_id"]),
+		Name:     utils.SafeGetString(doc, "name"),
+		Artist:   utils.SafeGetString(doc, "albumArtist"),
+		ArtistID: hexID(doc["albumArtistID"]),
+		CoverArt: utils.SafeGetString(doc, "coverArtHash"),
+		Year:     utils.SafeGetInt(doc, "year"),
+	}
+	if id, ok := doc["_id"].(primitive.ObjectID); ok {
+		created := id.Timestamp()
+		album.Created = &created
+	}
+	if stats, ok := subdoc(doc, "stats"); ok {
+		album.SongCount = utils.SafeGetInt(stats, "trackCount")
+		album.Duration = int(time.Duration(utils.SafeGetInt64(stats, "duration")).Seconds())
+		if album.Year == 0 {
+			album.Year = utils.SafeGetInt(stats, "yearMin")
+		}
+		if genres := storedStrings(stats["genres"]); len(genres) > 0 {
+			album.Genre = genres[0]
+		}
+	}
+	return album
+}
+
+func subsonicSong(doc map[string]interface{}) child {
+	extension := utils.SafeGetString(doc, "fileExtension")
+	song := child{
+		ID:          hexID(doc["_id"]),
+		Parent:      hexID(doc["albumID"]),
+		Title:       utils.SafeGetString(doc, "title"),
+		Album:       utils.SafeGetString(doc, "album"),
+		Artist:      utils.SafeGetString(doc, "artist"),
+		Track:       utils.SafeGetInt(doc, "track"),
+		DiscNumber:  utils.SafeGetInt(doc, "disc"),
+		Year:        utils.SafeGetInt(doc, "year"),
+		Genre:       utils.SafeGetString(doc, "genre"),
+		CoverArt:    utils.SafeGetString(doc, "coverArtHash"),
+		Size:        utils.SafeGetInt64(doc, "size"),
+		ContentType: AudioType(extension),
+		Suffix:      strings.TrimPrefix(strings.ToLower(extension), "."),
+		Duration:    int(time.Duration(utils.SafeGetInt64(doc, "length")).Seconds()),
+		BitRate:     utils.SafeGetInt(doc, "bitrate") / 1000,
+		Path:        filepath.ToSlash(filepath.Join(utils.SafeGetString(doc, "subDir"), utils.SafeGetString(doc, "fileName"))),
+		PlayCount:   utils.SafeGetInt64(doc, "playCount"),
+		AlbumID:     hexID(doc["albumID"]),
+		ArtistID:    hexID(doc["artistID"]),
+		Type:        "music",
+	}
+	if played, ok := doc["lastPlayed"].(primitive.DateTime); ok {
+		t := played.Time()
+		song.Played = &t
+	}
+	if created, ok := doc["creationDate"].(primitive.DateTime); ok {
+		t := created.Time()
+		song.Created = &t
+	}
+	return song
+}
+
+func subsonicPlaylistOf(p *playlists.Playlist) subsonicPlaylist {
+	created := p.ImportedAt
+	if created.IsZero() {
+		created = p.ID.Timestamp()
+	}
+	changed := created
+	if p.MaterializedAt.After(changed) {
+		changed = p.MaterializedAt
+	}
+	return subsonicPlaylist{ID: p.ID.Hex(), Name: p.Name, Created: created, Changed: changed}
+}
+
+// subsonicID parses the id parameter. Malformed IDs can't name anything,
+// so they are reported as not found.
+func subsonicID(r *http.Request) (primitive.ObjectID, error) {
+	value := r.FormValue("id")
+	if value == "" {
+		return primitive.NilObjectID, subsonicFail(subsonicMissingParam, "required parameter id is missing")
+	}
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return id, subsonicFail(subsonicNotFound, "%s not found", value)
+	}
+	return id, nil
+}
+
+func subsonicFindError(err error, what string) error {
+	if err == mongo.ErrNoDocuments {
+		return subsonicFail(subsonicNotFound, "%s not found", what)
+	}
+	return err
+}
+
+func intParam(r *http.Request, name string, fallback int) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, subsonicFail(subsonicGeneric, "bad %s %q", name, value)
+	}
+	return n, nil
+}
+
+// indexLetter is the index entry of a sort name: its first letter, or # for
+// names starting with anything else.
+func indexLetter(sortName string) string {
+	for _, r := range utils.FoldDiacritics(sortName) {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		return "#"
+	}
+	return "#"
+}
+
+func hexID(value interface{}) string {
+	if id, ok := value.(primitive.ObjectID); ok && !id.IsZero() {
+		return id.Hex()
+	}
+	return ""
+}
+
+// subdoc returns an embedded document, however it was decoded.
+func subdoc(doc map[string]interface{}, key string) (map[string]interface{}, bool) {
+	switch m := doc[key].(type) {
+	case map[string]interface{}:
+		return m, true
+	case primitive.M:
+		return m, true
+	}
+	return nil, false
+}
+
+func storedStrings(value interface{}) []string {
+	var values []interface{}
+	switch v := value.(type) {
+	case primitive.A:
+		values = v
+	case []interface{}:
+		values = v
+	}
+	var result []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
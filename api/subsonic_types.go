@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Subsonic response elements. Each field carries both XML and JSON tags,
+// since clients ask for either with ?f=.
+
+type subsonicResponse struct {
+	XMLName       xml.Name `xml:"subsonic-response" json:"-"`
+	XMLNS         string   `xml:"xmlns,attr" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error                  *subsonicError          `xml:"error,omitempty" json:"error,omitempty"`
+	License                *license                `xml:"license,omitempty" json:"license,omitempty"`
+	MusicFolders           *musicFolders           `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Artists                *artistsID3             `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist                 *artistWithAlbums       `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album                  *albumWithSongs         `xml:"album,omitempty" json:"album,omitempty"`
+	Song                   *child                  `xml:"song,omitempty" json:"song,omitempty"`
+	SearchResult3          *searchResult3          `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Playlists              *playlistList           `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist               *playlistWithSongs      `xml:"playlist,omitempty" json:"playlist,omitempty"`
+	AlbumList2             *albumList2             `xml:"albumList2,omitempty" json:"albumList2,omitempty"`
+	OpenSubsonicExtensions []openSubsonicExtension `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+}
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type license struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type openSubsonicExtension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions" json:"versions"`
+}
+
+type musicFolders struct {
+	MusicFolder []musicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type musicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type artistsID3 struct {
+	IgnoredArticles string        `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []artistIndex `xml:"index" json:"index"`
+}
+
+type artistIndex struct {
+	Name   string      `xml:"name,attr" json:"name"`
+	Artist []artistID3 `xml:"artist" json:"artist"`
+}
+
+type artistID3 struct {
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+	CoverArt   string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SortName   string `xml:"sortName,attr,omitempty" json:"sortName,omitempty"`
+	MBID       string `xml:"musicBrainzId,attr,omitempty" json:"musicBrainzId,omitempty"`
+}
+
+type artistWithAlbums struct {
+	artistID3
+	Album []albumID3 `xml:"album" json:"album"`
+}
+
+type albumID3 struct {
+	ID        string     `xml:"id,attr" json:"id"`
+	Name      string     `xml:"name,attr" json:"name"`
+	Artist    string     `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	ArtistID  string     `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string     `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int        `xml:"songCount,attr" json:"songCount"`
+	Duration  int        `xml:"duration,attr" json:"duration"`
+	PlayCount int64      `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Created   *time.Time `xml:"created,attr,omitempty" json:"created,omitempty"`
+	Year      int        `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre     string     `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+}
+
+type albumWithSongs struct {
+	albumID3
+	Song []child `xml:"song" json:"song"`
+}
+
+// child is a song in Subsonic terms.
+type child struct {
+	ID          string     `xml:"id,attr" json:"id"`
+	Parent      string     `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool       `xml:"isDir,attr" json:"isDir"`
+	Title       string     `xml:"title,attr" json:"title"`
+	Album       string     `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string     `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track       int        `xml:"track,attr,omitempty" json:"track,omitempty"`
+	Year        int        `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre       string     `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt    string     `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size        int64      `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType string     `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string     `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration    int        `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	BitRate     int        `xml:"bitRate,attr,omitempty" json:"bitRate,omitempty"`
+	Path        string     `xml:"path,attr,omitempty" json:"path,omitempty"`
+	PlayCount   int64      `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Played      *time.Time `xml:"played,attr,omitempty" json:"played,omitempty"`
+	DiscNumber  int        `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	Created     *time.Time `xml:"created,attr,omitempty" json:"created,omitempty"`
+	AlbumID     string     `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID    string     `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type        string     `xml:"type,attr" json:"type"`
+}
+
+type searchResult3 struct {
+	Artist []artistID3 `xml:"artist" json:"artist"`
+	Album  []albumID3  `xml:"album" json:"album"`
+	Song   []child     `xml:"song" json:"song"`
+}
+
+type albumList2 struct {
+	Album []albumID3 `xml:"album" json:"album"`
+}
+
+type playlistList struct {
+	Playlist []subsonicPlaylist `xml:"playlist" json:"playlist"`
+}
+
+type subsonicPlaylist struct {
+	ID        string    `xml:"id,attr" json:"id"`
+	Name      string    `xml:"name,attr" json:"name"`
+	Comment   string    `xml:"comment,attr,omitempty" json:"comment,omitempty"`
+	Owner     string    `xml:"owner,attr,omitempty" json:"owner,omitempty"`
+	Public    bool      `xml:"public,attr" json:"public"`
+	SongCount int       `xml:"songCount,attr" json:"songCount"`
+	Duration  int       `xml:"duration,attr" json:"duration"`
+	Created   time.Time `xml:"created,attr" json:"created"`
+	Changed   time.Time `xml:"changed,attr" json:"changed"`
+}
+
+type playlistWithSongs struct {
+	subsonicPlaylist
+	Entry []child `xml:"entry" json:"entry"`
+}
@@ -5,69 +5,135 @@ import (
 	"log"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/ksuayan/go-tracks/mongodb"
 	"github.com/ksuayan/go-tracks/musicbrainz"
-	"github.com/ksuayan/go-tracks/utils"
 )
 
-// Update Artist in the database and return the artist ID
-func UpdateArtists(db *mongo.Database, track map[string] interface {}, mbEnabled bool) (string, error) {
+// Update Artist in the database and return the artist ID.
+// The track's artist string is split into individual credited artists
+// ("A feat. B", "A & B", multi-valued tags), each of which is upserted; the
+// resolved credits are stored on the track map as "artistCredits" and the ID
+// of the first main artist is returned.
+// MusicBrainz artist data is fetched only when mb is non-nil.
+func UpdateArtists(db *mongo.Database, track map[string] interface {}, mb *musicbrainz.Client) (string, error) {
 
 	artist := track["artist"].(string)
 
-	tags, ok := utils.SafeGet(track, "ffprobe", "format", "tags")
-	if !ok {
-		log.Printf("No tags found for %s\n", artist)
+	credits := ParseCredits(CreditSourceFromTrack(track))
+	if len(credits) == 0 {
+		credits = []Credit{{Name: artist, Role: RoleMain}}
+	}
+	credits, err := ResolveCredits(db, credits, mb)
+	if err != nil {
+		return "", err
 	}
 
-	artistsCollection := db.Collection("artists")
-	artistFilter := bson.M{"name": artist}
-	var artistUpdate bson.M
+	track["artistCredits"] = credits
+	return credits[0].ArtistID.Hex(), nil
+}
 
-	if (mbEnabled) {
-		mbArtistID, ok := tags.(map[string]interface{})["MusicBrainz Artist Id"].(string)
-		if !ok {
-			log.Printf("Error: MusicBrainz Artist Id not found or not a string")
+// ResolveCredits upserts every artist in the credit and fills in their IDs.
+func ResolveCredits(db *mongo.Database, credits []Credit, mb *musicbrainz.Client) ([]Credit, error) {
+	for i := range credits {
+		artistID, err := upsertArtist(db, credits[i], mb)
+		if err != nil {
+			return nil, err
 		}
+		credits[i].ArtistID = mongodb.SafeObjectIDFromHex(artistID)
+	}
+	return credits, nil
+}
+
+// upsertArtist upserts a single artist. Artists are identified by their
+// MusicBrainz ID when known, falling back to the normalized name otherwise.
+// The first spelling seen becomes the display name; every spelling is kept
+// in `aliases`.
+func upsertArtist(db *mongo.Database, credit Credit, mb *musicbrainz.Client) (string, error) {
+	artist := credit.Name
+	mbArtistID := credit.MBID
+	nameKey := NormalizeName(artist)
+
+	artistsCollection := db.Collection("artists")
+	artistFilter, err := artistFilter(artistsCollection, artist, nameKey, mbArtistID)
+	if err != nil {
+		return "", err
+	}
+
+	// nameKey is only set on insert: an artist found by one of its aliases
+	// keeps the key of its own name
+	set := bson.M{}
+	setOnInsert := bson.M{"name": artist, "nameKey": nameKey}
+	if credit.SortName != "" {
+		set["sortName"] = credit.SortName
+	} else {
+		setOnInsert["sortName"] = SortName(artist)
+	}
+	if mbArtistID != "" {
+		set["musicbrainz.id"] = mbArtistID
+	}
 
-		mbArtistData, err := musicbrainz.FetchMusicBrainz("artist", mbArtistID)
+	if mb != nil && mbArtistID != "" {
+		mbArtistData, err := mb.LookupArtist(mbArtistID)
 		if err != nil {
 			log.Printf("Error fetching MusicBrainz Artist Data for %s: %v\n", artist, err)
+		} else {
+			delete(set, "musicbrainz.id")
+			set["musicbrainz"] = mbArtistData
+			if mbArtistData.SortName != "" {
+				delete(setOnInsert, "sortName")
+				set["sortName"] = mbArtistData.SortName
+			}
 		}
-
-		artistUpdate = bson.M{"$set": bson.M{
-			"name": artist, 
-			"musicbrainz": mbArtistData,
-		}}
-	} else {
-		artistUpdate = bson.M{"$set": bson.M{
-			"name": artist, 
-		}}
 	}
 
-	artistOpts := options.Update().SetUpsert(true)
-	artistRes, err := artistsCollection.UpdateOne(context.Background(), artistFilter, artistUpdate, artistOpts)
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": setOnInsert,
+		"$addToSet":    bson.M{"aliases": artist},
+	}
+	if len(set) == 0 {
+		delete(update, "$set")
+	}
+	artistID, upserted, err := mongodb.UpsertID(artistsCollection, artistFilter, update)
 	if err != nil {
 		return "", err
 	}
-
-	var artistID string
-	if artistRes.UpsertedID != nil {
-		artistID = mongodb.ToHex(artistRes.UpsertedID.(primitive.ObjectID))
+	if upserted {
 		log.Printf("Upserted Artist: %s\n", artist)
 	} else {
-		var existingArtist bson.M
-		err := artistsCollection.FindOne(context.Background(), artistFilter).Decode(&existingArtist)
+		// Artists stored before names were normalized have no key yet
+		_, err := artistsCollection.UpdateOne(context.Background(),
+			bson.M{"_id": mongodb.SafeObjectIDFromHex(artistID), "nameKey": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"nameKey": nameKey}})
 		if err != nil {
 			return "", err
 		}
-		artistID = mongodb.ToHex(existingArtist["_id"].(primitive.ObjectID))
-		// log.Printf("Existing Artist: %s\n", artist)
 	}
 
 	return artistID, nil
 }
+
+// artistFilter picks the identity used to upsert an artist. An existing
+// artist that was matched by name before its MBID was known is adopted
+// rather than duplicated. Artists stored before names were normalized are
+// found by their exact name, and artists merged into another by the
+// aliases they left on it.
+func artistFilter(artistsCollection *mongo.Collection, artist, nameKey, mbArtistID string) (bson.M, error) {
+	byName := bson.M{"$or": []bson.M{{"nameKey": nameKey}, {"name": artist}, {"aliases": artist}}}
+	if mbArtistID == "" {
+		return byName, nil
+	}
+
+	byID := bson.M{"musicbrainz.id": mbArtistID}
+	found, err := mongodb.Exists(artistsCollection, byID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return byID, nil
+	}
+	byName["musicbrainz.id"] = bson.M{"$exists": false}
+	return byName, nil
+}
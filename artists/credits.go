@@ -0,0 +1,229 @@
+package artists
+
+import (
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/ksuayan/go-tracks/musicbrainz"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// Roles an artist can be credited with on a track or album.
+const (
+	RoleMain     = "main"
+	RoleFeatured = "featured"
+	RoleRemixer  = "remixer"
+)
+
+// Credit is one artist in an ordered artist credit. As in MusicBrainz, the
+// join phrase is the text printed after the artist's name, so concatenating
+// name and join phrase over the main and featured credits gives back the
+// display string.
+type Credit struct {
+	ArtistID   primitive.ObjectID `bson:"artistId"`
+	Name       string             `bson:"name"`
+	JoinPhrase string             `bson:"joinPhrase,omitempty"`
+	Role       string             `bson:"role"`
+	MBID       string             `bson:"mbid,omitempty"`
+	SortName   string             `bson:"sortName,omitempty"`
+}
+
+// CreditSource is the tag data an artist credit is parsed from.
+type CreditSource struct {
+	Artist   string   // display artist, e.g. "A & B feat. C"
+	SortName string   // sort name tag for the display artist
+	Artists  []string // individual names from a multi-valued ARTIST/ARTISTS tag
+	Title    string   // track title, for "(feat. X)" and "(X Remix)"
+	Remixers []string // REMIXER tag values
+	MBIDs    []string // artist MBIDs, in credit order
+}
+
+var (
+	featuringPattern = regexp.MustCompile(`(?i)\s*[(\[]?\s*\b(?:feat\.?|featuring|ft\.?)\s+(.+?)\s*[)\]]?\s*$`)
+	// In titles the featuring clause must be bracketed ("Feat of Clay").
+	titleFeaturingPattern = regexp.MustCompile(`(?i)[(\[]\s*(?:feat\.?|featuring|ft\.?)\s+([^)\]]+?)\s*[)\]]`)
+	remixPattern          = regexp.MustCompile(`(?i)[(\[]\s*([^()\[\]]+?)\s+(?:remix|rmx)\s*[)\]]`)
+	// Separators that are never part of a name.
+	strongSeparator = regexp.MustCompile(`\s*;\s*|\s+/\s+`)
+	// Separators that may also appear inside a name ("Simon & Garfunkel").
+	weakSeparator = regexp.MustCompile(`\s*;\s*|\s+/\s+|\s+&\s+|\s+vs\.?\s+`)
+	// Commas are only split on when artist MBIDs confirm the count.
+	commaSeparator = regexp.MustCompile(`\s*;\s*|\s+/\s+|\s+&\s+|\s+vs\.?\s+|,\s+`)
+)
+
+// Remix descriptions that do not name a remixer.
+var genericRemixes = map[string]bool{
+	"original": true, "extended": true, "radio": true, "club": true,
+	"dub": true, "album": true, "single": true, "instrumental": true,
+}
+
+// CreditSourceFromTrack collects credit data from a track document.
+func CreditSourceFromTrack(track map[string]interface{}) CreditSource {
+	tags := utils.TrackTags(track)
+
+	src := CreditSource{
+		Artist:   utils.SafeGetString(track, "artist"),
+		SortName: utils.FindTagValue(tags, "artistsort", "artist-sort", "sort_artist"),
+		Title:    utils.SafeGetString(track, "title"),
+		MBIDs:    musicbrainz.IDsFromTrack(track).ArtistIDs,
+	}
+	// Picard writes ARTISTS; multiple Vorbis ARTIST fields come through
+	// ffprobe joined with ";".
+	if artists := utils.FindTagValue(tags, "artists"); artists != "" {
+		src.Artists = splitOn(strongSeparator, artists)
+	} else if artist := utils.FindTagValue(tags, "artist"); strings.Contains(artist, ";") {
+		src.Artists = splitOn(strongSeparator, artist)
+	}
+	if remixer := utils.FindTagValue(tags, "remixer", "mixartist"); remixer != "" {
+		src.Remixers = splitOn(strongSeparator, remixer)
+	}
+	return src
+}
+
+// ParseCredits splits an artist string into its individual artists, in
+// order: main artists, then featured artists, then remixers.
+func ParseCredits(src CreditSource) []Credit {
+	mainPart, featuredPart := splitFeaturing(src.Artist)
+	mainNames, featuredNames := splitCredit(mainPart, featuredPart, len(src.MBIDs))
+
+	// A multi-valued tag names each artist exactly; use the display string
+	// only to tell featured artists from main ones.
+	if len(src.Artists) > 1 {
+		mainNames, featuredNames = nil, nil
+		for _, name := range src.Artists {
+			if featuredPart != "" && containsFold(featuredPart, name) {
+				featuredNames = append(featuredNames, name)
+			} else {
+				mainNames = append(mainNames, name)
+			}
+		}
+	}
+
+	// "Title (feat. X)" credits featured artists in the title instead
+	for _, match := range titleFeaturingPattern.FindAllStringSubmatch(src.Title, -1) {
+		for _, name := range splitOn(weakSeparator, match[1]) {
+			if !containsName(mainNames, name) && !containsName(featuredNames, name) {
+				featuredNames = append(featuredNames, name)
+			}
+		}
+	}
+
+	var credits []Credit
+	credits = appendCredits(credits, mainNames, RoleMain)
+	if len(featuredNames) > 0 && len(credits) > 0 {
+		credits[len(credits)-1].JoinPhrase = " feat. "
+	}
+	credits = appendCredits(credits, featuredNames, RoleFeatured)
+
+	// MBIDs line up with the credit only when the counts agree
+	if len(src.MBIDs) == len(credits) {
+		for i := range credits {
+			credits[i].MBID = src.MBIDs[i]
+		}
+	}
+	// The sort name tag describes the whole display artist
+	if len(credits) == 1 {
+		credits[0].SortName = src.SortName
+	}
+
+	remixers := src.Remixers
+	for _, match := range remixPattern.FindAllStringSubmatch(src.Title, -1) {
+		if !genericRemixes[strings.ToLower(match[1])] {
+			remixers = append(remixers, splitOn(weakSeparator, match[1])...)
+		}
+	}
+	for _, name := range remixers {
+		if !containsCredit(credits, name, RoleRemixer) {
+			credits = append(credits, Credit{Name: name, Role: RoleRemixer})
+		}
+	}
+
+	return credits
+}
+
+// CreditedName joins the main and featured credits into a display string.
+func CreditedName(credits []Credit) string {
+	name := ""
+	for _, credit := range credits {
+		if credit.Role != RoleRemixer {
+			name += credit.Name + credit.JoinPhrase
+		}
+	}
+	return strings.TrimSpace(name)
+}
+
+// splitFeaturing separates "A feat. B" into "A" and "B".
+func splitFeaturing(s string) (string, string) {
+	loc := featuringPattern.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return strings.TrimSpace(s), ""
+	}
+	return strings.TrimSpace(s[:loc[0]]), strings.TrimSpace(s[loc[2]:loc[3]])
+}
+
+// splitCredit splits the main and featured parts into names. When artist
+// MBIDs are known, the split whose name count matches them wins; otherwise
+// "&" and "vs." are treated as separators but commas are not.
+func splitCredit(mainPart, featuredPart string, expected int) ([]string, []string) {
+	separators := []*regexp.Regexp{strongSeparator, weakSeparator, commaSeparator}
+	if expected > 0 {
+		for _, mainSep := range separators {
+			for _, featuredSep := range separators {
+				mainNames := splitOn(mainSep, mainPart)
+				featuredNames := splitOn(featuredSep, featuredPart)
+				if len(mainNames)+len(featuredNames) == expected {
+					return mainNames, featuredNames
+				}
+			}
+		}
+	}
+	return splitOn(weakSeparator, mainPart), splitOn(weakSeparator, featuredPart)
+}
+
+func splitOn(separator *regexp.Regexp, s string) []string {
+	var names []string
+	for _, name := range separator.Split(s, -1) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func appendCredits(credits []Credit, names []string, role string) []Credit {
+	for i, name := range names {
+		joinPhrase := ""
+		switch {
+		case i == len(names)-2:
+			joinPhrase = " & "
+		case i < len(names)-2:
+			joinPhrase = ", "
+		}
+		credits = append(credits, Credit{Name: name, JoinPhrase: joinPhrase, Role: role})
+	}
+	return credits
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCredit(credits []Credit, name, role string) bool {
+	for _, credit := range credits {
+		if credit.Role == role && strings.EqualFold(credit.Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package artists
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/mongodb"
+)
+
+// Artist is an artist document as read for merging.
+type Artist struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Name        string             `bson:"name"`
+	NameKey     string             `bson:"nameKey"`
+	SortName    string             `bson:"sortName"`
+	Aliases     []string           `bson:"aliases"`
+	MusicBrainz struct {
+		ID string `bson:"id"`
+	} `bson:"musicbrainz"`
+}
+
+// Duplicates groups artists whose names normalize to the same key. The
+// first artist of each group is the suggested merge target: the one with a
+// MusicBrainz ID, else the oldest. Groups with more than one distinct MBID
+// are different artists sharing a name and are left out.
+func Duplicates(db *mongo.Database) ([][]Artist, error) {
+	cursor, err := db.Collection("artists").Find(context.Background(), bson.M{},
+		options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var all []Artist
+	if err := cursor.All(context.Background(), &all); err != nil {
+		return nil, err
+	}
+
+	// Keys are recomputed since artists stored before normalization have none
+	byKey := make(map[string][]Artist)
+	var keys []string
+	for _, artist := range all {
+		key := NormalizeName(artist.Name)
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], artist)
+	}
+
+	var groups [][]Artist
+	for _, key := range keys {
+		group := byKey[key]
+		if len(group) < 2 {
+			continue
+		}
+		mbids := make(map[string]bool)
+		target := 0
+		for i, artist := range group {
+			if artist.MusicBrainz.ID != "" {
+				if len(mbids) == 0 {
+					target = i
+				}
+				mbids[artist.MusicBrainz.ID] = true
+			}
+		}
+		if len(mbids) > 1 {
+			log.Printf("Not merging %q: %d different MusicBrainz artists\n", key, len(mbids))
+			continue
+		}
+		group[0], group[target] = group[target], group[0]
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// Merge folds the source artists into the target: their names and aliases
+// become aliases of the target, every track and album credit pointing at a
+// source is re-pointed to the target, and the sources are deleted.
+func Merge(db *mongo.Database, targetID string, sourceIDs []string) error {
+	artistsCollection := db.Collection("artists")
+	target := mongodb.SafeObjectIDFromHex(targetID)
+
+	var targetArtist Artist
+	if err := artistsCollection.FindOne(context.Background(), bson.M{"_id": target}).Decode(&targetArtist); err != nil {
+		return fmt.Errorf("error loading artist %s: %w", targetID, err)
+	}
+
+	for _, sourceID := range sourceIDs {
+		source := mongodb.SafeObjectIDFromHex(sourceID)
+		if source == target {
+			continue
+		}
+
+		var sourceArtist Artist
+		if err := artistsCollection.FindOne(context.Background(), bson.M{"_id": source}).Decode(&sourceArtist); err != nil {
+			return fmt.Errorf("error loading artist %s: %w", sourceID, err)
+		}
+		if sourceArtist.MusicBrainz.ID != "" && targetArtist.MusicBrainz.ID != "" &&
+			sourceArtist.MusicBrainz.ID != targetArtist.MusicBrainz.ID {
+			return fmt.Errorf("artists %s and %s have different MusicBrainz IDs", targetID, sourceID)
+		}
+
+		if err := repointArtist(db, source, target); err != nil {
+			return err
+		}
+
+		aliases := append([]string{sourceArtist.Name}, sourceArtist.Aliases...)
+		update := bson.M{
+			"$addToSet": bson.M{"aliases": bson.M{"$each": aliases}},
+			"$set":      bson.M{"nameKey": NormalizeName(targetArtist.Name)},
+		}
+		if targetArtist.MusicBrainz.ID == "" && sourceArtist.MusicBrainz.ID != "" {
+			update["$set"].(bson.M)["musicbrainz.id"] = sourceArtist.MusicBrainz.ID
+			targetArtist.MusicBrainz.ID = sourceArtist.MusicBrainz.ID
+		}
+		if _, err := artistsCollection.UpdateOne(context.Background(), bson.M{"_id": target}, update); err != nil {
+			return err
+		}
+		if _, err := artistsCollection.DeleteOne(context.Background(), bson.M{"_id": source}); err != nil {
+			return err
+		}
+		log.Printf("Merged artist %s into %s\n", sourceArtist.Name, targetArtist.Name)
+	}
+	return nil
+}
+
+// repointArtist moves every reference to an artist onto another one.
+func repointArtist(db *mongo.Database, from, to primitive.ObjectID) error {
+	ctx := context.Background()
+	tracksCollection := db.Collection("tracks")
+	albumsCollection := db.Collection("albums")
+
+	if _, err := tracksCollection.UpdateMany(ctx, bson.M{"artistID": from}, bson.M{"$set": bson.M{"artistID": to}}); err != nil {
+		return err
+	}
+
+	if _, err := albumsCollection.UpdateMany(ctx, bson.M{"albumArtistID": from}, bson.M{"$set": bson.M{"albumArtistID": to}}); err != nil {
+		return err
+	}
+
+	creditFilter := bson.M{"artistCredits.artistId": from}
+	creditUpdate := bson.M{"$set": bson.M{"artistCredits.$[credit].artistId": to}}
+	creditOpts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"credit.artistId": from}},
+	})
+	for _, collection := range []*mongo.Collection{tracksCollection, albumsCollection} {
+		if _, err := collection.UpdateMany(ctx, creditFilter, creditUpdate, creditOpts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package artists
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+var (
+	leadingArticle  = regexp.MustCompile(`^the\s+`)
+	trailingArticle = regexp.MustCompile(`(?i),\s*the\s*$`)
+)
+
+// NormalizeName returns the key used to identify an artist by name, so that
+// "The Beatles", "Beatles, The", "the beatles" and "Beatles" are one artist.
+// On top of utils.FoldKey it drops a leading or trailing "The".
+func NormalizeName(name string) string {
+	key := trailingArticle.ReplaceAllString(name, "")
+	key = utils.FoldKey(key)
+	key = leadingArticle.ReplaceAllString(key, "")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return utils.FoldKey(name)
+	}
+	return key
+}
+
+// SortName derives a sort name by moving a leading "The" to the end, as in
+// "Beatles, The". Sort names from tags or MusicBrainz are preferred.
+func SortName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) > 4 && strings.EqualFold(name[:4], "the ") {
+		return strings.TrimSpace(name[4:]) + ", " + name[:3]
+	}
+	return name
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/albums"
+	"github.com/ksuayan/go-tracks/mongodb"
+)
+
+const albumsUsage = `Usage: gt albums <command> [flags]
+
+Commands:
+  migrate             re-file tracks under the current album identity and merge fragmented albums
+  stats               recompute album aggregates and box sets
+  incomplete [-mixed] list albums with missing tracks, or with -mixed, of mixed quality
+  show <id>           list the tracks of an album or box set disc by disc
+  boxsets             list box sets and the albums they contain`
+
+// runAlbums implements `gt albums`.
+func runAlbums(args []string) {
+	fs := flag.NewFlagSet("albums", flag.ExitOnError)
+	mixed := fs.Bool("mixed", false, "list albums mixing formats, bit depths or sample rates")
+	fs.Usage = func() {
+		fmt.Println(albumsUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	switch command {
+	case "migrate":
+		moved, removed, err := albums.Migrate(db)
+		if err != nil {
+			fmt.Printf("Error migrating albums: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Tracks moved: %d, albums removed: %d\n", moved, removed)
+
+	case "stats":
+		updated, err := albums.UpdateStats(db)
+		if err != nil {
+			fmt.Printf("Error updating album stats: %v\n", err)
+			os.Exit(1)
+		}
+		boxSets, err := albums.UpdateBoxSets(db)
+		if err != nil {
+			fmt.Printf("Error updating box sets: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Albums updated: %d, box sets: %d\n", updated, boxSets)
+
+	case "incomplete":
+		find := albums.FindIncomplete
+		if *mixed {
+			find = albums.FindMixedQuality
+		}
+		summaries, err := find(db)
+		if err != nil {
+			fmt.Printf("Error reading albums: %v\n", err)
+			os.Exit(1)
+		}
+		for _, album := range summaries {
+			printAlbumSummary(album, *mixed)
+		}
+		fmt.Printf("%d albums\n", len(summaries))
+
+	case "show":
+		if fs.NArg() < 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		id := mongodb.SafeObjectIDFromHex(fs.Arg(0))
+		var album albums.Summary
+		err := db.Collection("albums").FindOne(context.Background(), bson.M{"_id": id}).Decode(&album)
+		if err == nil {
+			fmt.Printf("%s - %s\n", album.AlbumArtist, album.Name)
+			err = printListing(db, id, "  ")
+		} else if err == mongo.ErrNoDocuments {
+			// A box set lists each of its albums in turn
+			var boxSet albums.BoxSet
+			err = db.Collection("boxsets").FindOne(context.Background(), bson.M{"_id": id}).Decode(&boxSet)
+			if err == nil {
+				fmt.Println(boxSet.Name)
+				for _, album := range boxSet.Albums {
+					fmt.Printf("  %s\n", album.Name)
+					if err = printListing(db, album.AlbumID, "    "); err != nil {
+						break
+					}
+				}
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error listing %s: %v\n", fs.Arg(0), err)
+			os.Exit(1)
+		}
+
+	case "boxsets":
+		boxSets, err := albums.BoxSets(db)
+		if err != nil {
+			fmt.Printf("Error reading box sets: %v\n", err)
+			os.Exit(1)
+		}
+		for _, boxSet := range boxSets {
+			fmt.Printf("%s  %s\n", boxSet.ID.Hex(), boxSet.Name)
+			for _, album := range boxSet.Albums {
+				fmt.Printf("  %s  %s (discs %v)\n", album.AlbumID.Hex(), album.Name, album.Discs)
+			}
+		}
+		fmt.Printf("%d box sets\n", len(boxSets))
+
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// printAlbumSummary prints an album with its missing tracks, or with its
+// formats when reporting mixed quality.
+func printAlbumSummary(album albums.Summary, mixed bool) {
+	stats := album.Stats
+	fmt.Printf("%s  %s - %s (%d tracks, %d discs)\n", album.ID.Hex(), album.AlbumArtist, album.Name, stats.TrackCount, stats.DiscCount)
+	if mixed {
+		fmt.Printf("    formats: %s, bit depths: %v, sample rates: %v\n", strings.Join(stats.Formats, ", "), stats.BitDepths, stats.SampleRates)
+		return
+	}
+	if len(stats.MissingDiscs) > 0 {
+		fmt.Printf("    missing discs: %v\n", stats.MissingDiscs)
+	}
+	missing := make(map[int][]string)
+	var discs []int
+	for _, track := range stats.MissingTracks {
+		if missing[track.Disc] == nil {
+			discs = append(discs, track.Disc)
+		}
+		missing[track.Disc] = append(missing[track.Disc], fmt.Sprint(track.Track))
+	}
+	for _, disc := range discs {
+		fmt.Printf("    disc %d missing tracks: %s\n", disc, strings.Join(missing[disc], ", "))
+	}
+}
+
+// printListing prints an album's tracks grouped by disc. The disc headings
+// are left out for single-disc albums.
+func printListing(db *mongo.Database, albumID primitive.ObjectID, indent string) error {
+	discs, err := albums.Listing(db, albumID)
+	if err != nil {
+		return err
+	}
+	for _, disc := range discs {
+		trackIndent := indent
+		if len(discs) > 1 {
+			heading := fmt.Sprintf("Disc %d", disc.Number)
+			if disc.Subtitle != "" {
+				heading += ": " + disc.Subtitle
+			}
+			fmt.Printf("%s%s\n", indent, heading)
+			trackIndent += "  "
+		}
+		for _, track := range disc.Tracks {
+			fmt.Printf("%s%2d. %s - %s (%s)\n", trackIndent, track.Track, track.Artist, track.Title, track.Length.Round(time.Second))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ksuayan/go-tracks/artists"
+)
+
+const artistsUsage = `Usage: gt artists <command> [flags]
+
+Commands:
+  merge <target_id> <source_id>...  merge artists into the target
+  merge -auto                       merge artists whose names normalize alike`
+
+// runArtists implements `gt artists`.
+func runArtists(args []string) {
+	fs := flag.NewFlagSet("artists", flag.ExitOnError)
+	auto := fs.Bool("auto", false, "merge every group of artists with the same normalized name")
+	dryRun := fs.Bool("dry-run", false, "only print what would be merged")
+	fs.Usage = func() {
+		fmt.Println(artistsUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) < 1 || args[0] != "merge" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	fs.Parse(args[1:])
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	if *auto {
+		groups, err := artists.Duplicates(db)
+		if err != nil {
+			fmt.Printf("Error finding duplicate artists: %v\n", err)
+			os.Exit(1)
+		}
+		for _, group := range groups {
+			target := group[0]
+			var sourceIDs []string
+			fmt.Printf("%s  %s\n", target.ID.Hex(), target.Name)
+			for _, source := range group[1:] {
+				fmt.Printf("  <- %s  %s\n", source.ID.Hex(), source.Name)
+				sourceIDs = append(sourceIDs, source.ID.Hex())
+			}
+			if *dryRun {
+				continue
+			}
+			if err := artists.Merge(db, target.ID.Hex(), sourceIDs); err != nil {
+				fmt.Printf("Error merging into %s: %v\n", target.Name, err)
+			}
+		}
+		fmt.Printf("%d groups of duplicate artists\n", len(groups))
+		return
+	}
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *dryRun {
+		fmt.Printf("Would merge %v into %s\n", fs.Args()[1:], fs.Arg(0))
+		return
+	}
+	if err := artists.Merge(db, fs.Arg(0), fs.Args()[1:]); err != nil {
+		fmt.Printf("Error merging artists: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/genres"
+	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/musicbrainz"
+)
+
+const mongoURI = "mongodb://localhost:27017"
+
+// connectDB connects to MongoDB or exits.
+func connectDB() (*mongo.Client, *mongo.Database) {
+	client, db, err := mongodb.ConnectToMongoDB(mongoURI)
+	if err != nil {
+		log.Printf("Error connecting to MongoDB: %v\n", err)
+		os.Exit(1)
+	}
+	return client, db
+}
+
+// musicBrainzFlags are the MusicBrainz client settings shared by commands.
+type musicBrainzFlags struct {
+	userAgent *string
+	cacheTTL  *time.Duration
+}
+
+func addMusicBrainzFlags(fs *flag.FlagSet) *musicBrainzFlags {
+	return &musicBrainzFlags{
+		userAgent: fs.String("mb-user-agent", musicbrainz.UserAgent, "User-Agent sent to MusicBrainz, including contact details"),
+		cacheTTL:  fs.Duration("mb-cache-ttl", musicbrainz.DefaultCacheTTL, "how long MusicBrainz responses are cached"),
+	}
+}
+
+// newClient creates the MusicBrainz client for a command, caching responses
+// in the database. Create it once and share it so that all callers share
+// its rate limiter.
+func (f *musicBrainzFlags) newClient(db *mongo.Database) *musicbrainz.Client {
+	cache, err := musicbrainz.NewMongoCache(db)
+	if err != nil {
+		log.Printf("Error creating MusicBrainz cache: %v\n", err)
+		os.Exit(1)
+	}
+	return musicbrainz.NewClient(musicbrainz.Options{
+		UserAgent: *f.userAgent,
+		Cache:     cache,
+		CacheTTL:  *f.cacheTTL,
+	})
+}
+
+func addGenresFlag(fs *flag.FlagSet) *string {
+	return fs.String("genres", "", "genre taxonomy file (JSON); the built-in taxonomy is used if empty")
+}
+
+// loadTaxonomy loads the genre taxonomy or exits.
+func loadTaxonomy(path string) *genres.Taxonomy {
+	taxonomy, err := genres.LoadTaxonomy(path)
+	if err != nil {
+		log.Printf("Error loading genre taxonomy: %v\n", err)
+		os.Exit(1)
+	}
+	return taxonomy
+}
+
+// stringsFlag collects a repeated string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/ksuayan/go-tracks/albums"
+	"github.com/ksuayan/go-tracks/genres"
+)
+
+const genresUsage = `Usage: gt genres <command> [flags]
+
+Commands:
+  list            show the genre hierarchy with track counts
+  tracks <genre>  list tracks in a genre or any genre below it
+  albums <genre>  list albums in a genre or any genre below it
+  relink          re-resolve every track's genres after editing the taxonomy`
+
+// runGenres implements `gt genres`.
+func runGenres(args []string) {
+	fs := flag.NewFlagSet("genres", flag.ExitOnError)
+	genresFile := addGenresFlag(fs)
+	fs.Usage = func() {
+		fmt.Println(genresUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+
+	taxonomy := loadTaxonomy(*genresFile)
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	switch command {
+	case "list":
+		all, err := genres.All(db)
+		if err != nil {
+			fmt.Printf("Error reading genres: %v\n", err)
+			os.Exit(1)
+		}
+		counts, err := genres.TrackCounts(db)
+		if err != nil {
+			fmt.Printf("Error counting tracks: %v\n", err)
+			os.Exit(1)
+		}
+		children := make(map[primitive.ObjectID][]genres.Document)
+		for _, genre := range all {
+			children[genre.ParentID] = append(children[genre.ParentID], genre)
+		}
+		var printTree func(parentID primitive.ObjectID, depth int)
+		printTree = func(parentID primitive.ObjectID, depth int) {
+			for _, genre := range children[parentID] {
+				fmt.Printf("%s%s (%d)\n", strings.Repeat("  ", depth), genre.Name, counts[genre.ID])
+				printTree(genre.ID, depth+1)
+			}
+		}
+		printTree(primitive.NilObjectID, 0)
+
+	case "tracks", "albums":
+		if fs.NArg() < 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		genre, err := genres.Find(db, taxonomy, fs.Arg(0))
+		if err != nil {
+			fmt.Printf("Error finding genre %s: %v\n", fs.Arg(0), err)
+			os.Exit(1)
+		}
+		genreIDs, err := genres.Descendants(db, genre.ID)
+		if err != nil {
+			fmt.Printf("Error reading genres below %s: %v\n", genre.Name, err)
+			os.Exit(1)
+		}
+		cursor, err := db.Collection(command).Find(context.Background(), genres.Filter(genreIDs))
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", command, err)
+			os.Exit(1)
+		}
+		var found []bson.M
+		if err := cursor.All(context.Background(), &found); err != nil {
+			fmt.Printf("Error reading %s: %v\n", command, err)
+			os.Exit(1)
+		}
+		for _, doc := range found {
+			if command == "albums" {
+				fmt.Printf("%s - %s\n", doc["albumArtist"], doc["name"])
+			} else {
+				fmt.Printf("%s - %s [%s]\n", doc["artist"], doc["title"], doc["album"])
+			}
+		}
+		fmt.Printf("%d %s\n", len(found), command)
+
+	case "relink":
+		updated, err := genres.Relink(db, taxonomy)
+		if err != nil {
+			fmt.Printf("Error relinking genres: %v\n", err)
+			os.Exit(1)
+		}
+		if _, err := albums.UpdateStats(db); err != nil {
+			fmt.Printf("Error updating album stats: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Tracks updated: %d\n", updated)
+
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
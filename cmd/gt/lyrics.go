@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ksuayan/go-tracks/lyrics"
+	"github.com/ksuayan/go-tracks/mongodb"
+)
+
+const lyricsUsage = `Usage: gt lyrics [flags] <track_id>
+
+Prints a track's lyrics, with timestamps when they are synchronized.`
+
+// runLyrics implements `gt lyrics`.
+func runLyrics(args []string) {
+	fs := flag.NewFlagSet("lyrics", flag.ExitOnError)
+	plain := fs.Bool("plain", false, "print synchronized lyrics without timestamps")
+	fs.Usage = func() {
+		fmt.Println(lyricsUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	l, err := lyrics.Find(db, mongodb.SafeObjectIDFromHex(fs.Arg(0)))
+	if err != nil {
+		fmt.Printf("Error reading lyrics: %v\n", err)
+		os.Exit(1)
+	}
+	if l == nil {
+		fmt.Println("No lyrics")
+		os.Exit(1)
+	}
+	if !l.Synced || *plain {
+		fmt.Println(l.Text)
+		return
+	}
+	for _, line := range l.Lines {
+		minutes := int(line.Time.Minutes())
+		seconds := line.Time.Seconds() - float64(minutes*60)
+		fmt.Printf("[%02d:%05.2f] %s\n", minutes, seconds, line.Text)
+	}
+}
@@ -2,37 +2,100 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
 
+	"github.com/ksuayan/go-tracks/albums"
 	"github.com/ksuayan/go-tracks/fileinfo"
 	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/musicbrainz"
+	"github.com/ksuayan/go-tracks/playlists"
 	"github.com/ksuayan/go-tracks/utils"
 	"github.com/ksuayan/go-tracks/worker"
 )
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: go run main.go <input_dir> <output_dir> <num_workers>")
-		os.Exit(1)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "match":
+			runMatch(os.Args[2:])
+			return
+		case "artists":
+			runArtists(os.Args[2:])
+			return
+		case "albums":
+			runAlbums(os.Args[2:])
+			return
+		case "genres":
+			runGenres(os.Args[2:])
+			return
+		case "lyrics":
+			runLyrics(os.Args[2:])
+			return
+		case "playlist":
+			runPlaylist(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "users":
+			runUsers(os.Args[2:])
+			return
+		case "search":
+			runSearch(os.Args[2:])
+			return
+		case "query":
+			runQuery(os.Args[2:])
+			return
+		}
 	}
 
-	inputDir := os.Args[1]
-	outputDir := os.Args[2]
-	numWorkers := utils.ParseNumWorkers(os.Args[3])
+	useMusicBrainz := flag.Bool("musicbrainz", false, "fetch artist metadata from MusicBrainz")
+	coverSize := flag.String("cover-size", musicbrainz.Size500, "Cover Art Archive size for albums without art: 250, 500, 1200 or original")
+	mbFlags := addMusicBrainzFlags(flag.CommandLine)
+	genresFile := addGenresFlag(flag.CommandLine)
+	flag.Parse()
 
-	// Initialize MongoDB client
-	mongoURI := "mongodb://localhost:27017"
-	client, db, err := mongodb.ConnectToMongoDB(mongoURI)
-	if err != nil {
-		log.Printf("Error connecting to MongoDB: %v\n", err)
+	if flag.NArg() < 3 {
+		fmt.Println("Usage: go run main.go [flags] <input_dir> <output_dir> <num_workers>")
+		fmt.Println("       go run main.go match <run|list|apply|skip> ...")
+		fmt.Println("       go run main.go artists merge ...")
+		fmt.Println("       go run main.go albums <migrate|stats|incomplete|show|boxsets> ...")
+		fmt.Println("       go run main.go genres <list|tracks|albums|relink> ...")
+		fmt.Println("       go run main.go lyrics <track_id>")
+		fmt.Println("       go run main.go playlist <import|list|unresolved|export|smart|show|fields> ...")
+		fmt.Println("       go run main.go serve [-addr :8080]")
+		fmt.Println("       go run main.go users <add|list|remove> ...")
+		fmt.Println("       go run main.go search [-type track] <query>")
+		fmt.Println("       go run main.go query [-format table|json|csv] <query>")
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
+
+	inputDir := flag.Arg(0)
+	outputDir := flag.Arg(1)
+	numWorkers := utils.ParseNumWorkers(flag.Arg(2))
+	taxonomy := loadTaxonomy(*genresFile)
+
+	// Initialize MongoDB client
+	client, db := connectDB()
 	defer client.Disconnect(context.Background())
 
+	// One MusicBrainz client is shared by all workers so that they share
+	// its rate limiter and cache.
+	var mb *musicbrainz.Client
+	if *useMusicBrainz {
+		mb = mbFlags.newClient(db)
+	}
+
+	if err := mongodb.EnsureIndexes(db); err != nil {
+		log.Printf("Error creating indexes: %v\n", err)
+	}
+
 	// Create temporary directory
 	tempDir := filepath.Join(outputDir, "temp")
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
@@ -42,7 +105,7 @@ func main() {
 
 	// Step 1: Scan directory and update tracks
 	log.Println("Scanning directory and updating tracks...")
-	err = fileinfo.ScanDirectoryAndUpdateDB(inputDir, db)
+	err := fileinfo.ScanDirectoryAndUpdateDB(inputDir, db)
 	if err != nil {
 		log.Printf("Error scanning directory: %v\n", err)
 		os.Exit(1)
@@ -54,9 +117,10 @@ func main() {
 	tasks := make(chan map[string]interface{}, numWorkers) // Buffered channel
 
 	// Launch workers
+	cfg := worker.Config{OutputDir: outputDir, MusicBrainz: mb, CoverSize: *coverSize, Genres: taxonomy}
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go worker.Worker(tasks, db, outputDir, &wg)
+		go worker.Worker(tasks, db, cfg, &wg)
 	}
 
 	// Enqueue tasks
@@ -72,6 +136,21 @@ func main() {
 
 	// Wait for all workers to finish
 	wg.Wait()
+
+	// Step 3: Recompute album aggregates
+	log.Println("Updating album stats...")
+	if _, err := albums.UpdateStats(db); err != nil {
+		log.Printf("Error updating album stats: %v\n", err)
+	}
+	if _, err := albums.UpdateBoxSets(db); err != nil {
+		log.Printf("Error updating box sets: %v\n", err)
+	}
+
+	// Step 4: Import playlist files found in the library
+	log.Println("Importing playlists...")
+	if _, err := playlists.ImportDir(db, inputDir); err != nil {
+		log.Printf("Error importing playlists: %v\n", err)
+	}
 	log.Println("All tasks completed successfully!")
 
 }
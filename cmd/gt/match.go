@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ksuayan/go-tracks/matcher"
+)
+
+const matchUsage = `Usage: gt match <command> [flags]
+
+Commands:
+  run [-limit N]                match albums without MusicBrainz IDs
+  list                          show albums waiting for review
+  apply <album_id> <release_id> assign a release to a queued album
+  skip <album_id>               leave a queued album unmatched`
+
+// runMatch implements `gt match`, which searches MusicBrainz for untagged
+// albums and lets ambiguous matches be resolved by hand.
+func runMatch(args []string) {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	limit := fs.Int("limit", 0, "maximum number of albums to match (0 for all)")
+	autoApply := fs.Float64("auto-apply", matcher.DefaultAutoApply, "minimum score to apply a match without review")
+	minScore := fs.Float64("min-score", matcher.DefaultMinScore, "minimum score for a release to be considered")
+	mbFlags := addMusicBrainzFlags(fs)
+	fs.Usage = func() {
+		fmt.Println(matchUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	switch command {
+	case "run":
+		m := matcher.New(db, mbFlags.newClient(db))
+		m.AutoApply = *autoApply
+		m.MinScore = *minScore
+		counts, err := m.Run(*limit)
+		if err != nil {
+			fmt.Printf("Error matching albums: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Applied: %d, queued for review: %d, unmatched: %d\n",
+			counts[matcher.Applied], counts[matcher.Queued], counts[matcher.Unmatched])
+
+	case "list":
+		entries, err := matcher.Pending(db)
+		if err != nil {
+			fmt.Printf("Error reading match queue: %v\n", err)
+			os.Exit(1)
+		}
+		for _, entry := range entries {
+			fmt.Printf("%s  %s - %s (%d tracks)\n", entry.AlbumID.Hex(), entry.AlbumArtist, entry.Album, entry.TrackCount)
+			for _, c := range entry.Candidates {
+				fmt.Printf("    %.2f  %s  %s - %s [%s %s, %d tracks]\n",
+					c.Score, c.ReleaseID, c.Artist, c.Title, c.Date, c.Country, c.TrackCount)
+			}
+		}
+		fmt.Printf("%d albums awaiting review\n", len(entries))
+
+	case "apply":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		m := matcher.New(db, mbFlags.newClient(db))
+		if err := m.Apply(fs.Arg(0), fs.Arg(1), "manual"); err != nil {
+			fmt.Printf("Error applying match: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "skip":
+		if fs.NArg() < 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if err := matcher.Skip(db, fs.Arg(0)); err != nil {
+			fmt.Printf("Error skipping album: %v\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/playlists"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+const playlistUsage = `Usage: gt playlist <command> [flags]
+
+Commands:
+  import <library_root> [playlist_file]  import playlist files found under the root, or just one
+  list                                   show stored playlists
+  unresolved                             show playlist entries that matched no track
+  export [flags] [playlist]              write a stored playlist, or the tracks matching -query
+  smart [-sort] [-limit] <name> <rules>  create or update a smart playlist from JSON rules
+  show <playlist>                        list a playlist's tracks, materializing smart playlists
+  fields                                 list the fields and operators smart playlist rules can use
+
+Smart playlist rules are a predicate {"field": "year", "op": "between", "value": [1955, 1965]}
+or a group {"all": [...]} / {"any": [...]} of rules.`
+
+// runPlaylist implements `gt playlist`.
+func runPlaylist(args []string) {
+	fs := flag.NewFlagSet("playlist", flag.ExitOnError)
+	format := fs.String("format", playlists.ExportM3U8, "export format: m3u8, xspf or json")
+	output := fs.String("o", "", "file to export to (default stdout)")
+	relative := fs.Bool("relative", false, "write paths relative to the exported file")
+	coverDir := fs.String("covers", "", "cover art output directory, to link covers in xspf and json")
+	query := fs.String("query", "", "export the tracks matching this MongoDB filter (extended JSON) instead of a playlist")
+	sortBy := fs.String("sort", "", "sort -query or smart playlist results by these comma-separated fields, - for descending")
+	limit := fs.Int64("limit", 0, "maximum number of -query or smart playlist results (0 for all)")
+	var rewrites rewriteFlags
+	fs.Var(&rewrites, "rewrite", "rewrite a path prefix as from=to, e.g. /Volumes/Music=/mnt/music (repeatable)")
+	fs.Usage = func() {
+		fmt.Println(playlistUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	switch command {
+	case "import":
+		if fs.NArg() < 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		var imported []playlists.Playlist
+		if fs.NArg() > 1 {
+			playlist, err := playlists.ImportFile(db, fs.Arg(0), fs.Arg(1))
+			if err != nil {
+				fmt.Printf("Error importing playlist: %v\n", err)
+				os.Exit(1)
+			}
+			imported = append(imported, *playlist)
+		} else {
+			var err error
+			if imported, err = playlists.ImportDir(db, fs.Arg(0)); err != nil {
+				fmt.Printf("Error importing playlists: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		for _, playlist := range imported {
+			fmt.Printf("%s  %s (%d entries, %d unresolved)\n", playlist.ID.Hex(), playlist.Name, len(playlist.Entries), playlist.Unresolved)
+		}
+
+	case "list":
+		found, err := playlists.FindAll(db)
+		if err != nil {
+			fmt.Printf("Error reading playlists: %v\n", err)
+			os.Exit(1)
+		}
+		for _, playlist := range found {
+			if playlist.IsSmart() {
+				fmt.Printf("%s  %s (smart, %d tracks when last materialized)\n", playlist.ID.Hex(), playlist.Name, len(playlist.TrackIDs))
+				continue
+			}
+			fmt.Printf("%s  %s (%d tracks, %d unresolved)\n", playlist.ID.Hex(), playlist.Name, len(playlist.TrackIDs), playlist.Unresolved)
+		}
+
+	case "unresolved":
+		found, err := playlists.FindUnresolved(db)
+		if err != nil {
+			fmt.Printf("Error reading playlists: %v\n", err)
+			os.Exit(1)
+		}
+		total := 0
+		for _, playlist := range found {
+			fmt.Printf("%s  %s (%s)\n", playlist.ID.Hex(), playlist.Name, playlist.Source)
+			for _, entry := range playlist.Entries {
+				if entry.ResolvedBy == "" {
+					fmt.Printf("  %3d. %s", entry.Position, entry.Location)
+					if entry.Title != "" {
+						fmt.Printf("  [%s - %s]", entry.Artist, entry.Title)
+					}
+					fmt.Println()
+					total++
+				}
+			}
+		}
+		fmt.Printf("%d unresolved entries\n", total)
+
+	case "export":
+		var name string
+		var trackDocs []map[string]interface{}
+		var err error
+		switch {
+		case *query != "":
+			name = "Query"
+			trackDocs, err = queryTracks(db, *query, *sortBy, *limit)
+		case fs.NArg() > 0:
+			var playlist *playlists.Playlist
+			if playlist, err = playlists.Find(db, fs.Arg(0)); err == nil {
+				name = playlist.Name
+				trackDocs, err = loadPlaylistTracks(db, playlist)
+			}
+		default:
+			fs.Usage()
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("Error loading tracks: %v\n", err)
+			os.Exit(1)
+		}
+
+		opts := playlists.ExportOptions{Format: *format, Relative: *relative, BaseDir: ".", Rewrites: rewrites, CoverDir: *coverDir}
+		w := os.Stdout
+		if *output != "" {
+			opts.BaseDir = filepath.Dir(*output)
+			if w, err = os.Create(*output); err != nil {
+				fmt.Printf("Error creating %s: %v\n", *output, err)
+				os.Exit(1)
+			}
+			defer w.Close()
+		}
+		if err := playlists.Export(w, name, trackDocs, opts); err != nil {
+			fmt.Printf("Error exporting playlist: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "smart":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		var rules playlists.Rule
+		if err := json.Unmarshal([]byte(fs.Arg(1)), &rules); err != nil {
+			fmt.Printf("Error parsing rules: %v\n", err)
+			os.Exit(1)
+		}
+		order, err := playlists.ParseSort(*sortBy)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		playlist, err := playlists.SaveSmart(db, fs.Arg(0), rules, order, *limit)
+		if err != nil {
+			fmt.Printf("Error saving smart playlist: %v\n", err)
+			os.Exit(1)
+		}
+		trackDocs, err := playlists.Materialize(db, playlist)
+		if err != nil {
+			fmt.Printf("Error materializing smart playlist: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s  %s (smart, %d tracks)\n", playlist.ID.Hex(), playlist.Name, len(trackDocs))
+
+	case "show":
+		if fs.NArg() < 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		playlist, err := playlists.Find(db, fs.Arg(0))
+		if err != nil {
+			fmt.Printf("Error reading playlist: %v\n", err)
+			os.Exit(1)
+		}
+		trackDocs, err := loadPlaylistTracks(db, playlist)
+		if err != nil {
+			fmt.Printf("Error loading tracks: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s  %s (%d tracks)\n", playlist.ID.Hex(), playlist.Name, len(trackDocs))
+		for i, track := range trackDocs {
+			id, _ := track["_id"].(primitive.ObjectID)
+			fmt.Printf("  %3d. %s  %s - %s [%s]\n", i+1, id.Hex(), utils.SafeGetString(track, "artist"),
+				utils.SafeGetString(track, "title"), utils.SafeGetString(track, "album"))
+		}
+
+	case "fields":
+		names := make([]string, 0, len(playlists.Fields))
+		for name := range playlists.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field := playlists.Fields[name]
+			fmt.Printf("%-12s %-8s %s\n", name, field.Type, strings.Join(playlists.Operators(field.Type), ", "))
+		}
+
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// loadPlaylistTracks returns a playlist's tracks, running the rules of a
+// smart playlist to get its current tracks.
+func loadPlaylistTracks(db *mongo.Database, playlist *playlists.Playlist) ([]map[string]interface{}, error) {
+	if playlist.IsSmart() {
+		return playlists.Materialize(db, playlist)
+	}
+	return playlists.LoadTracks(db, playlist.TrackIDs)
+}
+
+// rewriteFlags collects repeated -rewrite from=to flags.
+type rewriteFlags []playlists.Rewrite
+
+func (r *rewriteFlags) String() string {
+	var pairs []string
+	for _, rewrite := range *r {
+		pairs = append(pairs, rewrite.From+"="+rewrite.To)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (r *rewriteFlags) Set(value string) error {
+	from, to, ok := strings.Cut(value, "=")
+	if !ok || from == "" {
+		return fmt.Errorf("expected from=to, got %q", value)
+	}
+	*r = append(*r, playlists.Rewrite{From: from, To: to})
+	return nil
+}
+
+// queryTracks returns the tracks matching an extended JSON filter.
+func queryTracks(db *mongo.Database, query, sortBy string, limit int64) ([]map[string]interface{}, error) {
+	var filter bson.M
+	if err := bson.UnmarshalExtJSON([]byte(query), false, &filter); err != nil {
+		return nil, fmt.Errorf("bad query: %v", err)
+	}
+	opts := options.Find().SetSort(sortFields(sortBy))
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := db.Collection("tracks").Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var found []map[string]interface{}
+	err = cursor.All(context.Background(), &found)
+	return found, err
+}
+
+// sortFields turns "artist,album,-year" into a sort document, defaulting to
+// album order.
+func sortFields(sortBy string) bson.D {
+	if sortBy == "" {
+		sortBy = "albumArtist,album,disc,track"
+	}
+	var sort bson.D
+	for _, field := range strings.Split(sortBy, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		order := 1
+		if strings.HasPrefix(field, "-") {
+			field, order = field[1:], -1
+		}
+		sort = append(sort, bson.E{Key: field, Value: order})
+	}
+	return sort
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/playlists"
+	"github.com/ksuayan/go-tracks/query"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+const queryUsage = `Usage: gt query [flags] <query>
+
+Lists the tracks matching a query, such as
+
+  codec:flac samplerate>48k path:Jazz/* year:1950..1960
+
+Terms side by side must all match; OR matches either, - or NOT negates a
+term and parentheses group them. A term is field:value, or a word or
+"quoted phrase" found in the title, artist, album or album artist.
+
+  text fields    title:blue (contains), title="So What" (is), artist:Miles*
+  numbers        year:1959, year:1950..1960, year:..1960, samplerate>=48k
+  length         length>10:00, length:3:30, length:2m..5m
+  dates          added>=2024-01-01, added:2024-01-01..2024-06-30,
+                 lastPlayed<30d (in the last 30 days), lastPlayed>365d
+  true/false     hasLyrics:yes
+  path           path:Jazz (under the folder), path:*/Live/*
+
+Fields are those of smart playlists (gt playlist fields) and path.`
+
+// defaultColumns are the fields gt query shows by default.
+const defaultColumns = "artist,album,title,year,codec,samplerate,bitDepth,length"
+
+// runQuery implements `gt query`.
+func runQuery(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	format := fs.String("format", "table", "output format: table, json or csv")
+	columns := fs.String("fields", defaultColumns, "comma-separated fields to show, including id and path")
+	sortBy := fs.String("sort", "albumArtist,album,disc,track", "sort by these comma-separated fields, - for descending")
+	limit := fs.Int64("limit", 0, "maximum number of tracks (0 for all)")
+	explain := fs.Bool("explain", false, "print the parsed query and MongoDB filter instead of running it")
+	fs.Usage = func() {
+		fmt.Println(queryUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	node, err := query.Parse(strings.Join(fs.Args(), " "))
+	if err != nil {
+		fmt.Printf("Error parsing query: %v\n", err)
+		os.Exit(1)
+	}
+	filter, err := query.Compile(node)
+	if err != nil {
+		fmt.Printf("Error compiling query: %v\n", err)
+		os.Exit(1)
+	}
+	if *explain {
+		data, err := bson.MarshalExtJSON(filter, false, false)
+		if err != nil {
+			fmt.Printf("Error encoding filter: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(node)
+		fmt.Println(string(data))
+		return
+	}
+
+	fields := strings.Split(*columns, ",")
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+		if _, ok := playlists.Fields[fields[i]]; !ok && fields[i] != "id" && fields[i] != query.PathField {
+			fmt.Printf("Error: unknown field %q; use id, path or one of %s\n", fields[i], strings.Join(fieldNames(), ", "))
+			os.Exit(1)
+		}
+	}
+	order, err := playlists.ParseSort(*sortBy)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	sortDoc, err := playlists.SortFilter(order)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	opts := options.Find().SetSort(sortDoc).SetProjection(bson.M{"ffprobe": 0})
+	if *limit > 0 {
+		opts.SetLimit(*limit)
+	}
+	cursor, err := db.Collection("tracks").Find(context.Background(), filter, opts)
+	if err != nil {
+		fmt.Printf("Error running query: %v\n", err)
+		os.Exit(1)
+	}
+	var found []map[string]interface{}
+	if err := cursor.All(context.Background(), &found); err != nil {
+		fmt.Printf("Error running query: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "json":
+		rows := make([]map[string]interface{}, len(found))
+		for i, track := range found {
+			rows[i] = make(map[string]interface{})
+			for _, field := range fields {
+				rows[i][field] = columnValue(track, field)
+			}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(rows); err != nil {
+			fmt.Printf("Error writing JSON: %v\n", err)
+			os.Exit(1)
+		}
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(fields)
+		for _, track := range found {
+			w.Write(columnStrings(track, fields))
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			fmt.Printf("Error writing CSV: %v\n", err)
+			os.Exit(1)
+		}
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+		for _, track := range found {
+			fmt.Fprintln(w, strings.Join(columnStrings(track, fields), "\t"))
+		}
+		w.Flush()
+		fmt.Printf("%d tracks\n", len(found))
+	default:
+		fmt.Printf("Error: unknown format %q\n", *format)
+		os.Exit(1)
+	}
+}
+
+// columnValue returns a field of a track for output: lengths in seconds,
+// dates as times.
+func columnValue(track map[string]interface{}, field string) interface{} {
+	switch field {
+	case "id":
+		id, _ := track["_id"].(primitive.ObjectID)
+		return id.Hex()
+	case query.PathField:
+		subDir, fileName := utils.SafeGetString(track, "subDir"), utils.SafeGetString(track, "fileName")
+		if subDir == "" {
+			return fileName
+		}
+		return subDir + "/" + fileName
+	}
+	f := playlists.Fields[field]
+	value := track[f.Path]
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v.Time()
+	case primitive.A:
+		return []interface{}(v)
+	}
+	if f.Type == playlists.TypeDuration && value != nil {
+		return time.Duration(utils.SafeGetInt64(track, f.Path)).Seconds()
+	}
+	return value
+}
+
+// columnStrings returns fields of a track as text, for tables and CSV.
+func columnStrings(track map[string]interface{}, fields []string) []string {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		switch v := columnValue(track, field).(type) {
+		case nil:
+		case time.Time:
+			values[i] = v.Local().Format("2006-01-02 15:04")
+		case float64:
+			if playlists.Fields[field].Type == playlists.TypeDuration {
+				minutes := int(v) / 60
+				values[i] = fmt.Sprintf("%d:%02d", minutes, int(v)-minutes*60)
+			} else {
+				values[i] = fmt.Sprint(v)
+			}
+		case []interface{}:
+			parts := make([]string, len(v))
+			for j, item := range v {
+				parts[j] = fmt.Sprint(item)
+			}
+			values[i] = strings.Join(parts, "; ")
+		default:
+			values[i] = fmt.Sprint(v)
+		}
+	}
+	return values
+}
+
+// fieldNames returns the names of the smart playlist fields, sorted.
+func fieldNames() []string {
+	names := make([]string, 0, len(playlists.Fields))
+	for name := range playlists.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ksuayan/go-tracks/search"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+const searchUsage = `Usage: gt search [flags] <query>
+
+Searches tracks (by title, artist, album, album artist, composer, genre and
+lyrics), albums and artists for every word of the query. Words match
+regardless of case and accents, as prefixes, and with a typo or two.`
+
+// runSearch implements `gt search`.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	kind := fs.String("type", "", "search only track, album or artist")
+	limit := fs.Int("limit", 10, "maximum results of each type")
+	fs.Usage = func() {
+		fmt.Println(searchUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	opts := search.Options{Limit: *limit}
+	switch *kind {
+	case "":
+	case search.KindTrack, search.KindAlbum, search.KindArtist:
+		opts.Kinds = []string{*kind}
+	default:
+		fmt.Printf("Error: unknown type %q\n", *kind)
+		os.Exit(1)
+	}
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	index, err := search.Build(db)
+	if err != nil {
+		fmt.Printf("Error building search index: %v\n", err)
+		os.Exit(1)
+	}
+	hits := index.Search(strings.Join(fs.Args(), " "), opts)
+	docs, err := search.Load(db, hits)
+	if err != nil {
+		fmt.Printf("Error loading results: %v\n", err)
+		os.Exit(1)
+	}
+	if len(hits) == 0 {
+		fmt.Println("No matches")
+		return
+	}
+	for i, hit := range hits {
+		doc := docs[i]
+		if doc == nil {
+			continue
+		}
+		var label string
+		switch hit.Kind {
+		case search.KindTrack:
+			label = fmt.Sprintf("%s - %s (%s)", utils.SafeGetString(doc, "artist"), utils.SafeGetString(doc, "title"), utils.SafeGetString(doc, "album"))
+		case search.KindAlbum:
+			label = fmt.Sprintf("%s - %s", utils.SafeGetString(doc, "albumArtist"), utils.SafeGetString(doc, "name"))
+		case search.KindArtist:
+			label = utils.SafeGetString(doc, "name")
+		}
+		fmt.Printf("%-6s %6.2f  %s  %s\n", hit.Kind, hit.Score, hit.ID.Hex(), label)
+	}
+}
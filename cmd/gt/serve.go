@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/ksuayan/go-tracks/api"
+	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/transcode"
+)
+
+const serveUsage = `Usage: gt serve [flags]
+
+Serves the library as a JSON API:
+  GET /tracks, /tracks/{id}
+  GET /tracks/{id}/stream[?profile=opus128][&client=name]
+  GET /tracks/{id}/hls/master.m3u8
+  GET /albums, /albums/{id}
+  GET /artists, /artists/{id}
+  GET /genres
+  GET /playlists, /playlists/{id}
+  GET /search?q=[&type=track][&limit=10]
+  GET /covers/{hash}[?size=300]
+  POST /graphql, GET /graphql/schema
+
+and to Subsonic clients under /rest, for the users added with gt users.
+
+Only /rest asks for a user; the rest of the API, streams and covers
+included, is open to anyone who can connect, so the server listens on
+localhost unless -addr says otherwise.`
+
+// runServe implements `gt serve`.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on (:8080 for every interface)")
+	coverDir := fs.String("covers", "", "cover art output directory of the scan, to serve /covers")
+	coverCacheSize := fs.Int64("cover-cache-mb", api.DefaultCoverCacheSize>>20, "size limit of the resized cover cache in MB")
+	var roots stringsFlag
+	fs.Var(&roots, "library", "library root audio may be streamed from (repeatable; default: every scanned root)")
+	profile := fs.String("profile", "", "default transcoding profile of streams ("+strings.Join(transcode.Names(), ", ")+"; raw for none)")
+	var clientProfiles stringsFlag
+	fs.Var(&clientProfiles, "client-profile", "transcoding profile of a client as client=profile (repeatable)")
+	transcodeWorkers := fs.Int("transcode-workers", runtime.NumCPU(), "maximum concurrent transcodes")
+	transcodeCache := fs.String("transcode-cache", "", "directory to cache transcoded streams in (default no cache)")
+	transcodeCacheSize := fs.Int64("transcode-cache-mb", api.DefaultTranscodeCacheSize>>20, "size limit of the transcode cache in MB")
+	hlsCache := fs.String("hls-cache", "", "directory to cache HLS segments in (default in the system temp directory)")
+	hlsCacheSize := fs.Int64("hls-cache-mb", api.DefaultHLSCacheSize>>20, "size limit of the HLS segment cache in MB")
+	searchRefresh := fs.Duration("search-refresh", api.DefaultSearchRefresh, "how often to rebuild the search index")
+	genresFile := addGenresFlag(fs)
+	fs.Usage = func() {
+		fmt.Println(serveUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	if err := mongodb.EnsureIndexes(db); err != nil {
+		log.Printf("Error creating indexes: %v\n", err)
+	}
+
+	if len(roots) == 0 {
+		scanned, err := db.Collection("tracks").Distinct(context.Background(), "rootDir", bson.M{})
+		if err != nil {
+			fmt.Printf("Error reading library roots: %v\n", err)
+			os.Exit(1)
+		}
+		for _, root := range scanned {
+			if root, ok := root.(string); ok {
+				roots = append(roots, root)
+			}
+		}
+		log.Printf("Streaming from library roots %v\n", roots)
+	}
+
+	byClient := make(map[string]string)
+	for _, pair := range clientProfiles {
+		clientName, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			fmt.Printf("Error: expected client=profile, got %q\n", pair)
+			os.Exit(1)
+		}
+		byClient[clientName] = name
+	}
+
+	server, err := api.New(db, api.Config{
+		Genres:         loadTaxonomy(*genresFile),
+		CoverDir:       *coverDir,
+		CoverCacheSize: *coverCacheSize << 20,
+		LibraryRoots:   roots,
+		DefaultProfile: *profile,
+		ClientProfiles: byClient,
+
+		TranscodeConcurrency: *transcodeWorkers,
+		TranscodeCacheDir:    *transcodeCache,
+		TranscodeCacheSize:   *transcodeCacheSize << 20,
+		HLSCacheDir:          *hlsCache,
+		HLSCacheSize:         *hlsCacheSize << 20,
+		SearchRefresh:        *searchRefresh,
+	})
+	if err != nil {
+		fmt.Printf("Error starting server: %v\n", err)
+		os.Exit(1)
+	}
+	log.Printf("Serving the library API on %s\n", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		fmt.Printf("Error serving: %v\n", err)
+		os.Exit(1)
+	}
+}
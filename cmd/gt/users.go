@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ksuayan/go-tracks/users"
+)
+
+const usersUsage = `Usage: gt users <command> [flags]
+
+Commands:
+  add [-admin] <name> <password>  add a user, or change their password
+  list                            list users
+  remove <name>                   remove a user`
+
+// runUsers implements `gt users`, managing the accounts of the Subsonic API.
+func runUsers(args []string) {
+	fs := flag.NewFlagSet("users", flag.ExitOnError)
+	admin := fs.Bool("admin", false, "make the user an administrator")
+	fs.Usage = func() {
+		fmt.Println(usersUsage)
+		fs.PrintDefaults()
+	}
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+
+	client, db := connectDB()
+	defer client.Disconnect(context.Background())
+
+	switch {
+	case command == "add" && fs.NArg() == 2:
+		if err := users.Save(db, fs.Arg(0), fs.Arg(1), *admin); err != nil {
+			fmt.Printf("Error saving user: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Saved user %s\n", fs.Arg(0))
+	case command == "list" && fs.NArg() == 0:
+		all, err := users.All(db)
+		if err != nil {
+			fmt.Printf("Error listing users: %v\n", err)
+			os.Exit(1)
+		}
+		for _, user := range all {
+			role := "user"
+			if user.Admin {
+				role = "admin"
+			}
+			fmt.Printf("%-20s %-5s  since %s\n", user.Name, role, user.CreatedAt.Format("2006-01-02"))
+		}
+	case command == "remove" && fs.NArg() == 1:
+		if err := users.Remove(db, fs.Arg(0)); err != nil {
+			fmt.Printf("Error removing user: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed user %s\n", fs.Arg(0))
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
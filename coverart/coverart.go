@@ -1,16 +1,19 @@
 package coverart
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"image"
 	"image/jpeg"
+	_ "image/png"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/ksuayan/go-tracks/musicbrainz"
 	"github.com/ksuayan/go-tracks/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -44,6 +47,13 @@ func ConvertToJPEG(inputFile, outputFile string) error {
 	return nil
 }
 
+// Sources recorded for each image in the `coverart` collection.
+const (
+	SourceEmbedded = "embedded"
+	SourceSidecar  = "sidecar"
+	SourceCAA      = "caa"
+)
+
 func ExtractCoverArt(db *mongo.Database, track map[string]interface{}, outputDir string) (string, string, error) {
 
 	rootDir := track["rootDir"].(string)
@@ -71,10 +81,30 @@ func ExtractCoverArt(db *mongo.Database, track map[string]interface{}, outputDir
 
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	source := SourceEmbedded
 	if err := cmd.Run(); err != nil {
-		return "", "", fmt.Errorf("error extracting cover art: %w", err)
+		// Fall back to a cover image next to the audio file, or for disc
+		// subfolders, in the album folder above
+		sidecar := FindSidecar(filepath.Join(rootDir, subDir))
+		if sidecar == "" && utils.AlbumDir(subDir) != subDir {
+			sidecar = FindSidecar(filepath.Join(rootDir, utils.AlbumDir(subDir)))
+		}
+		if sidecar == "" {
+			return "", "", fmt.Errorf("error extracting cover art: %w", err)
+		}
+		if err := ConvertToJPEG(sidecar, tempFile); err != nil {
+			return "", "", fmt.Errorf("error converting sidecar %s: %w", sidecar, err)
+		}
+		source = SourceSidecar
 	}
-  
+
+	return StoreCoverArt(db, tempFile, outputDir, source)
+}
+
+// StoreCoverArt moves a JPEG into the content-addressed cover tree under
+// outputDir and records it in the `coverart` collection with its source.
+// It returns the hash and final path of the image.
+func StoreCoverArt(db *mongo.Database, tempFile, outputDir, source string) (string, string, error) {
 	// Generate a hash for the cover art file
 	hash, err := utils.GetFileHash(tempFile)
 	if err != nil {
@@ -106,6 +136,7 @@ func ExtractCoverArt(db *mongo.Database, track map[string]interface{}, outputDir
 			"$set": bson.M{
 				"hash":    hash,
 				"filePath": hashedFilePath,
+				"source":   source,
 			},
 		},
 		options.Update().SetUpsert(true),
@@ -117,6 +148,28 @@ func ExtractCoverArt(db *mongo.Database, track map[string]interface{}, outputDir
 	return hash, hashedFilePath, nil
 }
 
+// Sidecar image names checked, in order of preference, when a file has no
+// embedded cover.
+var sidecarNames = []string{"cover", "folder", "front", "album"}
+
+// FindSidecar returns the path of a cover image in dir, or "" if none.
+func FindSidecar(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, name := range sidecarNames {
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			base := strings.ToLower(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+			if base == name && (ext == ".jpg" || ext == ".jpeg" || ext == ".png") {
+				return filepath.Join(dir, entry.Name())
+			}
+		}
+	}
+	return ""
+}
+
 // GetFilePath generates the file path for a given hash value.
 // It uses a two-level directory structure based on the first 4 characters of the hash.
 func GetCoverArtPathFromHash(outputDir, hash string) (string, error) {
@@ -132,4 +185,34 @@ func GetCoverArtPathFromHash(outputDir, hash string) (string, error) {
 	filePath := filepath.Join(outputDir, level1, level2, fmt.Sprintf("%s.jpg", hash))
 
 	return filePath, nil
-}
\ No newline at end of file
+}
+
+// FetchCoverArtArchive downloads the front cover for a release (or its
+// release group) from the Cover Art Archive and stores it like extracted
+// art, with source "caa". size is one of the musicbrainz.Size* values.
+func FetchCoverArtArchive(db *mongo.Database, mb *musicbrainz.Client, releaseID, releaseGroupID, outputDir, size string) (string, string, error) {
+	image, err := mb.FetchFrontCover(releaseID, releaseGroupID, size)
+	if err != nil {
+		return "", "", fmt.Errorf("error fetching cover from Cover Art Archive: %w", err)
+	}
+
+	uniqueID := utils.GetUniqueID()
+	tempFile := filepath.Join(outputDir, "temp", fmt.Sprintf("caa_%s.jpg", uniqueID))
+	if err := os.WriteFile(tempFile, image, 0644); err != nil {
+		return "", "", err
+	}
+
+	// The archive mostly holds JPEGs, but PNG uploads are served as-is
+	if !bytes.HasPrefix(image, []byte{0xFF, 0xD8}) {
+		rawFile := tempFile + ".raw"
+		if err := os.Rename(tempFile, rawFile); err != nil {
+			return "", "", err
+		}
+		defer os.Remove(rawFile)
+		if err := ConvertToJPEG(rawFile, tempFile); err != nil {
+			return "", "", err
+		}
+	}
+
+	return StoreCoverArt(db, tempFile, outputDir, SourceCAA)
+}
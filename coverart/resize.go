@@ -0,0 +1,69 @@
+package coverart
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+)
+
+// ResizeJPEG writes the JPEG or PNG at path as a JPEG scaled down to fit
+// within size×size, keeping its aspect ratio. Images already that small are
+// re-encoded as is.
+func ResizeJPEG(w io.Writer, path string, size int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+	return jpeg.Encode(w, Resize(img, size), &jpeg.Options{Quality: 85})
+}
+
+// Resize scales an image down to fit within size×size by averaging the
+// source pixels under each destination pixel, which keeps downscaled cover
+// art free of aliasing. It never scales up.
+func Resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if size <= 0 || (srcW <= size && srcH <= size) {
+		return src
+	}
+	dstW, dstH := size, size
+	if srcW > srcH {
+		dstH = max(1, srcH*size/srcW)
+	} else {
+		dstW = max(1, srcW*size/srcH)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/dstH)
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/dstW)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
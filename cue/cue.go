@@ -0,0 +1,237 @@
+package cue
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Sheet is a parsed CUE sheet.
+type Sheet struct {
+	Title     string
+	Performer string
+	Genre     string
+	Date      string
+	Catalog   string
+	Files     []File
+}
+
+// File is a FILE entry with the tracks it holds.
+type File struct {
+	Name   string
+	Type   string
+	Tracks []Track
+}
+
+// Track is a TRACK entry. Start is INDEX 01; End is the start of the next
+// track in the same file, or 0 for the last track, which runs to the end.
+type Track struct {
+	Number     int
+	Title      string
+	Performer  string
+	Songwriter string
+	ISRC       string
+	Pregap     time.Duration // INDEX 00, if any
+	Start      time.Duration
+	End        time.Duration
+}
+
+// Length returns the track's length, given the length of the whole file for
+// the last track.
+func (t Track) Length(fileLength time.Duration) time.Duration {
+	if t.End > 0 {
+		return t.End - t.Start
+	}
+	if fileLength > t.Start {
+		return fileLength - t.Start
+	}
+	return 0
+}
+
+// CUE sheets count time in frames of 1/75 second.
+const framesPerSecond = 75
+
+// ParseFile reads and parses a .cue file.
+func ParseFile(path string) (*Sheet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(bytes.NewReader(data))
+}
+
+// Parse parses a CUE sheet. Sheets that aren't valid UTF-8 are read as
+// Windows-1252, which is what most rippers write.
+func Parse(r io.Reader) (*Sheet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		if data, err = charmap.Windows1252.NewDecoder().Bytes(data); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet := &Sheet{}
+	var file *File
+	var track *Track
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := splitFields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		arg := func(i int) string {
+			if i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "REM":
+			switch strings.ToUpper(arg(1)) {
+			case "GENRE":
+				sheet.Genre = arg(2)
+			case "DATE":
+				sheet.Date = arg(2)
+			}
+		case "CATALOG":
+			sheet.Catalog = arg(1)
+		case "TITLE":
+			if track != nil {
+				track.Title = arg(1)
+			} else {
+				sheet.Title = arg(1)
+			}
+		case "PERFORMER":
+			if track != nil {
+				track.Performer = arg(1)
+			} else {
+				sheet.Performer = arg(1)
+			}
+		case "SONGWRITER":
+			if track != nil {
+				track.Songwriter = arg(1)
+			}
+		case "ISRC":
+			if track != nil {
+				track.ISRC = arg(1)
+			}
+		case "FILE":
+			sheet.Files = append(sheet.Files, File{Name: arg(1), Type: arg(2)})
+			file = &sheet.Files[len(sheet.Files)-1]
+			track = nil
+		case "TRACK":
+			if file == nil {
+				return nil, fmt.Errorf("line %d: TRACK before FILE", lineNo)
+			}
+			number, err := strconv.Atoi(arg(1))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad track number %q", lineNo, arg(1))
+			}
+			file.Tracks = append(file.Tracks, Track{Number: number})
+			track = &file.Tracks[len(file.Tracks)-1]
+		case "INDEX":
+			if track == nil {
+				return nil, fmt.Errorf("line %d: INDEX outside TRACK", lineNo)
+			}
+			offset, err := ParseTime(arg(2))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			switch arg(1) {
+			case "00", "0":
+				track.Pregap = offset
+			case "01", "1":
+				track.Start = offset
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range sheet.Files {
+		tracks := sheet.Files[i].Tracks
+		for j := 0; j+1 < len(tracks); j++ {
+			tracks[j].End = tracks[j+1].Start
+		}
+	}
+	return sheet, nil
+}
+
+// ParseTime parses a CUE time of minutes, seconds and frames ("03:25:60").
+func ParseTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	var n [3]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("bad time %q", s)
+		}
+		n[i] = value
+	}
+	return time.Duration(n[0])*time.Minute +
+		time.Duration(n[1])*time.Second +
+		time.Duration(n[2])*time.Second/framesPerSecond, nil
+}
+
+// FindFile returns the FILE entry for an audio file. Rippers often write the
+// sheet before encoding, so "album.wav" also matches "album.flac".
+func (s *Sheet) FindFile(fileName string) *File {
+	stem := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	for i := range s.Files {
+		name := filepath.Base(strings.ReplaceAll(s.Files[i].Name, `\`, "/"))
+		if strings.EqualFold(name, fileName) {
+			return &s.Files[i]
+		}
+	}
+	for i := range s.Files {
+		name := filepath.Base(strings.ReplaceAll(s.Files[i].Name, `\`, "/"))
+		if strings.EqualFold(strings.TrimSuffix(name, filepath.Ext(name)), stem) {
+			return &s.Files[i]
+		}
+	}
+	return nil
+}
+
+// splitFields splits a line into words, keeping quoted strings together.
+func splitFields(line string) []string {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				fields = append(fields, line[1:])
+				break
+			}
+			fields = append(fields, line[1:end+1])
+			line = strings.TrimSpace(line[end+2:])
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			fields = append(fields, line)
+			break
+		}
+		fields = append(fields, line[:end])
+		line = strings.TrimSpace(line[end:])
+	}
+	return fields
+}
@@ -0,0 +1,215 @@
+// Package diskcache keeps generated files (resized covers, transcodes) in a
+// directory, evicting the least recently used once it grows past a size.
+package diskcache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Cache is an LRU cache of files in a directory. Keys are file names and
+// may contain "/" to group files in subdirectories.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List // front is most recently used
+	size     int64
+	creating map[string]*sync.Mutex
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// New opens a cache in dir, picking up files left by earlier runs. maxBytes
+// of 0 means no limit.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		creating: make(map[string]*sync.Mutex),
+	}
+
+	type existing struct {
+		key  string
+		info os.FileInfo
+	}
+	var files []existing
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, existing{filepath.ToSlash(rel), info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Oldest first, so the most recently written end up at the front
+	sort.Slice(files, func(i, j int) bool { return files[i].info.ModTime().Before(files[j].info.ModTime()) })
+	for _, f := range files {
+		c.add(f.key, f.info.Size())
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Path returns where a key is stored, whether or not it is cached.
+func (c *Cache) Path(key string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(key))
+}
+
+// Get returns the path of a cached file and marks it as recently used.
+func (c *Cache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return c.Path(key), true
+}
+
+// GetOrCreate returns the path of a cached file, creating it with create
+// if needed. Concurrent calls for the same key create it only once; a
+// failed create leaves nothing behind.
+func (c *Cache) GetOrCreate(key string, create func(w io.Writer) error) (string, error) {
+	if path, ok := c.Get(key); ok {
+		return path, nil
+	}
+
+	c.mu.Lock()
+	lock, ok := c.creating[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.creating[key] = lock
+	}
+	c.mu.Unlock()
+	lock.Lock()
+	defer func() {
+		lock.Unlock()
+		c.mu.Lock()
+		if c.creating[key] == lock {
+			delete(c.creating, key)
+		}
+		c.mu.Unlock()
+	}()
+
+	// Another caller may have created it while we waited
+	if path, ok := c.Get(key); ok {
+		return path, nil
+	}
+	return c.create(key, create)
+}
+
+// Put stores a file under key, replacing any earlier one.
+func (c *Cache) Put(key string, create func(w io.Writer) error) (string, error) {
+	return c.create(key, create)
+}
+
+func (c *Cache) create(key string, create func(w io.Writer) error) (string, error) {
+	path := c.Path(key)
+	if !strings.HasPrefix(path, filepath.Clean(c.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("bad cache key %q", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	// Written to a temporary file first so readers never see partial files
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	err = create(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.add(key, info.Size())
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return path, nil
+}
+
+// Remove drops a file from the cache.
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// Size returns the total size of the cached files.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *Cache) add(key string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.size -= element.Value.(*entry).size
+		element.Value.(*entry).size = size
+		c.size += size
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, size: size})
+	c.size += size
+}
+
+// evict removes least recently used files until the cache fits, always
+// keeping the newest. c.mu must be held.
+func (c *Cache) evict() {
+	for c.maxBytes > 0 && c.size > c.maxBytes && c.order.Len() > 1 {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *Cache) removeElement(element *list.Element) {
+	e := element.Value.(*entry)
+	c.order.Remove(element)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if err := os.Remove(c.Path(e.key)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing cached file %s: %v\n", e.key, err)
+	}
+}
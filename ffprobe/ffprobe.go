@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os/exec"
+	"strconv"
 )
 
 // FFProbe represents the structure of ffprobe JSON output
@@ -14,25 +15,78 @@ type FFProbe struct {
 
 // Stream represents an individual stream in ffprobe output
 type Stream struct {
-	Index        int    `bson:"index"`
-	CodecName    string `bson:"codec_name"`
-	CodecType    string `bson:"codec_type"`
-	BitRate      string `bson:"bit_rate,omitempty"`
-	SampleRate   string `bson:"sample_rate,omitempty"`
-	Channels     int    `bson:"channels,omitempty"`
-	ChannelLayout string `bson:"channel_layout,omitempty"`
-	Width        int    `bson:"width,omitempty"`
-	Height       int    `bson:"height,omitempty"`
-	Duration     string `bson:"duration,omitempty"`
+	Index            int               `json:"index" bson:"index"`
+	CodecName        string            `json:"codec_name" bson:"codec_name"`
+	CodecType        string            `json:"codec_type" bson:"codec_type"`
+	BitRate          string            `json:"bit_rate" bson:"bit_rate,omitempty"`
+	SampleRate       string            `json:"sample_rate" bson:"sample_rate,omitempty"`
+	SampleFmt        string            `json:"sample_fmt" bson:"sample_fmt,omitempty"`
+	BitsPerSample    int               `json:"bits_per_sample" bson:"bits_per_sample,omitempty"`
+	BitsPerRawSample string            `json:"bits_per_raw_sample" bson:"bits_per_raw_sample,omitempty"`
+	Channels         int               `json:"channels" bson:"channels,omitempty"`
+	ChannelLayout    string            `json:"channel_layout" bson:"channel_layout,omitempty"`
+	Width            int               `json:"width" bson:"width,omitempty"`
+	Height           int               `json:"height" bson:"height,omitempty"`
+	Duration         string            `json:"duration" bson:"duration,omitempty"`
+	Tags             map[string]string `json:"tags" bson:"tags,omitempty"`
 }
 
 // Format represents the format section in ffprobe output
 type Format struct {
-	Filename string            `bson:"filename"`
-	Duration string            `bson:"duration"`
-	BitRate  string            `bson:"bit_rate"`
-	Size     string            `bson:"size"`
-	Tags     map[string]string `bson:"tags"`
+	Filename   string            `json:"filename" bson:"filename"`
+	FormatName string            `json:"format_name" bson:"format_name,omitempty"`
+	Duration   string            `json:"duration" bson:"duration"`
+	BitRate    string            `json:"bit_rate" bson:"bit_rate"`
+	Size       string            `json:"size" bson:"size"`
+	Tags       map[string]string `json:"tags" bson:"tags"`
+}
+
+// AudioStream returns the first audio stream, or nil if there is none.
+func (f *FFProbe) AudioStream() *Stream {
+	for i := range f.Streams {
+		if f.Streams[i].CodecType == "audio" {
+			return &f.Streams[i]
+		}
+	}
+	return nil
+}
+
+// BitDepth returns the bits per sample of the audio stream, or 0 for lossy
+// codecs where it doesn't apply.
+func (f *FFProbe) BitDepth() int {
+	stream := f.AudioStream()
+	if stream == nil {
+		return 0
+	}
+	if bits, err := strconv.Atoi(stream.BitsPerRawSample); err == nil && bits > 0 {
+		return bits
+	}
+	return stream.BitsPerSample
+}
+
+// Codec returns the codec name of the audio stream.
+func (f *FFProbe) Codec() string {
+	if stream := f.AudioStream(); stream != nil {
+		return stream.CodecName
+	}
+	return ""
+}
+
+// AllTags merges the container tags with the tags of each stream.
+// Ogg and Opus files carry their Vorbis comments on the audio stream rather
+// than the format, so callers looking up a tag should use this instead of
+// Format.Tags. Container tags take precedence.
+func (f *FFProbe) AllTags() map[string]string {
+	tags := make(map[string]string)
+	for _, stream := range f.Streams {
+		for key, value := range stream.Tags {
+			tags[key] = value
+		}
+	}
+	for key, value := range f.Format.Tags {
+		tags[key] = value
+	}
+	return tags
 }
 
 // getFFProbe runs ffprobe on the input file and parses the JSON output
@@ -0,0 +1,138 @@
+package fileinfo
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ksuayan/go-tracks/cue"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// CueEmbedded is the CueSheet value of tracks split by an embedded CUESHEET tag.
+const CueEmbedded = "embedded"
+
+// Tags that describe the whole rip's single "track" rather than the album,
+// and so don't carry over to the tracks split from it.
+var perTrackTags = []string{
+	"title", "artist", "artists", "artistsort", "track", "tracknumber",
+	"lyrics", "unsyncedlyrics", "cuesheet", "isrc", "remixer",
+	"musicbrainz_trackid", "musicbrainz track id",
+	"musicbrainz_releasetrackid", "musicbrainz release track id",
+	"musicbrainz_artistid", "musicbrainz artist id",
+	"musicbrainz_workid", "musicbrainz work id",
+}
+
+// cueSidecar is a parsed .cue file found next to audio files.
+type cueSidecar struct {
+	path  string
+	sheet *cue.Sheet
+}
+
+// cueSidecars parses the .cue files of each directory once per scan.
+type cueSidecars map[string][]cueSidecar
+
+func (c cueSidecars) load(dir string) []cueSidecar {
+	if sidecars, ok := c[dir]; ok {
+		return sidecars
+	}
+	var sidecars []cueSidecar
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".cue") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		sheet, err := cue.ParseFile(path)
+		if err != nil {
+			log.Printf("Error parsing cue sheet %s: %v", path, err)
+			continue
+		}
+		sidecars = append(sidecars, cueSidecar{path: path, sheet: sheet})
+	}
+	c[dir] = sidecars
+	return sidecars
+}
+
+// findCueSheet looks for a CUE sheet describing an audio file: an embedded
+// CUESHEET tag, or a .cue file in the same directory whose FILE entry names
+// it. A single-file .cue with the same name as the audio file describes it
+// even if its FILE line is stale. It returns the sheet, the entry for the
+// file and where the sheet came from, or nil if there is none.
+func (c cueSidecars) findCueSheet(dir, fileName string, tags map[string]string) (*cue.Sheet, *cue.File, string) {
+	if embedded := utils.FindTagValue(tags, "cuesheet"); embedded != "" {
+		sheet, err := cue.Parse(strings.NewReader(embedded))
+		if err != nil {
+			log.Printf("Error parsing embedded cue sheet of %s: %v", fileName, err)
+		} else if file := sheet.FindFile(fileName); file != nil {
+			return sheet, file, CueEmbedded
+		} else if len(sheet.Files) == 1 {
+			return sheet, &sheet.Files[0], CueEmbedded
+		}
+	}
+
+	stem := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	for _, sidecar := range c.load(dir) {
+		if file := sidecar.sheet.FindFile(fileName); file != nil {
+			return sidecar.sheet, file, sidecar.path
+		}
+		sidecarStem := strings.TrimSuffix(filepath.Base(sidecar.path), filepath.Ext(sidecar.path))
+		if strings.EqualFold(sidecarStem, stem) && len(sidecar.sheet.Files) == 1 {
+			return sidecar.sheet, &sidecar.sheet.Files[0], sidecar.path
+		}
+	}
+	return nil, nil, ""
+}
+
+// cueTracks splits a single-file rip into one virtual track per CUE track.
+// Each shares the file's path, hash and audio properties, and records its
+// Start and End offsets within the file. Album-level tags of the file are
+// kept; titles and performers come from the sheet.
+func cueTracks(file FileInfo, sheet *cue.Sheet, cueFile *cue.File, source string) []FileInfo {
+	year, _ := strconv.Atoi(strings.TrimSpace(sheet.Date[:min(len(sheet.Date), 4)]))
+	tags := utils.WithoutTags(file.FFProbe.Format.Tags, perTrackTags...)
+	ids := file.MusicBrainz
+	ids.RecordingID, ids.TrackID, ids.WorkID, ids.ArtistIDs = "", "", "", nil
+
+	numbers := make([]int, len(cueFile.Tracks))
+	for i, cueTrack := range cueFile.Tracks {
+		numbers[i] = cueTrack.Number
+	}
+
+	var tracks []FileInfo
+	for _, cueTrack := range cueFile.Tracks {
+		track := file
+		track.CueSheet = source
+		track.CueTrack = cueTrack.Number
+		track.cueTracks = numbers
+		track.Start = cueTrack.Start
+		track.End = cueTrack.End
+		track.Length = cueTrack.Length(file.Length)
+		track.Track = cueTrack.Number
+		track.TrackTotal = len(cueFile.Tracks)
+		track.Title = firstNonEmpty(cueTrack.Title, fmt.Sprintf("Track %02d", cueTrack.Number))
+		track.Artist = firstNonEmpty(cueTrack.Performer, sheet.Performer, file.Artist)
+		track.Album = firstNonEmpty(sheet.Title, file.Album)
+		track.AlbumArtist = firstNonEmpty(file.AlbumArtist, sheet.Performer)
+		track.Genre = firstNonEmpty(file.Genre, sheet.Genre)
+		if track.Year == 0 {
+			track.Year = year
+		}
+		track.MusicBrainz = ids
+		track.FFProbe.Format.Tags = tags
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/ksuayan/go-tracks/ffprobe"
+	"github.com/ksuayan/go-tracks/musicbrainz"
+	"github.com/ksuayan/go-tracks/playlists"
 	"github.com/ksuayan/go-tracks/utils"
 
 	"github.com/wtolson/go-taglib"
@@ -28,6 +30,7 @@ type FileInfo struct {
 	Artist           string    `bson:"artist"`
 	Album            string    `bson:"album"`
 	AlbumArtist			 string    `bson:"albumArtist"`
+	Composer         string    `bson:"composer,omitempty"`
 	Year             int       `bson:"year"`
 	Genre            string    `bson:"genre"`
 	Bitrate          int       `bson:"bitrate"`
@@ -35,11 +38,30 @@ type FileInfo struct {
 	Channels         int       `bson:"channels"`
 	Length           time.Duration       `bson:"length"`
 	Track            int       `bson:"track"`
+	TrackTotal       int       `bson:"trackTotal"`
+	Disc             int       `bson:"disc"`
+	DiscTotal        int       `bson:"discTotal"`
+	DiscSubtitle     string    `bson:"discSubtitle"`
+	AlbumDir         string    `bson:"albumDir"`
+	Codec            string    `bson:"codec"`
+	BitDepth         int       `bson:"bitDepth"`
+	Size             int64     `bson:"size"`
 	Status           string    `bson:"status"`
 	CoverArt         string    `bson:"coverArt"`
 	CoverArtHash     string    `bson:"coverArtHash"`
 	FileHash         string    `bson:"fileHash"`
 	FFProbe					 ffprobe.FFProbe   `bson:"ffprobe"`
+	MusicBrainz      musicbrainz.IDs   `bson:"musicbrainz"`
+	// Set on virtual tracks split from a single-file rip by a CUE sheet:
+	// the .cue path (or CueEmbedded), the CUE track number, and the
+	// track's offsets within the file (End is 0 for the last track).
+	CueSheet         string        `bson:"cueSheet,omitempty"`
+	CueTrack         int           `bson:"cueTrack,omitempty"`
+	Start            time.Duration `bson:"start,omitempty"`
+	End              time.Duration `bson:"end,omitempty"`
+	// cueTracks are the CUE track numbers of every track of the file, so
+	// that tracks dropped from an edited sheet are removed.
+	cueTracks        []int
 }
 
 // List of known audio file extensions
@@ -62,6 +84,7 @@ func ScanDirectoryAsync(root string, fileChan chan<- FileInfo, doneChan chan<- e
 	defer close(fileChan) // Close the channel when done
 
 	totalFiles := 0
+	sidecars := make(cueSidecars)
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			log.Printf("Error accessing path %s: %v", path, err)
@@ -105,10 +128,32 @@ func ScanDirectoryAsync(root string, fileChan chan<- FileInfo, doneChan chan<- e
 				ffprobeData, err := ffprobe.GetFFProbe(fullpath)
 				if err != nil {
 					log.Printf("Error getting ffprobe for %s: %v", info.Name(), err)
+					ffprobeData = &ffprobe.FFProbe{}
 				} 
 
-				// Send FileInfo to the channel
-				fileChan <- FileInfo{
+				// Track and disc totals come from "3/12" style tags or separate total tags
+				tags := ffprobeData.AllTags()
+				_, trackTotal := utils.ParsePosition(utils.FindTagValue(tags, "track", "tracknumber"))
+				if trackTotal == 0 {
+					trackTotal, _ = utils.ParsePosition(utils.FindTagValue(tags, "tracktotal", "totaltracks"))
+				}
+				disc, discTotal := utils.ParsePosition(utils.FindTagValue(tags, "disc", "discnumber"))
+				if discTotal == 0 {
+					discTotal, _ = utils.ParsePosition(utils.FindTagValue(tags, "disctotal", "totaldiscs"))
+				}
+				discSubtitle := utils.FindTagValue(tags, "discsubtitle", "setsubtitle", "tsst")
+
+				// CD1/, "Disc 2 - Live"/ and the like give the disc when tags don't
+				if folderDisc, folderSubtitle, ok := utils.ParseDiscFolder(filepath.Base(subDir)); ok {
+					if disc == 0 {
+						disc = folderDisc
+					}
+					if discSubtitle == "" {
+						discSubtitle = folderSubtitle
+					}
+				}
+
+				file := FileInfo{
 					RootDir:				 root,
 					SubDir:        	 subDir,
 					FileName:        fileName,
@@ -125,16 +170,40 @@ func ScanDirectoryAsync(root string, fileChan chan<- FileInfo, doneChan chan<- e
 					Channels:        audioMetadata.Channels(),
 					Length:          audioMetadata.Length(),
 					Track:           audioMetadata.Track(),
+					TrackTotal:      trackTotal,
+					Disc:            disc,
+					DiscTotal:       discTotal,
+					DiscSubtitle:    discSubtitle,
+					AlbumDir:        utils.AlbumDir(subDir),
+					Codec:           ffprobeData.Codec(),
+					BitDepth:        ffprobeData.BitDepth(),
+					Size:            info.Size(),
 					Status:          "new",
 					CoverArt:				 "",
 					CoverArtHash:    "",
 					FileHash:        fileHash,
 					FFProbe: 			   *ffprobeData,
 					AlbumArtist: 		 utils.SafeGetTagValue(ffprobeData.Format.Tags,	"album_artist"),
+					Composer:        utils.FindTagValue(tags, "composer"),
+					MusicBrainz:     musicbrainz.ExtractIDs(tags),
+				}
+
+				// Send FileInfo to the channel, split into its CUE tracks if it's a single-file rip
+				sheet, cueFile, source := sidecars.findCueSheet(dirPath, fileName, tags)
+				if sheet != nil && len(cueFile.Tracks) > 1 {
+					log.Printf("Splitting %s into %d tracks from cue sheet %s", fileName, len(cueFile.Tracks), source)
+					for _, track := range cueTracks(file, sheet, cueFile, source) {
+						fileChan <- track
+					}
+				} else {
+					fileChan <- file
 				}
 
 				totalFiles++
 
+			} else if playlists.IsPlaylistFile(fileExt) {
+				// Imported once the tracks they refer to are in the database
+				log.Printf("dir: %s, playlist file: %s", utils.GetSubDir(path, root, info.Name()), info.Name())
 			} else {
 				log.Printf("Skipping non-audio file: %s", path)
 			}
@@ -165,10 +234,28 @@ func UpdateDatabase(db *mongo.Database, fileChan <-chan FileInfo, doneChan <-cha
 				return err
 			}
 
-			// Update the database
+			// Update the database. A file is stored either whole or as its
+			// CUE tracks, so remove whichever form it no longer has, and
+			// CUE tracks no longer on the sheet.
 			filter := bson.M{"rootDir": file.RootDir, "subDir": file.SubDir, "fileName": file.FileName}
-			update := bson.M{"$set": file}
-			_, err := collection.UpdateOne(context.Background(), 
+			stale := bson.M{"rootDir": file.RootDir, "subDir": file.SubDir, "fileName": file.FileName}
+			if file.CueTrack > 0 {
+				filter["cueTrack"] = file.CueTrack
+				stale["cueTrack"] = bson.M{"$nin": file.cueTracks}
+			} else {
+				filter["cueTrack"] = bson.M{"$exists": false}
+				stale["cueTrack"] = bson.M{"$exists": true}
+			}
+			if _, err := collection.DeleteMany(context.Background(), stale); err != nil {
+				log.Printf("Error removing stale tracks for %s: %v", file.FileName, err)
+			}
+			set, err := scanFields(file)
+			if err != nil {
+				log.Printf("Error encoding %s: %v", file.FileName, err)
+				continue
+			}
+			update := bson.M{"$set": set}
+			_, err = collection.UpdateOne(context.Background(), 
 				filter, 
 				update, 
 				options.Update().SetUpsert(true))
@@ -185,6 +272,26 @@ func UpdateDatabase(db *mongo.Database, fileChan <-chan FileInfo, doneChan <-cha
 	}
 }
 
+// scanFields returns the fields a scan sets on a track. The MusicBrainz IDs
+// found in the tags are set one by one, so that a rescan keeps what
+// enrichment and matching stored in the same sub-document.
+func scanFields(file FileInfo) (bson.M, error) {
+	data, err := bson.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	var set bson.M
+	if err := bson.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	ids, _ := set["musicbrainz"].(bson.M)
+	delete(set, "musicbrainz")
+	for key, value := range ids {
+		set["musicbrainz."+key] = value
+	}
+	return set, nil
+}
+
 func ScanDirectoryAndUpdateDB(root string, db *mongo.Database) error {
 	fileChan := make(chan FileInfo, 1000) // Buffered channel for FileInfo
 	doneChan := make(chan error, 1)     // Channel for signaling completion
@@ -0,0 +1,232 @@
+package genres
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// Document is a genre in the `genres` collection.
+type Document struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	Name     string             `bson:"name"`
+	Key      string             `bson:"key"`
+	Parent   string             `bson:"parent,omitempty"`
+	ParentID primitive.ObjectID `bson:"parentID,omitempty"`
+	Aliases  []string           `bson:"aliases,omitempty"`
+}
+
+// UpdateGenres splits the track's genre tag, maps each value to its canonical
+// genre and upserts it together with its ancestors. The canonical names and
+// genre IDs are stored on the track map as "genres" and "genreIDs".
+func UpdateGenres(db *mongo.Database, t *Taxonomy, track map[string]interface{}) ([]primitive.ObjectID, error) {
+	names := t.Resolve(utils.SafeGetString(track, "genre"))
+	genreIDs := []primitive.ObjectID{}
+	for _, name := range names {
+		genreID, err := ensureGenre(db, t, name)
+		if err != nil {
+			return nil, err
+		}
+		genreIDs = append(genreIDs, genreID)
+	}
+	if names == nil {
+		names = []string{}
+	}
+	track["genres"] = names
+	track["genreIDs"] = genreIDs
+	return genreIDs, nil
+}
+
+// ensureGenre upserts a canonical genre by key, parents first so that the
+// parent ID can be stored.
+func ensureGenre(db *mongo.Database, t *Taxonomy, name string) (primitive.ObjectID, error) {
+	set := bson.M{"name": name, "key": Key(name)}
+	unset := bson.M{}
+	if parent := t.Parent(name); parent != "" {
+		parentID, err := ensureGenre(db, t, parent)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		set["parent"] = parent
+		set["parentID"] = parentID
+	} else {
+		unset["parent"] = ""
+		unset["parentID"] = ""
+	}
+	if genre, ok := t.genres[Key(name)]; ok && len(genre.Aliases) > 0 {
+		set["aliases"] = genre.Aliases
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	genreID, upserted, err := mongodb.UpsertID(db.Collection("genres"), bson.M{"key": Key(name)}, update)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if upserted {
+		log.Printf("Upserted Genre: %s\n", name)
+	}
+	return mongodb.SafeObjectIDFromHex(genreID), nil
+}
+
+// Sync upserts every genre of the taxonomy, so the hierarchy can be browsed
+// before any track uses it, and returns the number of genres.
+func Sync(db *mongo.Database, t *Taxonomy) (int, error) {
+	for _, genre := range t.Genres() {
+		if _, err := ensureGenre(db, t, genre.Name); err != nil {
+			return 0, err
+		}
+	}
+	return len(t.genres), nil
+}
+
+// Relink re-resolves the genres of every track, as after the taxonomy file
+// changes, and deletes genres no track or taxonomy entry uses any more. It
+// returns the number of tracks updated.
+func Relink(db *mongo.Database, t *Taxonomy) (int, error) {
+	ctx := context.Background()
+	tracksCollection := db.Collection("tracks")
+
+	if _, err := Sync(db, t); err != nil {
+		return 0, err
+	}
+
+	cursor, err := tracksCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		var track map[string]interface{}
+		if err := cursor.Decode(&track); err != nil {
+			return updated, err
+		}
+		if _, err := UpdateGenres(db, t, track); err != nil {
+			log.Printf("Error updating genres for track %v: %v\n", track["_id"], err)
+			continue
+		}
+		_, err := tracksCollection.UpdateOne(ctx, bson.M{"_id": track["_id"]}, bson.M{"$set": bson.M{
+			"genres":   track["genres"],
+			"genreIDs": track["genreIDs"],
+		}})
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, err
+	}
+
+	return updated, removeUnused(db, t)
+}
+
+// removeUnused deletes genres outside the taxonomy that no track links to.
+func removeUnused(db *mongo.Database, t *Taxonomy) error {
+	ctx := context.Background()
+	used, err := db.Collection("tracks").Distinct(ctx, "genreIDs", bson.M{})
+	if err != nil {
+		return err
+	}
+	var configured []string
+	for key := range t.genres {
+		configured = append(configured, key)
+	}
+	_, err = db.Collection("genres").DeleteMany(ctx, bson.M{
+		"_id": bson.M{"$nin": used},
+		"key": bson.M{"$nin": configured},
+	})
+	return err
+}
+
+// Find returns the genre a name or alias resolves to.
+func Find(db *mongo.Database, t *Taxonomy, name string) (*Document, error) {
+	var genre Document
+	err := db.Collection("genres").FindOne(context.Background(), bson.M{"key": Key(t.Canonical(name))}).Decode(&genre)
+	if err != nil {
+		return nil, err
+	}
+	return &genre, nil
+}
+
+// All returns every genre, sorted by name.
+func All(db *mongo.Database) ([]Document, error) {
+	ctx := context.Background()
+	cursor, err := db.Collection("genres").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var genres []Document
+	if err := cursor.All(ctx, &genres); err != nil {
+		return nil, err
+	}
+	sort.Slice(genres, func(i, j int) bool { return genres[i].Key < genres[j].Key })
+	return genres, nil
+}
+
+// Descendants returns the genre and every genre below it, so that browsing
+// "Jazz" includes "Bebop".
+func Descendants(db *mongo.Database, genreID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx := context.Background()
+	ids := []primitive.ObjectID{genreID}
+	seen := map[primitive.ObjectID]bool{genreID: true}
+	for level := []primitive.ObjectID{genreID}; len(level) > 0; {
+		cursor, err := db.Collection("genres").Find(ctx, bson.M{"parentID": bson.M{"$in": level}})
+		if err != nil {
+			return nil, err
+		}
+		var children []Document
+		if err := cursor.All(ctx, &children); err != nil {
+			return nil, err
+		}
+		level = nil
+		for _, child := range children {
+			if !seen[child.ID] {
+				seen[child.ID] = true
+				ids = append(ids, child.ID)
+				level = append(level, child.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// Filter matches tracks or albums linked to any of the genres.
+func Filter(genreIDs []primitive.ObjectID) bson.M {
+	return bson.M{"genreIDs": bson.M{"$in": genreIDs}}
+}
+
+// TrackCounts returns the number of tracks linked directly to each genre.
+func TrackCounts(db *mongo.Database) (map[primitive.ObjectID]int, error) {
+	ctx := context.Background()
+	cursor, err := db.Collection("tracks").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$unwind", Value: "$genreIDs"}},
+		{{Key: "$group", Value: bson.M{"_id": "$genreIDs", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Count int                `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	counts := make(map[primitive.ObjectID]int)
+	for _, result := range results {
+		counts[result.ID] = result.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,165 @@
+package genres
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+//go:embed default.json
+var defaultTaxonomy []byte
+
+// Genre is one canonical genre in the taxonomy file. Aliases are the other
+// spellings that map to it; Parent names its broader genre.
+type Genre struct {
+	Name    string   `json:"name"`
+	Parent  string   `json:"parent,omitempty"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Taxonomy maps genre spellings to canonical genres and knows their
+// hierarchy. Genres not in the taxonomy are kept as found, without a parent.
+type Taxonomy struct {
+	genres  map[string]Genre  // by key of the canonical name
+	aliases map[string]string // key of any spelling -> key of the canonical name
+}
+
+// Multi-valued genre tags come through as "Jazz; Bebop", "Jazz/Bebop" or
+// "Jazz, Bebop" (and NUL-separated for ID3v2.4).
+var genreSeparator = regexp.MustCompile(`\s*[;/,|\x00]\s*`)
+
+// ID3v1 numeric genres as written by some taggers, e.g. "(8)" or "(8)Jazz".
+var id3v1Genre = regexp.MustCompile(`^\((\d+)\)\s*`)
+
+// LoadTaxonomy reads a taxonomy file, or the built-in taxonomy when path is
+// empty. The file is JSON of the form
+//
+//	{"genres": [{"name": "Bebop", "parent": "Jazz", "aliases": ["Be-Bop"]}]}
+func LoadTaxonomy(path string) (*Taxonomy, error) {
+	data := defaultTaxonomy
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var file struct {
+		Genres []Genre `json:"genres"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing genre taxonomy %s: %v", path, err)
+	}
+	return NewTaxonomy(file.Genres)
+}
+
+// NewTaxonomy builds a taxonomy from canonical genres. Parents must be
+// canonical genres themselves and the hierarchy must not loop.
+func NewTaxonomy(genres []Genre) (*Taxonomy, error) {
+	t := &Taxonomy{genres: make(map[string]Genre), aliases: make(map[string]string)}
+	for _, genre := range genres {
+		key := Key(genre.Name)
+		if _, ok := t.genres[key]; ok {
+			return nil, fmt.Errorf("genre %q is listed twice", genre.Name)
+		}
+		t.genres[key] = genre
+		t.aliases[key] = key
+	}
+	for key, genre := range t.genres {
+		for _, alias := range genre.Aliases {
+			if existing, ok := t.aliases[Key(alias)]; ok && existing != key {
+				return nil, fmt.Errorf("alias %q of %q is already %q", alias, genre.Name, t.genres[existing].Name)
+			}
+			t.aliases[Key(alias)] = key
+		}
+		if genre.Parent != "" {
+			if _, ok := t.genres[Key(genre.Parent)]; !ok {
+				return nil, fmt.Errorf("parent %q of %q is not a genre", genre.Parent, genre.Name)
+			}
+		}
+	}
+	for _, genre := range t.genres {
+		if len(t.Ancestors(genre.Name)) > len(t.genres) {
+			return nil, fmt.Errorf("genre %q is its own ancestor", genre.Name)
+		}
+	}
+	return t, nil
+}
+
+// Key is the identity of a genre name: folded like other names, with spaces
+// also dropped so that "Hip-Hop", "Hip Hop" and "hiphop" are one genre.
+func Key(name string) string {
+	return strings.ReplaceAll(utils.FoldKey(name), " ", "")
+}
+
+// Canonical returns the canonical spelling of a genre, or the name as given
+// when the taxonomy doesn't know it.
+func (t *Taxonomy) Canonical(name string) string {
+	name = strings.TrimSpace(name)
+	if key, ok := t.aliases[Key(name)]; ok {
+		return t.genres[key].Name
+	}
+	return name
+}
+
+// Parent returns the canonical parent of a genre, or "" for top-level and
+// unknown genres.
+func (t *Taxonomy) Parent(name string) string {
+	genre, ok := t.genres[t.aliases[Key(name)]]
+	if !ok || genre.Parent == "" {
+		return ""
+	}
+	return t.Canonical(genre.Parent)
+}
+
+// Ancestors returns the parent, grandparent and so on of a genre. The walk
+// stops after as many steps as there are genres, so a loop can't hang it.
+func (t *Taxonomy) Ancestors(name string) []string {
+	var ancestors []string
+	for parent := t.Parent(name); parent != "" && len(ancestors) <= len(t.genres); parent = t.Parent(parent) {
+		ancestors = append(ancestors, parent)
+	}
+	return ancestors
+}
+
+// Genres returns the canonical genres of the taxonomy.
+func (t *Taxonomy) Genres() []Genre {
+	genres := make([]Genre, 0, len(t.genres))
+	for _, genre := range t.genres {
+		genres = append(genres, genre)
+	}
+	return genres
+}
+
+// Resolve splits a genre tag into its values and maps each to its canonical
+// genre, dropping duplicates. A value that is an alias as a whole
+// ("Hip Hop/Rap") is not split.
+func (t *Taxonomy) Resolve(value string) []string {
+	value = strings.TrimSpace(id3v1Genre.ReplaceAllString(value, ""))
+	if value == "" {
+		return nil
+	}
+	parts := []string{value}
+	if _, ok := t.aliases[Key(value)]; !ok {
+		parts = genreSeparator.Split(value, -1)
+	}
+
+	var resolved []string
+	seen := make(map[string]bool)
+	for _, part := range parts {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name := t.Canonical(part)
+		if key := Key(name); !seen[key] {
+			seen[key] = true
+			resolved = append(resolved, name)
+		}
+	}
+	return resolved
+}
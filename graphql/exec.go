@@ -0,0 +1,608 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// MaxDepth bounds how deeply a query may nest fields, since each level can
+// cost a query against the store.
+const MaxDepth = 12
+
+// Request is a GraphQL request, as clients post it.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Data is left out when the request
+// couldn't be executed at all, as with syntax errors.
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is an error in a response, with the path of the field that failed.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Execute runs a query. Fields that fail resolve to null, with an error.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return failed(err)
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return failed(err)
+	}
+	if op.Type != "query" {
+		return failed(fmt.Errorf("%s operations are not supported", op.Type))
+	}
+	e := &executor{schema: s, doc: doc}
+	if err := e.variables(op, req.Variables); err != nil {
+		return failed(err)
+	}
+	if err := e.validate(s.query, op.Selections, 1); err != nil {
+		return failed(err)
+	}
+	data := e.object(ctx, s.query, []interface{}{nil}, [][]interface{}{nil}, op.Selections)
+	return &Response{Data: data[0], Errors: e.errors}
+}
+
+func failed(err error) *Response {
+	return &Response{Errors: []*Error{{Message: err.Error()}}}
+}
+
+// operation picks the operation to run: the named one, or the only one.
+func (d *Document) operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return nil, fmt.Errorf("the document has several operations; name one with operationName")
+		}
+		return d.Operations[0], nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("no operation %s", name)
+}
+
+type executor struct {
+	schema *Schema
+	doc    *Document
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+func (e *executor) fail(path []interface{}, err error) {
+	e.errors = append(e.errors, &Error{Message: err.Error(), Path: path})
+}
+
+// variables coerces the request's variables to the operation's types.
+func (e *executor) variables(op *Operation, given map[string]interface{}) error {
+	e.vars = make(map[string]interface{})
+	for _, def := range op.Variables {
+		ref, err := parseTypeRef(def.Type)
+		if err != nil {
+			return fmt.Errorf("variable $%s: %v", def.Name, err)
+		}
+		if name := ref.named(); !scalars[name] && e.schema.inputs[name] == nil {
+			return fmt.Errorf("variable $%s: unknown type %s", def.Name, name)
+		}
+		value, ok := given[def.Name]
+		if !ok {
+			if def.Default == nil {
+				if ref.nonNull {
+					return fmt.Errorf("variable $%s of type %s is required", def.Name, def.Type)
+				}
+				continue
+			}
+			value, _ = e.plain(def.Default)
+		}
+		if e.vars[def.Name], err = e.schema.coerce(value, ref); err != nil {
+			return fmt.Errorf("variable $%s: %v", def.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a selection on an object type against the schema
+// before anything is executed.
+func (e *executor) validate(obj *Object, selections []Selection, depth int) error {
+	if depth > MaxDepth {
+		return fmt.Errorf("the query is nested more than %d levels deep", MaxDepth)
+	}
+	keys, fields, err := e.collect(obj, selections)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		for _, node := range fields[key] {
+			if node.Name != fields[key][0].Name {
+				return fmt.Errorf("fields %s and %s can't both be returned as %s", node.Name, fields[key][0].Name, key)
+			}
+			if node.Name == "__typename" {
+				if node.Selections != nil {
+					return fmt.Errorf("field __typename can't have a selection")
+				}
+				continue
+			}
+			def := obj.field(node.Name)
+			if def == nil {
+				return fmt.Errorf("type %s has no field %s", obj.Name, node.Name)
+			}
+			for _, arg := range node.Arguments {
+				if !hasArg(def.Args, arg.Name) {
+					return fmt.Errorf("field %s.%s has no argument %s", obj.Name, def.Name, arg.Name)
+				}
+			}
+			ref, _ := parseTypeRef(def.Type)
+			child := e.schema.objects[ref.named()]
+			switch {
+			case child == nil && node.Selections != nil:
+				return fmt.Errorf("field %s.%s of type %s can't have a selection", obj.Name, def.Name, def.Type)
+			case child != nil && node.Selections == nil:
+				return fmt.Errorf("field %s.%s of type %s needs a selection", obj.Name, def.Name, def.Type)
+			case child != nil:
+				if err := e.validate(child, node.Selections, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasArg(args []*Arg, name string) bool {
+	for _, arg := range args {
+		if arg.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// collect groups the fields selected on an object type by response key, in
+// query order, following fragments and applying @skip and @include.
+func (e *executor) collect(obj *Object, selections []Selection) ([]string, map[string][]*FieldSelection, error) {
+	var keys []string
+	fields := make(map[string][]*FieldSelection)
+	visited := make(map[string]bool)
+	var walk func([]Selection) error
+	walk = func(selections []Selection) error {
+		for _, selection := range selections {
+			var directives []*Directive
+			var typeCondition string
+			var nested []Selection
+			switch sel := selection.(type) {
+			case *FieldSelection:
+				directives = sel.Directives
+			case *FragmentSpread:
+				fragment, ok := e.doc.Fragments[sel.Name]
+				if !ok {
+					return fmt.Errorf("no fragment %s", sel.Name)
+				}
+				if visited[sel.Name] {
+					continue
+				}
+				visited[sel.Name] = true
+				directives, typeCondition, nested = sel.Directives, fragment.TypeCondition, fragment.Selections
+			case *InlineFragment:
+				directives, typeCondition, nested = sel.Directives, sel.TypeCondition, sel.Selections
+			}
+			ok, err := e.included(directives)
+			if err != nil {
+				return err
+			}
+			if applies, err := e.applies(typeCondition, obj); err != nil {
+				return err
+			} else if !ok || !applies {
+				continue
+			}
+			if field, isField := selection.(*FieldSelection); isField {
+				key := field.ResponseKey()
+				if _, ok := fields[key]; !ok {
+					keys = append(keys, key)
+				}
+				fields[key] = append(fields[key], field)
+				continue
+			}
+			if err := walk(nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := walk(selections)
+	return keys, fields, err
+}
+
+func (e *executor) applies(typeCondition string, obj *Object) (bool, error) {
+	if typeCondition == "" {
+		return true, nil
+	}
+	if e.schema.objects[typeCondition] == nil {
+		return false, fmt.Errorf("unknown type %s", typeCondition)
+	}
+	return typeCondition == obj.Name, nil
+}
+
+// included applies @skip(if:) and @include(if:).
+func (e *executor) included(directives []*Directive) (bool, error) {
+	for _, d := range directives {
+		if d.Name != "skip" && d.Name != "include" {
+			return false, fmt.Errorf("unknown directive @%s", d.Name)
+		}
+		args, err := e.arguments([]*Arg{{Name: "if", Type: "Boolean!"}}, d.Arguments)
+		if err != nil {
+			return false, fmt.Errorf("@%s: %v", d.Name, err)
+		}
+		if args["if"].(bool) == (d.Name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// object resolves a selection on objects of a type, field by field, each
+// for all the objects at once.
+func (e *executor) object(ctx context.Context, obj *Object, sources []interface{}, paths [][]interface{}, selections []Selection) []*result {
+	results := make([]*result, len(sources))
+	for i := range results {
+		results[i] = &result{values: make(map[string]interface{})}
+	}
+	// Selections were validated, which collects them the same way
+	keys, fields, _ := e.collect(obj, selections)
+	for _, key := range keys {
+		nodes := fields[key]
+		fieldPaths := make([][]interface{}, len(paths))
+		for i, path := range paths {
+			fieldPaths[i] = appendPath(path, key)
+		}
+		if nodes[0].Name == "__typename" {
+			for _, r := range results {
+				r.set(key, obj.Name)
+			}
+			continue
+		}
+		def := obj.field(nodes[0].Name)
+		var selections []Selection
+		for _, node := range nodes {
+			selections = append(selections, node.Selections...)
+		}
+		ref, _ := parseTypeRef(def.Type)
+		values := e.complete(ctx, ref, e.resolve(ctx, def, nodes[0], sources, fieldPaths), fieldPaths, selections)
+		for i, r := range results {
+			r.set(key, values[i])
+		}
+	}
+	return results
+}
+
+// resolve runs a field's resolver over the sources.
+func (e *executor) resolve(ctx context.Context, def *Field, node *FieldSelection, sources []interface{}, paths [][]interface{}) []interface{} {
+	values := make([]interface{}, len(sources))
+	args, err := e.arguments(def.Args, node.Arguments)
+	if err != nil {
+		e.fail(paths[0], err)
+		return values
+	}
+	switch {
+	case def.Batch != nil:
+		batch, err := def.Batch(ctx, sources, args)
+		if err == nil && len(batch) != len(sources) {
+			err = fmt.Errorf("resolved %d values for %d objects", len(batch), len(sources))
+		}
+		if err != nil {
+			e.fail(paths[0], err)
+			return values
+		}
+		return batch
+	case def.Resolve != nil:
+		for i, source := range sources {
+			if values[i], err = def.Resolve(ctx, source, args); err != nil {
+				e.fail(paths[i], err)
+			}
+		}
+	default:
+		for i, source := range sources {
+			if v := reflect.ValueOf(source); v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+				if value := v.MapIndex(reflect.ValueOf(def.Name).Convert(v.Type().Key())); value.IsValid() {
+					values[i] = value.Interface()
+				}
+			}
+		}
+	}
+	return values
+}
+
+// complete turns resolved values into response values: objects are
+// resolved further, all together, and lists element by element.
+func (e *executor) complete(ctx context.Context, ref *typeRef, values []interface{}, paths [][]interface{}, selections []Selection) []interface{} {
+	out := make([]interface{}, len(values))
+	switch {
+	case ref.elem != nil:
+		var items []interface{}
+		var itemPaths [][]interface{}
+		lengths := make([]int, len(values))
+		for i, value := range values {
+			lengths[i] = -1
+			if isNull(value) {
+				continue
+			}
+			v := reflect.ValueOf(value)
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				e.fail(paths[i], fmt.Errorf("expected a list, got %T", value))
+				continue
+			}
+			lengths[i] = v.Len()
+			for j := 0; j < v.Len(); j++ {
+				items = append(items, v.Index(j).Interface())
+				itemPaths = append(itemPaths, appendPath(paths[i], j))
+			}
+		}
+		completed := e.complete(ctx, ref.elem, items, itemPaths, selections)
+		for i, n := range lengths {
+			if n >= 0 {
+				out[i], completed = completed[:n:n], completed[n:]
+			}
+		}
+	case e.schema.objects[ref.name] != nil:
+		var objects []interface{}
+		var objectPaths [][]interface{}
+		var index []int
+		for i, value := range values {
+			if !isNull(value) {
+				objects = append(objects, value)
+				objectPaths = append(objectPaths, paths[i])
+				index = append(index, i)
+			}
+		}
+		if len(objects) > 0 {
+			for k, r := range e.object(ctx, e.schema.objects[ref.name], objects, objectPaths, selections) {
+				out[index[k]] = r
+			}
+		}
+	default:
+		for i, value := range values {
+			if !isNull(value) {
+				out[i] = value
+			}
+		}
+	}
+	return out
+}
+
+// arguments coerces the arguments of a field to their declared types,
+// applying defaults. Arguments that are neither given nor defaulted are
+// left out.
+func (e *executor) arguments(defs []*Arg, given []*Argument) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	for _, def := range defs {
+		ref, err := parseTypeRef(def.Type)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		present := false
+		for _, arg := range given {
+			if arg.Name == def.Name {
+				value, present = e.plain(arg.Value)
+			}
+		}
+		if !present {
+			value, present = def.Default, def.Default != nil
+		}
+		if !present || value == nil {
+			if ref.nonNull {
+				return nil, fmt.Errorf("argument %s of type %s is required", def.Name, def.Type)
+			}
+			if present {
+				args[def.Name] = nil
+			}
+			continue
+		}
+		if args[def.Name], err = e.schema.coerce(value, ref); err != nil {
+			return nil, fmt.Errorf("argument %s: %v", def.Name, err)
+		}
+	}
+	return args, nil
+}
+
+// plain converts a value in the document to the Go values variables
+// decode to, substituting variables. Unset variables are reported as not
+// present.
+func (e *executor) plain(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case Variable:
+		value, ok := e.vars[string(v)]
+		return value, ok
+	case EnumValue:
+		return string(v), true
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i], _ = e.plain(item)
+		}
+		return list, true
+	case ObjectValue:
+		object := make(map[string]interface{})
+		for _, field := range v {
+			if value, ok := e.plain(field.Value); ok {
+				object[field.Name] = value
+			}
+		}
+		return object, true
+	}
+	return value, true
+}
+
+// coerce converts an input value to a type: Int to int, Float to float64,
+// String and ID to string, Boolean to bool, lists to []interface{} and
+// input objects to map[string]interface{}.
+func (s *Schema) coerce(value interface{}, ref *typeRef) (interface{}, error) {
+	if value == nil {
+		if ref.nonNull {
+			return nil, fmt.Errorf("expected %s, got null", ref)
+		}
+		return nil, nil
+	}
+	if ref.elem != nil {
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if list[i], err = s.coerce(item, ref.elem); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+
+	switch ref.name {
+	case "Int":
+		if f, ok := number(value); ok && f == math.Trunc(f) && math.Abs(f) <= math.MaxInt32 {
+			return int(f), nil
+		}
+	case "Float":
+		if f, ok := number(value); ok {
+			return f, nil
+		}
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if f, ok := number(value); ok && f == math.Trunc(f) {
+			return strconv.FormatInt(int64(f), 10), nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	default:
+		input := s.inputs[ref.name]
+		fields, ok := value.(map[string]interface{})
+		if input == nil || !ok {
+			break
+		}
+		for name := range fields {
+			if !hasArg(input.Fields, name) {
+				return nil, fmt.Errorf("%s has no field %s", input.Name, name)
+			}
+		}
+		object := make(map[string]interface{})
+		for _, def := range input.Fields {
+			fieldRef, _ := parseTypeRef(def.Type)
+			value, ok := fields[def.Name]
+			if !ok {
+				value = def.Default
+			}
+			if value == nil && !fieldRef.nonNull {
+				continue
+			}
+			coerced, err := s.coerce(value, fieldRef)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", input.Name, def.Name, err)
+			}
+			object[def.Name] = coerced
+		}
+		return object, nil
+	}
+	return nil, fmt.Errorf("expected %s, got %s", ref, literal(value))
+}
+
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+func isNull(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(path)+1), path...), elem)
+}
+
+// result is an object in the response, keeping its fields in query order.
+type result struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (r *result) set(key string, value interface{}) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+}
+
+func (r *result) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
@@ -0,0 +1,716 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed query document.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query, with its variables.
+type Operation struct {
+	Type       string // query, mutation or subscription
+	Name       string
+	Variables  []*VariableDefinition
+	Selections []Selection
+}
+
+// VariableDefinition declares a variable of an operation.
+type VariableDefinition struct {
+	Name    string
+	Type    string
+	Default interface{}
+}
+
+// Fragment is a named fragment.
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	Selections    []Selection
+}
+
+// Selection is a *FieldSelection, *FragmentSpread or *InlineFragment.
+type Selection interface{}
+
+// FieldSelection is a selected field.
+type FieldSelection struct {
+	Alias      string
+	Name       string
+	Arguments  []*Argument
+	Directives []*Directive
+	Selections []Selection
+}
+
+// ResponseKey is the name of the field in the result.
+func (f *FieldSelection) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// FragmentSpread is a use of a named fragment.
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+}
+
+// InlineFragment is a selection set for objects of a type.
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	Selections    []Selection
+}
+
+// Directive is a directive such as @skip(if: $x).
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+}
+
+// Argument is an argument of a field or directive, or a field of an input
+// object value.
+type Argument struct {
+	Name  string
+	Value interface{}
+}
+
+// Values in a document are nil, bool, int64, float64, string, EnumValue,
+// Variable, []interface{} or ObjectValue.
+type (
+	Variable    string
+	EnumValue   string
+	ObjectValue []*Argument
+)
+
+// Parse parses a query document.
+func Parse(query string) (*Document, error) {
+	p := &parser{lexer: lexer{src: query}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &Document{Fragments: make(map[string]*Fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek(tokPunct, "{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", Selections: selections})
+		case p.peek(tokName, "query"), p.peek(tokName, "mutation"), p.peek(tokName, "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.peek(tokName, "fragment"):
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Fragments[fragment.Name]; ok {
+				return nil, fmt.Errorf("fragment %s is defined twice", fragment.Name)
+			}
+			doc.Fragments[fragment.Name] = fragment
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("the document has no operation")
+	}
+	return doc, nil
+}
+
+type parser struct {
+	lexer lexer
+	tok   token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+// skip consumes the token if it is the punctuator, reporting whether it was.
+func (p *parser) skip(punct string) (bool, error) {
+	if !p.peek(tokPunct, punct) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(tokPunct, punct) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("syntax error: unexpected end of query")
+	}
+	return fmt.Errorf("syntax error at %s: unexpected %q", p.lexer.position(p.tok.pos), p.tok.value)
+}
+
+func (p *parser) operation() (*Operation, error) {
+	op := &Operation{Type: p.tok.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.Name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(tokPunct, ")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.Variables = append(op.Variables, def)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = selections
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (*VariableDefinition, error) {
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	typ, err := p.typeName()
+	if err != nil {
+		return nil, err
+	}
+	def := &VariableDefinition{Name: name, Type: typ}
+	if ok, err := p.skip("="); err != nil {
+		return nil, err
+	} else if ok {
+		if def.Default, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	return def, nil
+}
+
+// typeName parses a type reference such as [ID!]! back to its text.
+func (p *parser) typeName() (string, error) {
+	var typ string
+	if ok, err := p.skip("["); err != nil {
+		return "", err
+	} else if ok {
+		elem, err := p.typeName()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + elem + "]"
+	} else if typ, err = p.name(); err != nil {
+		return "", err
+	}
+	if ok, err := p.skip("!"); err != nil {
+		return "", err
+	} else if ok {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, fmt.Errorf("syntax error: a fragment can't be named on")
+	}
+	if !p.peek(tokName, "on") {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	fragment := &Fragment{Name: name}
+	if fragment.TypeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if fragment.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if fragment.Selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return fragment, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []Selection
+	for {
+		if ok, err := p.skip("}"); err != nil {
+			return nil, err
+		} else if ok {
+			break
+		}
+		selection, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("syntax error: empty selection set")
+	}
+	return selections, nil
+}
+
+func (p *parser) selection() (Selection, error) {
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == tokName && p.tok.value != "on" {
+			spread := &FragmentSpread{Name: p.tok.value}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			spread.Directives, err = p.directives()
+			return spread, err
+		}
+		fragment := &InlineFragment{}
+		if p.peek(tokName, "on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if fragment.TypeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if fragment.Directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		fragment.Selections, err = p.selectionSet()
+		return fragment, err
+	}
+
+	field := &FieldSelection{}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		field.Alias = name
+		if name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+	if field.Arguments, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if field.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokPunct, "{") {
+		if field.Selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (p *parser) arguments(constant bool) ([]*Argument, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+	var args []*Argument
+	for {
+		if ok, err := p.skip(")"); err != nil {
+			return nil, err
+		} else if ok {
+			return args, nil
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &Argument{Name: name, Value: value})
+	}
+}
+
+func (p *parser) directives() ([]*Directive, error) {
+	var directives []*Directive
+	for p.peek(tokPunct, "@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments(false)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, &Directive{Name: name, Arguments: args})
+	}
+	return directives, nil
+}
+
+// value parses a value; constant values, such as variable defaults, can't
+// refer to variables.
+func (p *parser) value(constant bool) (interface{}, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokPunct && tok.value == "$" && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return Variable(name), err
+	case tok.kind == tokPunct && tok.value == "[":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for {
+			if ok, err := p.skip("]"); err != nil {
+				return nil, err
+			} else if ok {
+				return list, nil
+			}
+			value, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+	case tok.kind == tokPunct && tok.value == "{":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		object := ObjectValue{}
+		for {
+			if ok, err := p.skip("}"); err != nil {
+				return nil, err
+			} else if ok {
+				return object, nil
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, &Argument{Name: name, Value: value})
+		}
+	case tok.kind == tokInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad integer %s", tok.value)
+		}
+		return n, p.advance()
+	case tok.kind == tokFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %s", tok.value)
+		}
+		return f, p.advance()
+	case tok.kind == tokString:
+		return tok.value, p.advance()
+	case tok.kind == tokName:
+		var value interface{}
+		switch tok.value {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			value = EnumValue(tok.value)
+		}
+		return value, p.advance()
+	}
+	return nil, p.unexpected()
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+// position describes an offset in the query as line:column.
+func (l *lexer) position(offset int) string {
+	before := l.src[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+	return fmt.Sprintf("%d:%d", line, column)
+}
+
+func (l *lexer) next() (token, error) {
+	// Whitespace, commas, comments and a byte order mark are insignificant
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+			continue
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		case strings.HasPrefix(l.src[l.pos:], "\uFEFF"):
+			l.pos += len("\uFEFF")
+			continue
+		}
+		break
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokPunct, value: "...", pos: start}, nil
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, value: string(c), pos: start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, value: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.blockString()
+		}
+		return l.string()
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, fmt.Errorf("syntax error at %s: unexpected character %q", l.position(start), r)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() int {
+		from := l.pos
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.pos - from
+	}
+	if digits() == 0 {
+		return token{}, fmt.Errorf("syntax error at %s: bad number", l.position(start))
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokFloat
+		l.pos++
+		if digits() == 0 {
+			return token{}, fmt.Errorf("syntax error at %s: bad number", l.position(start))
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return token{}, fmt.Errorf("syntax error at %s: bad number", l.position(start))
+		}
+	}
+	return token{kind: kind, value: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokString, value: b.String(), pos: start}, nil
+		case c == '\n' || c == '\r':
+			return token{}, fmt.Errorf("syntax error at %s: unterminated string", l.position(start))
+		case c == '\\' && l.pos+1 < len(l.src):
+			escape := l.src[l.pos+1]
+			l.pos += 2
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return token{}, fmt.Errorf("syntax error at %s: bad escape", l.position(l.pos))
+				}
+				code, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return token{}, fmt.Errorf("syntax error at %s: bad escape", l.position(l.pos))
+				}
+				b.WriteRune(rune(code))
+				l.pos += 4
+			default:
+				return token{}, fmt.Errorf("syntax error at %s: bad escape \\%c", l.position(l.pos-2), escape)
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return token{}, fmt.Errorf("syntax error at %s: unterminated string", l.position(start))
+}
+
+// blockString lexes a """block string""", removing the common indentation
+// of its lines and its blank first and last lines.
+func (l *lexer) blockString() (token, error) {
+	start := l.pos
+	l.pos += 3
+	end := -1
+	for i := l.pos; i+3 <= len(l.src); i++ {
+		if l.src[i] == '\\' && strings.HasPrefix(l.src[i+1:], `"""`) {
+			i += 3
+			continue
+		}
+		if strings.HasPrefix(l.src[i:], `"""`) {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return token{}, fmt.Errorf("syntax error at %s: unterminated string", l.position(start))
+	}
+	raw := strings.ReplaceAll(l.src[l.pos:end], `\"""`, `"""`)
+	l.pos = end + 3
+
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		lines[i] = lines[i][min(indent, len(lines[i])):]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return token{kind: tokString, value: strings.Join(lines, "\n"), pos: start}, nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
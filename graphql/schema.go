@@ -0,0 +1,244 @@
+// Package graphql executes GraphQL queries against a schema of Go
+// resolvers. Execution is breadth-first: a field is resolved for every
+// object at its depth at once, so batch resolvers can load what all of
+// their parents need with one query instead of one per parent.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Built-in scalar types. Resolvers return leaf values as they should be
+// encoded to JSON.
+var scalars = map[string]bool{"ID": true, "String": true, "Int": true, "Float": true, "Boolean": true}
+
+// ResolveFunc resolves a field of one object.
+type ResolveFunc func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// BatchFunc resolves a field of many objects at once, returning a value
+// per source, in order.
+type BatchFunc func(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error)
+
+// Object is an object type.
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+// Field is a field of an object type. Type is a type reference in schema
+// notation, such as "[Album!]!". Fields without a resolver read the key of
+// their name from map[string]interface{} sources.
+type Field struct {
+	Name        string
+	Description string
+	Type        string
+	Args        []*Arg
+	Resolve     ResolveFunc
+	Batch       BatchFunc
+}
+
+// Arg is an argument of a field, or a field of an input object type.
+type Arg struct {
+	Name        string
+	Description string
+	Type        string
+	Default     interface{}
+}
+
+// Input is an input object type, for structured arguments such as filters.
+type Input struct {
+	Name        string
+	Description string
+	Fields      []*Arg
+}
+
+// Schema is an executable schema: its query type and the types used by it.
+type Schema struct {
+	query   *Object
+	objects map[string]*Object
+	inputs  map[string]*Input
+	order   []string
+}
+
+// NewSchema creates a schema from the query type and the *Object and
+// *Input types it refers to, checking that every reference resolves.
+func NewSchema(query *Object, types ...interface{}) (*Schema, error) {
+	s := &Schema{query: query, objects: make(map[string]*Object), inputs: make(map[string]*Input)}
+	for _, t := range append([]interface{}{query}, types...) {
+		var name string
+		switch t := t.(type) {
+		case *Object:
+			name = t.Name
+			s.objects[name] = t
+		case *Input:
+			name = t.Name
+			s.inputs[name] = t
+		default:
+			return nil, fmt.Errorf("unsupported type %T", t)
+		}
+		if scalars[name] || containsString(s.order, name) {
+			return nil, fmt.Errorf("type %s is defined twice", name)
+		}
+		s.order = append(s.order, name)
+	}
+
+	for _, obj := range s.objects {
+		for _, f := range obj.Fields {
+			ref, err := parseTypeRef(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", obj.Name, f.Name, err)
+			}
+			if name := ref.named(); !scalars[name] && s.objects[name] == nil {
+				return nil, fmt.Errorf("%s.%s: unknown output type %s", obj.Name, f.Name, name)
+			}
+			if f.Resolve != nil && f.Batch != nil {
+				return nil, fmt.Errorf("%s.%s: has both a resolver and a batch resolver", obj.Name, f.Name)
+			}
+			if err := s.checkArgs(f.Args); err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", obj.Name, f.Name, err)
+			}
+		}
+	}
+	for _, input := range s.inputs {
+		if err := s.checkArgs(input.Fields); err != nil {
+			return nil, fmt.Errorf("%s: %v", input.Name, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) checkArgs(args []*Arg) error {
+	for _, arg := range args {
+		ref, err := parseTypeRef(arg.Type)
+		if err != nil {
+			return fmt.Errorf("argument %s: %v", arg.Name, err)
+		}
+		if name := ref.named(); !scalars[name] && s.inputs[name] == nil {
+			return fmt.Errorf("argument %s: unknown input type %s", arg.Name, name)
+		}
+	}
+	return nil
+}
+
+func (o *Object) field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// String returns the schema in the GraphQL schema language.
+func (s *Schema) String() string {
+	var b strings.Builder
+	b.WriteString("schema {\n  query: " + s.query.Name + "\n}\n")
+	for _, name := range s.order {
+		b.WriteString("\n")
+		if obj, ok := s.objects[name]; ok {
+			writeDescription(&b, "", obj.Description)
+			b.WriteString("type " + obj.Name + " {\n")
+			for _, f := range obj.Fields {
+				writeDescription(&b, "  ", f.Description)
+				b.WriteString("  " + f.Name)
+				if len(f.Args) > 0 {
+					var args []string
+					for _, arg := range f.Args {
+						args = append(args, argString(arg))
+					}
+					b.WriteString("(" + strings.Join(args, ", ") + ")")
+				}
+				b.WriteString(": " + f.Type + "\n")
+			}
+		} else {
+			input := s.inputs[name]
+			writeDescription(&b, "", input.Description)
+			b.WriteString("input " + input.Name + " {\n")
+			for _, f := range input.Fields {
+				writeDescription(&b, "  ", f.Description)
+				b.WriteString("  " + argString(f) + "\n")
+			}
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		b.WriteString(indent + strconv.Quote(description) + "\n")
+	}
+}
+
+func argString(arg *Arg) string {
+	s := arg.Name + ": " + arg.Type
+	if arg.Default != nil {
+		s += " = " + literal(arg.Default)
+	}
+	return s
+}
+
+// literal writes a default value as a GraphQL literal.
+func literal(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			items = append(items, literal(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
+
+// typeRef is a parsed type reference: a named type, or a list of elem,
+// possibly non-null.
+type typeRef struct {
+	name    string
+	elem    *typeRef
+	nonNull bool
+}
+
+func parseTypeRef(s string) (*typeRef, error) {
+	ref := &typeRef{}
+	if strings.HasSuffix(s, "!") {
+		ref.nonNull = true
+		s = s[:len(s)-1]
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		elem, err := parseTypeRef(s[1 : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		ref.elem = elem
+		return ref, nil
+	}
+	if s == "" || strings.ContainsAny(s, "[]! ") {
+		return nil, fmt.Errorf("bad type %q", s)
+	}
+	ref.name = s
+	return ref, nil
+}
+
+// named returns the named type at the bottom of a reference.
+func (t *typeRef) named() string {
+	for t.elem != nil {
+		t = t.elem
+	}
+	return t.name
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package lyrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// readSYLT returns the synchronized lyrics (ID3v2.3/2.4 SYLT frame) of an
+// MP3 file, or nil if it has none. ffprobe doesn't expose SYLT, so the tag
+// is read directly. Only millisecond timestamps are supported.
+func readSYLT(path string) ([]Line, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:3]) != "ID3" {
+		return nil, "", nil
+	}
+	version, flags := header[3], header[5]
+	if version != 3 && version != 4 {
+		return nil, "", nil
+	}
+	tag := make([]byte, syncsafe(header[6:10]))
+	if _, err := io.ReadFull(f, tag); err != nil {
+		return nil, "", fmt.Errorf("error reading ID3 tag: %w", err)
+	}
+	if version == 3 && flags&0x80 != 0 {
+		tag = bytes.ReplaceAll(tag, []byte{0xff, 0x00}, []byte{0xff})
+	}
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		size := int(binary.BigEndian.Uint32(tag))
+		if version == 3 {
+			size += 4
+		} else {
+			size = syncsafe(tag[:4])
+		}
+		if size > len(tag) {
+			return nil, "", nil
+		}
+		tag = tag[size:]
+	}
+
+	for len(tag) >= 10 && tag[0] != 0 {
+		id := string(tag[:4])
+		size := int(binary.BigEndian.Uint32(tag[4:8]))
+		if version == 4 {
+			size = syncsafe(tag[4:8])
+		}
+		if size > len(tag)-10 {
+			break
+		}
+		frame := tag[10 : 10+size]
+		tag = tag[10+size:]
+		if id == "SYLT" {
+			if lines, language := parseSYLT(frame); len(lines) > 0 {
+				return lines, language, nil
+			}
+		}
+	}
+	return nil, "", nil
+}
+
+// parseSYLT decodes a SYLT frame. Entries that don't start a new line (no
+// leading line break) are appended to the previous line, since karaoke
+// taggers time individual syllables.
+func parseSYLT(frame []byte) ([]Line, string) {
+	if len(frame) < 6 {
+		return nil, ""
+	}
+	encoding, language, format := frame[0], string(frame[1:4]), frame[4]
+	if format != 2 {
+		return nil, ""
+	}
+	_, rest := readText(frame[6:], encoding)
+
+	var lines []Line
+	for len(rest) > 0 {
+		var text string
+		text, rest = readText(rest, encoding)
+		if len(rest) < 4 {
+			break
+		}
+		t := time.Duration(binary.BigEndian.Uint32(rest)) * time.Millisecond
+		rest = rest[4:]
+
+		newLine := strings.HasPrefix(text, "\n") || strings.HasPrefix(text, "\r")
+		if len(lines) > 0 && !newLine {
+			lines[len(lines)-1].Text += text
+			continue
+		}
+		lines = append(lines, Line{Time: t, Text: text})
+	}
+	for i := range lines {
+		lines[i].Text = strings.TrimSpace(lines[i].Text)
+	}
+	return lines, strings.TrimSpace(language)
+}
+
+// readText reads a terminated string in an ID3 text encoding and returns it
+// with the remaining bytes.
+func readText(data []byte, encoding byte) (string, []byte) {
+	switch encoding {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		end := len(data)
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+		text := decodeUTF16(data[:end], encoding == 2)
+		return text, data[min(end+2, len(data)):]
+	default: // ISO-8859-1, UTF-8
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			end = len(data)
+		}
+		text := string(data[:end])
+		if encoding == 0 {
+			text, _ = charmap.ISO8859_1.NewDecoder().String(text)
+		}
+		return text, data[min(end+1, len(data)):]
+	}
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	if len(data) >= 2 {
+		switch {
+		case data[0] == 0xff && data[1] == 0xfe:
+			bigEndian, data = false, data[2:]
+		case data[0] == 0xfe && data[1] == 0xff:
+			bigEndian, data = true, data[2:]
+		}
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
@@ -0,0 +1,85 @@
+package lyrics
+
+import (
+	"bufio"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// [mm:ss], [mm:ss.xx] or [mm:ss:xx]; a line may carry several.
+	lrcTimestamp = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	// [ar:Artist], [ti:Title], [offset:+250] and other ID tags.
+	lrcTag = regexp.MustCompile(`^\[([a-zA-Z#]+):(.*)\]\s*$`)
+	// Enhanced LRC word timings, <mm:ss.xx>, inside a line.
+	lrcWordTimestamp = regexp.MustCompile(`<\d+:\d{1,2}(?:[.:]\d{1,3})?>`)
+)
+
+// ParseLRC parses LRC lyrics into timed lines, sorted by time, and the ID
+// tags of the file ("ar", "ti", "al", ...). The [offset:] tag, in
+// milliseconds, is applied to every line; positive values make lines appear
+// sooner. ok is false when the text has no timestamps, i.e. isn't LRC.
+func ParseLRC(text string) (lines []Line, metadata map[string]string, ok bool) {
+	metadata = make(map[string]string)
+	var offset time.Duration
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(text, "\ufeff")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		var times []time.Duration
+		for {
+			match := lrcTimestamp.FindStringSubmatch(line)
+			if match == nil {
+				break
+			}
+			times = append(times, parseLRCTime(match[1], match[2], match[3]))
+			line = line[len(match[0]):]
+		}
+		if len(times) == 0 {
+			if match := lrcTag.FindStringSubmatch(line); match != nil {
+				key, value := strings.ToLower(match[1]), strings.TrimSpace(match[2])
+				if key == "offset" {
+					ms, _ := strconv.Atoi(strings.TrimPrefix(value, "+"))
+					offset = time.Duration(ms) * time.Millisecond
+				} else if value != "" {
+					metadata[key] = value
+				}
+			}
+			continue
+		}
+
+		lyric := strings.TrimSpace(lrcWordTimestamp.ReplaceAllString(line, ""))
+		for _, t := range times {
+			lines = append(lines, Line{Time: t, Text: lyric})
+		}
+	}
+	if len(lines) == 0 {
+		return nil, metadata, false
+	}
+
+	for i := range lines {
+		lines[i].Time = max(lines[i].Time-offset, 0)
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Time < lines[j].Time })
+	return lines, metadata, true
+}
+
+// parseLRCTime converts minutes, seconds and a fraction of a second given
+// in hundredths (2 digits) or milliseconds (3 digits).
+func parseLRCTime(minutes, seconds, fraction string) time.Duration {
+	m, _ := strconv.Atoi(minutes)
+	s, _ := strconv.Atoi(seconds)
+	t := time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if fraction != "" {
+		f, _ := strconv.Atoi(fraction)
+		for i := len(fraction); i < 3; i++ {
+			f *= 10
+		}
+		t += time.Duration(f) * time.Millisecond
+	}
+	return t
+}
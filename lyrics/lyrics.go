@@ -0,0 +1,204 @@
+package lyrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/tracks"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// Where lyrics were found.
+const (
+	SourceLRC      = "lrc"      // .lrc file next to the audio file
+	SourceSYLT     = "sylt"     // ID3 synchronized lyrics frame
+	SourceEmbedded = "embedded" // USLT, LYRICS/UNSYNCEDLYRICS or ©lyr tag
+)
+
+// Line is one timed line of synchronized lyrics.
+type Line struct {
+	Time time.Duration `bson:"time"`
+	Text string        `bson:"text"`
+}
+
+// Lyrics is a track's lyrics in the `lyrics` collection, keyed by track ID.
+// Text is always set; Lines only for synchronized lyrics.
+type Lyrics struct {
+	TrackID   primitive.ObjectID `bson:"_id"`
+	Source    string             `bson:"source"`
+	Language  string             `bson:"language,omitempty"`
+	Synced    bool               `bson:"synced"`
+	Text      string             `bson:"text"`
+	Lines     []Line             `bson:"lines,omitempty"`
+	Metadata  map[string]string  `bson:"metadata,omitempty"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+}
+
+// Extract finds the lyrics of an audio file, preferring synchronized ones:
+// a matching .lrc sidecar, then an ID3 SYLT frame, then embedded lyrics
+// tags (which are parsed as LRC when they carry timestamps). It returns nil
+// when the file has no lyrics.
+func Extract(filePath string, tags map[string]string) (*Lyrics, error) {
+	if sidecar := findSidecar(filePath); sidecar != "" {
+		data, err := os.ReadFile(sidecar)
+		if err != nil {
+			return nil, err
+		}
+		if l := fromText(string(data), SourceLRC, ""); l != nil {
+			return l, nil
+		}
+	}
+
+	if strings.EqualFold(filepath.Ext(filePath), ".mp3") {
+		lines, language, err := readSYLT(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) > 0 {
+			return &Lyrics{Source: SourceSYLT, Language: language, Synced: true, Lines: lines, Text: joinLines(lines)}, nil
+		}
+	}
+
+	if text, language := findLyricsTag(tags); text != "" {
+		return fromText(text, SourceEmbedded, language), nil
+	}
+	return nil, nil
+}
+
+// Segment returns the lyrics of part of a file, for tracks split from a
+// single-file rip: the lines between start and end (0 for the end of the
+// file), shifted to start at 0. Unsynchronized lyrics can't be split, so
+// nil is returned for them.
+func (l *Lyrics) Segment(start, end time.Duration) *Lyrics {
+	if l == nil || !l.Synced {
+		return nil
+	}
+	segment := *l
+	segment.Lines = nil
+	for _, line := range l.Lines {
+		if line.Time >= start && (end == 0 || line.Time < end) {
+			segment.Lines = append(segment.Lines, Line{Time: line.Time - start, Text: line.Text})
+		}
+	}
+	if len(segment.Lines) == 0 {
+		return nil
+	}
+	segment.Text = joinLines(segment.Lines)
+	return &segment
+}
+
+// UpdateLyrics extracts a track's lyrics and stores them, setting the
+// track's `hasLyrics` and `syncedLyrics` flags. It reports whether lyrics
+// were found.
+func UpdateLyrics(db *mongo.Database, track map[string]interface{}) (bool, error) {
+	trackID, _ := track["_id"].(primitive.ObjectID)
+
+	l, err := Extract(tracks.FilePath(track), utils.TrackTags(track))
+	if err != nil {
+		return false, err
+	}
+	if tracks.IsCueTrack(track) {
+		l = l.Segment(tracks.Segment(track))
+	}
+	return l != nil, Store(db, trackID, l)
+}
+
+// Store saves a track's lyrics, or removes them when l is nil, and updates
+// the flags on the track.
+func Store(db *mongo.Database, trackID primitive.ObjectID, l *Lyrics) error {
+	ctx := context.Background()
+	lyricsCollection := db.Collection("lyrics")
+
+	flags := bson.M{"hasLyrics": l != nil, "syncedLyrics": l != nil && l.Synced}
+	if l == nil {
+		if _, err := lyricsCollection.DeleteOne(ctx, bson.M{"_id": trackID}); err != nil {
+			return err
+		}
+	} else {
+		l.TrackID = trackID
+		l.UpdatedAt = time.Now()
+		_, err := lyricsCollection.ReplaceOne(ctx, bson.M{"_id": trackID}, l, options.Replace().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.Collection("tracks").UpdateOne(ctx, bson.M{"_id": trackID}, bson.M{"$set": flags})
+	return err
+}
+
+// Find returns a track's lyrics, or nil if it has none.
+func Find(db *mongo.Database, trackID primitive.ObjectID) (*Lyrics, error) {
+	var l Lyrics
+	err := db.Collection("lyrics").FindOne(context.Background(), bson.M{"_id": trackID}).Decode(&l)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// fromText builds lyrics from text that may or may not be LRC.
+func fromText(text, source, language string) *Lyrics {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if lines, metadata, ok := ParseLRC(text); ok {
+		if language == "" {
+			language = metadata["la"]
+		}
+		return &Lyrics{Source: source, Language: language, Synced: true, Lines: lines, Text: joinLines(lines), Metadata: metadata}
+	}
+	return &Lyrics{Source: source, Language: language, Text: text}
+}
+
+// findSidecar returns the .lrc file with the same name as the audio file.
+func findSidecar(filePath string) string {
+	dir := filepath.Dir(filePath)
+	stem := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.EqualFold(filepath.Ext(name), ".lrc") && strings.EqualFold(strings.TrimSuffix(name, filepath.Ext(name)), stem) {
+			return filepath.Join(dir, name)
+		}
+	}
+	return ""
+}
+
+// findLyricsTag returns embedded lyrics and their language. ffprobe reports
+// ID3 USLT frames as "lyrics-eng" (by language), Vorbis comments as LYRICS
+// or UNSYNCEDLYRICS and MP4 ©lyr as "lyrics".
+func findLyricsTag(tags map[string]string) (string, string) {
+	if text := utils.FindTagValue(tags, "lyrics", "unsyncedlyrics", "unsynced lyrics"); text != "" {
+		return text, ""
+	}
+	for key, value := range tags {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "lyrics-") && strings.TrimSpace(value) != "" {
+			return value, strings.TrimPrefix(lower, "lyrics-")
+		}
+	}
+	return "", ""
+}
+
+func joinLines(lines []Line) string {
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,392 @@
+package matcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/albums"
+	"github.com/ksuayan/go-tracks/fileinfo"
+	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/musicbrainz"
+	"github.com/ksuayan/go-tracks/tracks"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+const (
+	// DefaultAutoApply is the score at or above which a match is applied
+	// without review, provided it beats the runner-up by minMargin.
+	DefaultAutoApply = 0.85
+	// DefaultMinScore is the score below which candidates are discarded.
+	DefaultMinScore = 0.5
+
+	minMargin     = 0.05
+	maxCandidates = 5
+	// Track lengths further apart than this contribute nothing to the score.
+	maxLengthDiff = 15 * time.Second
+)
+
+// Candidate is a scored MusicBrainz release for an album.
+type Candidate struct {
+	ReleaseID   string  `bson:"releaseId"`
+	Title       string  `bson:"title"`
+	Artist      string  `bson:"artist"`
+	Date        string  `bson:"date,omitempty"`
+	Country     string  `bson:"country,omitempty"`
+	TrackCount  int     `bson:"trackCount"`
+	Score       float64 `bson:"score"`
+	LengthScore float64 `bson:"lengthScore"`
+	CountScore  float64 `bson:"countScore"`
+	TitleScore  float64 `bson:"titleScore"`
+}
+
+// QueueEntry is an album waiting for a manual match decision, stored in the
+// `matchqueue` collection keyed by album ID.
+type QueueEntry struct {
+	AlbumID     primitive.ObjectID `bson:"_id"`
+	Album       string             `bson:"album"`
+	AlbumArtist string             `bson:"albumArtist"`
+	TrackCount  int                `bson:"trackCount"`
+	Candidates  []Candidate        `bson:"candidates"`
+	Status      string             `bson:"status"`
+	UpdatedAt   time.Time          `bson:"updatedAt"`
+}
+
+// Outcome of matching a single album.
+const (
+	Applied   = "applied"
+	Queued    = "pending"
+	Skipped   = "skipped"
+	Unmatched = "unmatched"
+)
+
+// Matcher searches MusicBrainz for albums whose files carry no MBIDs.
+type Matcher struct {
+	db        *mongo.Database
+	mb        *musicbrainz.Client
+	AutoApply float64
+	MinScore  float64
+}
+
+// albumTrack is a track document with its ID, decoded for matching.
+type albumTrack struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	fileinfo.FileInfo `bson:",inline"`
+}
+
+func New(db *mongo.Database, mb *musicbrainz.Client) *Matcher {
+	return &Matcher{db: db, mb: mb, AutoApply: DefaultAutoApply, MinScore: DefaultMinScore}
+}
+
+// Run matches up to limit albums (0 for all) that have no release MBID and
+// are not already waiting in the queue, returning a count per outcome.
+func (m *Matcher) Run(limit int) (map[string]int, error) {
+	queued, err := m.db.Collection("matchqueue").Distinct(context.Background(), "_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"musicbrainz.releaseId": bson.M{"$exists": false},
+		"_id":                   bson.M{"$nin": queued},
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := m.db.Collection("albums").Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	counts := map[string]int{}
+	for cursor.Next(context.Background()) {
+		var album struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&album); err != nil {
+			return counts, err
+		}
+		outcome, err := m.MatchAlbum(album.ID.Hex())
+		if err != nil {
+			log.Printf("Error matching album %s: %v\n", album.ID.Hex(), err)
+			continue
+		}
+		counts[outcome]++
+	}
+	return counts, cursor.Err()
+}
+
+// MatchAlbum scores candidate releases for an album. A confident match is
+// applied immediately; ambiguous candidates are queued for review.
+func (m *Matcher) MatchAlbum(albumID string) (string, error) {
+	album, artist, local, err := m.loadAlbum(albumID)
+	if err != nil {
+		return "", err
+	}
+	if len(local) == 0 {
+		return Unmatched, nil
+	}
+
+	candidates, err := m.candidates(album, artist, local)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		log.Printf("No MusicBrainz match for %s - %s\n", artist, album)
+		return Unmatched, nil
+	}
+
+	best := candidates[0]
+	margin := best.Score
+	if len(candidates) > 1 {
+		margin = best.Score - candidates[1].Score
+	}
+	if best.Score >= m.AutoApply && margin >= minMargin {
+		log.Printf("Matched %s - %s to release %s (score %.2f)\n", artist, album, best.ReleaseID, best.Score)
+		return Applied, m.Apply(albumID, best.ReleaseID, "search")
+	}
+
+	log.Printf("Queued %s - %s for review (best score %.2f)\n", artist, album, best.Score)
+	entry := QueueEntry{
+		AlbumID:     mongodb.SafeObjectIDFromHex(albumID),
+		Album:       album,
+		AlbumArtist: artist,
+		TrackCount:  len(local),
+		Candidates:  candidates,
+		Status:      Queued,
+		UpdatedAt:   time.Now(),
+	}
+	_, err = m.db.Collection("matchqueue").ReplaceOne(context.Background(),
+		bson.M{"_id": entry.AlbumID}, entry, options.Replace().SetUpsert(true))
+	return Queued, err
+}
+
+// candidates searches MusicBrainz for an album and returns the releases
+// scoring at least MinScore, best first.
+func (m *Matcher) candidates(album, artist string, local []albumTrack) ([]Candidate, error) {
+	results, err := m.mb.SearchReleases(album, artist, len(local), maxCandidates*2)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []Candidate
+	for _, result := range results {
+		if len(candidates) >= maxCandidates {
+			break
+		}
+		release, err := m.mb.LookupRelease(result.ID)
+		if err != nil {
+			log.Printf("Error fetching release %s: %v\n", result.ID, err)
+			continue
+		}
+		candidate := score(album, artist, local, release)
+		if candidate.Score >= m.MinScore {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates, nil
+}
+
+// score compares an album's files against a release: track lengths count for
+// half the score, track count and title/artist similarity for the rest.
+func score(album, artist string, local []albumTrack, release *musicbrainz.Release) Candidate {
+	remote := releaseTracks(release)
+	releaseArtist := musicbrainz.CreditedName(release.ArtistCredit)
+
+	countScore := 0.0
+	if longest := max(len(local), len(remote)); longest > 0 {
+		countScore = 1 - math.Abs(float64(len(local)-len(remote)))/float64(longest)
+	}
+
+	lengthScore := 0.0
+	for i, track := range local {
+		if i >= len(remote) {
+			break
+		}
+		if remote[i].Length == 0 {
+			lengthScore += 0.5
+			continue
+		}
+		diff := track.Length - time.Duration(remote[i].Length)*time.Millisecond
+		lengthScore += math.Max(0, 1-math.Abs(float64(diff))/float64(maxLengthDiff))
+	}
+	if len(local) > 0 {
+		lengthScore /= float64(max(len(local), len(remote)))
+	}
+
+	titleScore := 0.7*utils.Similarity(album, release.Title) + 0.3*utils.Similarity(artist, releaseArtist)
+
+	return Candidate{
+		ReleaseID:   release.ID,
+		Title:       release.Title,
+		Artist:      releaseArtist,
+		Date:        release.Date,
+		Country:     release.Country,
+		TrackCount:  len(remote),
+		Score:       0.5*lengthScore + 0.2*countScore + 0.3*titleScore,
+		LengthScore: lengthScore,
+		CountScore:  countScore,
+		TitleScore:  titleScore,
+	}
+}
+
+// Apply assigns a release to an album: each file is paired with the release
+// track in the same position, then album and tracks are enriched. If another
+// album already holds the release, the tracks are moved onto it.
+func (m *Matcher) Apply(albumID, releaseID, matchedBy string) error {
+	release, err := m.mb.LookupRelease(releaseID)
+	if err != nil {
+		return err
+	}
+	_, _, local, err := m.loadAlbum(albumID)
+	if err != nil {
+		return err
+	}
+
+	albumsCollection := m.db.Collection("albums")
+	tracksCollection := m.db.Collection("tracks")
+	objectID := mongodb.SafeObjectIDFromHex(albumID)
+	// The album the release ends up on; the queue entry stays the original's
+	targetID := objectID
+
+	var existing struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err = albumsCollection.FindOne(context.Background(),
+		bson.M{"musicbrainz.releaseId": releaseID, "_id": bson.M{"$ne": objectID}}).Decode(&existing)
+	switch {
+	case err == nil:
+		if _, err := tracksCollection.UpdateMany(context.Background(),
+			bson.M{"albumID": objectID}, bson.M{"$set": bson.M{"albumID": existing.ID}}); err != nil {
+			return err
+		}
+		if _, err := albumsCollection.DeleteOne(context.Background(), bson.M{"_id": objectID}); err != nil {
+			return err
+		}
+		targetID = existing.ID
+	case err != mongo.ErrNoDocuments:
+		return err
+	}
+
+	_, err = albumsCollection.UpdateOne(context.Background(), bson.M{"_id": targetID}, bson.M{"$set": bson.M{
+		"musicbrainz.releaseId": release.ID,
+		"musicbrainz.matchedBy": matchedBy,
+	}})
+	if err != nil {
+		return err
+	}
+	if _, err := albums.EnrichAlbum(m.db, m.mb, targetID.Hex(), musicbrainz.IDs{ReleaseID: release.ID}); err != nil {
+		return err
+	}
+
+	remote := releaseTracks(release)
+	for i, track := range local {
+		if i >= len(remote) {
+			break
+		}
+		_, err := tracksCollection.UpdateOne(context.Background(), bson.M{"_id": track.ID}, bson.M{"$set": bson.M{
+			"musicbrainz.releaseId":   release.ID,
+			"musicbrainz.trackId":     remote[i].ID,
+			"musicbrainz.recordingId": remote[i].Recording.ID,
+		}})
+		if err != nil {
+			return err
+		}
+
+		var doc map[string]interface{}
+		if err := tracksCollection.FindOne(context.Background(), bson.M{"_id": track.ID}).Decode(&doc); err != nil {
+			return err
+		}
+		if err := tracks.EnrichTrack(m.db, m.mb, doc, release); err != nil {
+			log.Printf("Error enriching track %s: %v\n", track.FileName, err)
+		}
+	}
+
+	_, err = m.db.Collection("matchqueue").UpdateOne(context.Background(),
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"status": Applied, "releaseId": release.ID, "updatedAt": time.Now()}})
+	return err
+}
+
+// Skip marks a queued album as reviewed without a match.
+func Skip(db *mongo.Database, albumID string) error {
+	res, err := db.Collection("matchqueue").UpdateOne(context.Background(),
+		bson.M{"_id": mongodb.SafeObjectIDFromHex(albumID)},
+		bson.M{"$set": bson.M{"status": Skipped, "updatedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("album %s is not in the match queue", albumID)
+	}
+	return nil
+}
+
+// Pending returns the queued albums awaiting review.
+func Pending(db *mongo.Database) ([]QueueEntry, error) {
+	cursor, err := db.Collection("matchqueue").Find(context.Background(),
+		bson.M{"status": Queued}, options.Find().SetSort(bson.M{"updatedAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var entries []QueueEntry
+	if err := cursor.All(context.Background(), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// loadAlbum returns the album title, its artist name and its tracks ordered
+// by disc and track number.
+func (m *Matcher) loadAlbum(albumID string) (string, string, []albumTrack, error) {
+	objectID := mongodb.SafeObjectIDFromHex(albumID)
+
+	var album bson.M
+	if err := m.db.Collection("albums").FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&album); err != nil {
+		return "", "", nil, fmt.Errorf("error loading album %s: %w", albumID, err)
+	}
+
+	cursor, err := m.db.Collection("tracks").Find(context.Background(), bson.M{"albumID": objectID},
+		options.Find().SetSort(bson.D{{Key: "disc", Value: 1}, {Key: "track", Value: 1}, {Key: "fileName", Value: 1}}))
+	if err != nil {
+		return "", "", nil, err
+	}
+	var local []albumTrack
+	if err := cursor.All(context.Background(), &local); err != nil {
+		return "", "", nil, err
+	}
+
+	name, _ := album["name"].(string)
+	artist, _ := album["albumArtist"].(string)
+	// Older albums fall back to the artist's ObjectID when the tag is empty.
+	if primitive.IsValidObjectID(artist) {
+		artist = ""
+	}
+	if artist == "" && len(local) > 0 {
+		artist = local[0].Artist
+	}
+	return name, artist, local, nil
+}
+
+// releaseTracks flattens a release's media into a single ordered tracklist.
+func releaseTracks(release *musicbrainz.Release) []musicbrainz.Track {
+	var all []musicbrainz.Track
+	for _, medium := range release.Media {
+		all = append(all, medium.Tracks...)
+	}
+	return all
+}
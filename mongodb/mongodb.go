@@ -2,8 +2,13 @@ package mongodb
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -40,4 +45,114 @@ func SafeObjectIDFromHex(id string) primitive.ObjectID {
 		return primitive.NilObjectID
 	}
 	return objectID
-}
\ No newline at end of file
+}
+
+// UpsertID upserts a single document and returns its ID as a hex string,
+// along with whether a new document was inserted.
+func UpsertID(collection *mongo.Collection, filter, update interface{}) (string, bool, error) {
+	res, err := collection.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return "", false, err
+	}
+	if res.UpsertedID != nil {
+		return ToHex(res.UpsertedID.(primitive.ObjectID)), true, nil
+	}
+
+	var existing bson.M
+	if err := collection.FindOne(context.Background(), filter).Decode(&existing); err != nil {
+		return "", false, err
+	}
+	return ToHex(existing["_id"].(primitive.ObjectID)), false, nil
+}
+
+// Exists reports whether any document in the collection matches the filter.
+func Exists(collection *mongo.Collection, filter interface{}) (bool, error) {
+	count, err := collection.CountDocuments(context.Background(), filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// indexes lists the secondary indexes used for lookups, per collection.
+var indexes = map[string][]bson.D{
+	"tracks": {
+		{{Key: "albumID", Value: 1}},
+		{{Key: "artistID", Value: 1}},
+		{{Key: "artistCredits.artistId", Value: 1}},
+		{{Key: "genreIDs", Value: 1}},
+		{{Key: "hasLyrics", Value: 1}},
+		{{Key: "fileHash", Value: 1}},
+		{{Key: "fileName", Value: 1}},
+	},
+	"albums": {
+		{{Key: "artistCredits.artistId", Value: 1}},
+		{{Key: "nameKey", Value: 1}, {Key: "albumArtistID", Value: 1}, {Key: "year", Value: 1}},
+		{{Key: "musicbrainz.releaseId", Value: 1}},
+		{{Key: "stats.incomplete", Value: 1}},
+		{{Key: "stats.mixedQuality", Value: 1}},
+		{{Key: "genreIDs", Value: 1}},
+		{{Key: "nameKey", Value: 1}, {Key: "dirs", Value: 1}},
+	},
+	"artists": {
+		{{Key: "nameKey", Value: 1}},
+		{{Key: "name", Value: 1}},
+		{{Key: "aliases", Value: 1}},
+		{{Key: "musicbrainz.id", Value: 1}},
+	},
+	"playlists": {
+		{{Key: "source", Value: 1}},
+		{{Key: "name", Value: 1}},
+	},
+	"genres": {
+		{{Key: "key", Value: 1}},
+		{{Key: "parentID", Value: 1}},
+	},
+}
+
+// uniqueIndexes lists the indexes whose keys must be unique, per collection.
+var uniqueIndexes = map[string][]bson.D{
+	"users": {
+		{{Key: "name", Value: 1}},
+	},
+}
+
+// EnsureIndexes creates the secondary indexes if they don't exist yet.
+func EnsureIndexes(db *mongo.Database) error {
+	for collection, keys := range indexes {
+		for _, key := range keys {
+			_, err := db.Collection(collection).Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: key})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for collection, keys := range uniqueIndexes {
+		for _, key := range keys {
+			model := mongo.IndexModel{Keys: key, Options: options.Index().SetUnique(true)}
+			_, err := db.Collection(collection).Indexes().CreateOne(context.Background(), model)
+			var cmdErr mongo.CommandError
+			if errors.As(err, &cmdErr) && cmdErr.Name == "IndexOptionsConflict" {
+				// Replace an index created before it had to be unique
+				name := indexName(key)
+				log.Printf("Recreating index %s.%s as unique\n", collection, name)
+				if _, err = db.Collection(collection).Indexes().DropOne(context.Background(), name); err == nil {
+					_, err = db.Collection(collection).Indexes().CreateOne(context.Background(), model)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexName returns the default name MongoDB gives an index, such as name_1.
+func indexName(key bson.D) string {
+	parts := make([]string, 0, 2*len(key))
+	for _, e := range key {
+		parts = append(parts, e.Key, fmt.Sprint(e.Value))
+	}
+	return strings.Join(parts, "_")
+}
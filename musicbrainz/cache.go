@@ -0,0 +1,98 @@
+package musicbrainz
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cache stores raw MusicBrainz responses keyed by request path and query.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, body []byte, ttl time.Duration)
+}
+
+// MongoCache persists responses in the `mbcache` collection. Entries expire
+// through a TTL index on `expiresAt`; since MongoDB only sweeps expired
+// documents once a minute, Get also checks the expiry itself.
+type MongoCache struct {
+	collection *mongo.Collection
+}
+
+type cacheEntry struct {
+	Key       string    `bson:"_id"`
+	Body      []byte    `bson:"body"`
+	FetchedAt time.Time `bson:"fetchedAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// NewMongoCache returns a cache backed by the `mbcache` collection and makes
+// sure its TTL index exists.
+func NewMongoCache(db *mongo.Database) (*MongoCache, error) {
+	collection := db.Collection("mbcache")
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoCache{collection: collection}, nil
+}
+
+func (c *MongoCache) Get(key string) ([]byte, bool) {
+	var entry cacheEntry
+	err := c.collection.FindOne(context.Background(), bson.M{"_id": key}).Decode(&entry)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Error reading MusicBrainz cache for %s: %v\n", key, err)
+		}
+		return nil, false
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		return nil, false
+	}
+	return entry.Body, true
+}
+
+func (c *MongoCache) Set(key string, body []byte, ttl time.Duration) {
+	now := time.Now()
+	entry := cacheEntry{Key: key, Body: body, FetchedAt: now, ExpiresAt: now.Add(ttl)}
+	_, err := c.collection.ReplaceOne(context.Background(), bson.M{"_id": key}, entry, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error writing MusicBrainz cache for %s: %v\n", key, err)
+	}
+}
+
+// MemoryCache keeps responses in process memory. It is used when no database
+// is available and as a stand-in for MongoCache in tests.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]cacheEntry)}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.ExpiresAt) {
+		return nil, false
+	}
+	return entry.Body, true
+}
+
+func (c *MemoryCache) Set(key string, body []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.entries[key] = cacheEntry{Key: key, Body: body, FetchedAt: now, ExpiresAt: now.Add(ttl)}
+}
@@ -0,0 +1,59 @@
+package musicbrainz
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Cover Art Archive thumbnail sizes. SizeOriginal requests the full image.
+const (
+	Size250      = "250"
+	Size500      = "500"
+	Size1200     = "1200"
+	SizeOriginal = "original"
+)
+
+// CoverNotFoundTTL is how long a release without a cover is remembered,
+// shorter than DefaultCacheTTL since covers get added.
+const CoverNotFoundTTL = 7 * 24 * time.Hour
+
+// FetchFrontCover downloads the front cover for a release from the Cover Art
+// Archive, falling back to the release group's chosen cover when the release
+// has none. Requests share the client's rate limiter and retry policy. The
+// images are not cached, as they are stored by the caller, but releases
+// without a cover are, for CoverNotFoundTTL.
+func (c *Client) FetchFrontCover(releaseID, releaseGroupID, size string) ([]byte, error) {
+	var lastErr error = ErrNotFound
+	for _, target := range []struct{ entity, mbID string }{
+		{"release", releaseID},
+		{"release-group", releaseGroupID},
+	} {
+		if target.mbID == "" {
+			continue
+		}
+		key := fmt.Sprintf("coverartarchive/%s/%s/front", target.entity, target.mbID)
+		if _, notFound := c.cache.Get(key); notFound {
+			continue
+		}
+		image, err := c.fetch(c.frontCoverURL(target.entity, target.mbID, size), url.Values{}, "image/*")
+		if err == nil {
+			return image, nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			c.cache.Set(key, nil, CoverNotFoundTTL)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (c *Client) frontCoverURL(entity, mbID, size string) string {
+	switch size {
+	case Size250, Size500, Size1200:
+		return fmt.Sprintf("%s/%s/%s/front-%s", c.coverArtURL, entity, mbID, size)
+	default:
+		return fmt.Sprintf("%s/%s/%s/front", c.coverArtURL, entity, mbID)
+	}
+}
@@ -0,0 +1,117 @@
+package musicbrainz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// newCoverServer serves the front covers in images by path, such as
+// "/release-group/g1/front-250", and 404 for anything else. It returns
+// the paths requested.
+func newCoverServer(t *testing.T, images map[string]string) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		image, ok := images[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte(image))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+}
+
+func newCoverClient(server *httptest.Server, cache Cache) *Client {
+	return NewClient(Options{BaseURL: server.URL, CoverArtURL: server.URL, Limiter: NewLimiter(100, 10), Cache: cache})
+}
+
+func TestFetchFrontCoverOfRelease(t *testing.T) {
+	server, requested := newCoverServer(t, map[string]string{
+		"/release/r1/front-250":       "release cover",
+		"/release-group/g1/front-250": "group cover",
+	})
+	client := newCoverClient(server, nil)
+
+	image, err := client.FetchFrontCover("r1", "g1", Size250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(image) != "release cover" {
+		t.Errorf("got %q, want the release's cover", image)
+	}
+	if paths := requested(); len(paths) != 1 {
+		t.Errorf("requested %v, want only the release", paths)
+	}
+}
+
+func TestFetchFrontCoverFallsBackToReleaseGroup(t *testing.T) {
+	server, requested := newCoverServer(t, map[string]string{
+		"/release-group/g1/front": "group cover",
+	})
+	client := newCoverClient(server, nil)
+
+	image, err := client.FetchFrontCover("r1", "g1", SizeOriginal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(image) != "group cover" {
+		t.Errorf("got %q, want the release group's cover", image)
+	}
+	want := []string{"/release/r1/front", "/release-group/g1/front"}
+	if paths := requested(); len(paths) != 2 || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("requested %v, want %v", paths, want)
+	}
+}
+
+func TestFetchFrontCoverCachesNotFound(t *testing.T) {
+	server, requested := newCoverServer(t, map[string]string{})
+	cache := NewMemoryCache()
+	client := newCoverClient(server, cache)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.FetchFrontCover("r1", "g1", Size500); err != ErrNotFound {
+			t.Fatalf("got error %v, want ErrNotFound", err)
+		}
+	}
+	if paths := requested(); len(paths) != 2 {
+		t.Errorf("requested %v, want the release and release group once each", paths)
+	}
+
+	// Other sizes of the same release are known to be missing too
+	if _, err := client.FetchFrontCover("r1", "", Size1200); err != ErrNotFound {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+	if paths := requested(); len(paths) != 2 {
+		t.Errorf("requested %v after asking for another size, want no new requests", paths)
+	}
+}
+
+func TestFetchFrontCoverNotFoundExpires(t *testing.T) {
+	server, requested := newCoverServer(t, map[string]string{})
+	client := newCoverClient(server, NewMemoryCache())
+
+	if _, err := client.FetchFrontCover("r1", "", Size250); err != ErrNotFound {
+		t.Fatalf("got error %v, want ErrNotFound", err)
+	}
+	// An expired entry, as MongoCache's TTL index would leave it
+	client.cache.Set("coverartarchive/release/r1/front", nil, -1)
+	if _, err := client.FetchFrontCover("r1", "", Size250); err != ErrNotFound {
+		t.Fatalf("got error %v, want ErrNotFound", err)
+	}
+	if paths := requested(); len(paths) != 2 {
+		t.Errorf("requested %v, want the release again once the entry expired", paths)
+	}
+}
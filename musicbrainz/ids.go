@@ -0,0 +1,86 @@
+package musicbrainz
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// IDs holds the MusicBrainz identifiers written to a file by Picard.
+type IDs struct {
+	RecordingID    string   `bson:"recordingId,omitempty"`
+	TrackID        string   `bson:"trackId,omitempty"`
+	ReleaseID      string   `bson:"releaseId,omitempty"`
+	ReleaseGroupID string   `bson:"releaseGroupId,omitempty"`
+	WorkID         string   `bson:"workId,omitempty"`
+	ArtistIDs      []string `bson:"artistIds,omitempty"`
+	AlbumArtistIDs []string `bson:"albumArtistIds,omitempty"`
+}
+
+// ExtractIDs reads the MusicBrainz identifiers from a file's tags.
+// Vorbis, ID3 (TXXX) and MP4 spell the keys differently, so lookups go
+// through utils.FindTagValue. Note that Picard stores the recording MBID
+// under "MusicBrainz Track Id" and the track MBID under "Release Track Id".
+func ExtractIDs(tags map[string]string) IDs {
+	return IDs{
+		RecordingID:    utils.FindTagValue(tags, "musicbrainz_trackid", "musicbrainz_recordingid"),
+		TrackID:        utils.FindTagValue(tags, "musicbrainz_releasetrackid"),
+		ReleaseID:      utils.FindTagValue(tags, "musicbrainz_albumid", "musicbrainz_releaseid"),
+		ReleaseGroupID: utils.FindTagValue(tags, "musicbrainz_releasegroupid"),
+		WorkID:         utils.FindTagValue(tags, "musicbrainz_workid"),
+		ArtistIDs:      splitIDs(utils.FindTagValue(tags, "musicbrainz_artistid")),
+		AlbumArtistIDs: splitIDs(utils.FindTagValue(tags, "musicbrainz_albumartistid")),
+	}
+}
+
+// IDsFromTrack decodes the "musicbrainz" sub-document of a track read from
+// the tracks collection.
+func IDsFromTrack(track map[string]interface{}) IDs {
+	var ids IDs
+	raw, ok := track["musicbrainz"]
+	if !ok || raw == nil {
+		return ids
+	}
+	data, err := bson.Marshal(raw)
+	if err != nil {
+		return ids
+	}
+	if err := bson.Unmarshal(data, &ids); err != nil {
+		return IDs{}
+	}
+	return ids
+}
+
+// ArtistID returns the MBID of the first credited track artist.
+func (ids IDs) ArtistID() string {
+	if len(ids.ArtistIDs) == 0 {
+		return ""
+	}
+	return ids.ArtistIDs[0]
+}
+
+// AlbumArtistID returns the MBID of the first credited album artist.
+func (ids IDs) AlbumArtistID() string {
+	if len(ids.AlbumArtistIDs) == 0 {
+		return ""
+	}
+	return ids.AlbumArtistIDs[0]
+}
+
+// Multi-valued IDs come through ffprobe joined by ";" (Vorbis) or "/" (ID3v2.3).
+func splitIDs(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var ids []string
+	for _, id := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ';' || r == '/' || r == ',' || r == 0
+	}) {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,65 @@
+package musicbrainz
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket shared by every goroutine talking to MusicBrainz.
+// MusicBrainz blocks clients that average more than one request per second,
+// so a single Limiter must be shared across all workers.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // time to earn one token
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewLimiter returns a limiter allowing perSecond requests per second with
+// bursts of up to burst requests.
+func NewLimiter(perSecond float64, burst int) *Limiter {
+	if perSecond <= 0 {
+		perSecond = 1
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a token is available and consumes it.
+func (l *Limiter) Wait() {
+	for {
+		delay := l.reserve()
+		if delay == 0 {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+// reserve takes a token if one is available, otherwise it returns how long
+// the caller should sleep before trying again.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) * float64(l.interval))
+}
@@ -0,0 +1,54 @@
+package musicbrainz
+
+// LookupArtist fetches an artist by MBID.
+func (c *Client) LookupArtist(mbID string) (*Artist, error) {
+	var artist Artist
+	if err := c.lookup("artist", mbID, &artist); err != nil {
+		return nil, err
+	}
+	return &artist, nil
+}
+
+// LookupRelease fetches a release with its tracklist, labels, release group
+// and artist credits.
+func (c *Client) LookupRelease(mbID string) (*Release, error) {
+	var release Release
+	if err := c.lookup("release", mbID, &release, "recordings", "labels", "release-groups", "artist-credits"); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// LookupReleaseGroup fetches a release group with its artist credits.
+func (c *Client) LookupReleaseGroup(mbID string) (*ReleaseGroup, error) {
+	var group ReleaseGroup
+	if err := c.lookup("release-group", mbID, &group, "artist-credits"); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// LookupRecording fetches a recording with its artist credits and ISRCs.
+func (c *Client) LookupRecording(mbID string) (*Recording, error) {
+	var recording Recording
+	if err := c.lookup("recording", mbID, &recording, "artist-credits", "isrcs"); err != nil {
+		return nil, err
+	}
+	return &recording, nil
+}
+
+// LookupWork fetches a work by MBID.
+func (c *Client) LookupWork(mbID string) (*Work, error) {
+	var work Work
+	if err := c.lookup("work", mbID, &work); err != nil {
+		return nil, err
+	}
+	return &work, nil
+}
+
+func (c *Client) lookup(entity, mbID string, out interface{}, inc ...string) error {
+	if mbID == "" {
+		return errEmptyMBID(entity)
+	}
+	return c.get(entity+"/"+mbID, includes(inc), out)
+}
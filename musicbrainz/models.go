@@ -0,0 +1,139 @@
+package musicbrainz
+
+// Typed subsets of the MusicBrainz JSON web service responses. Only the
+// fields go-tracks uses are mapped; bson tags let the same types be stored
+// directly in the albums, tracks and artists collections.
+
+type Artist struct {
+	ID             string `json:"id" bson:"id"`
+	Name           string `json:"name" bson:"name"`
+	SortName       string `json:"sort-name" bson:"sortName,omitempty"`
+	Type           string `json:"type" bson:"type,omitempty"`
+	Country        string `json:"country" bson:"country,omitempty"`
+	Disambiguation string `json:"disambiguation" bson:"disambiguation,omitempty"`
+}
+
+type ArtistCredit struct {
+	Name       string `json:"name" bson:"name"`
+	JoinPhrase string `json:"joinphrase" bson:"joinPhrase,omitempty"`
+	Artist     Artist `json:"artist" bson:"artist"`
+}
+
+type Label struct {
+	ID   string `json:"id" bson:"id"`
+	Name string `json:"name" bson:"name"`
+}
+
+type LabelInfo struct {
+	CatalogNumber string `json:"catalog-number" bson:"catalogNumber,omitempty"`
+	Label         *Label `json:"label" bson:"label,omitempty"`
+}
+
+type ReleaseGroup struct {
+	ID               string         `json:"id" bson:"id"`
+	Title            string         `json:"title" bson:"title"`
+	PrimaryType      string         `json:"primary-type" bson:"primaryType,omitempty"`
+	SecondaryTypes   []string       `json:"secondary-types" bson:"secondaryTypes,omitempty"`
+	FirstReleaseDate string         `json:"first-release-date" bson:"firstReleaseDate,omitempty"`
+	ArtistCredit     []ArtistCredit `json:"artist-credit" bson:"artistCredit,omitempty"`
+}
+
+type Release struct {
+	ID           string         `json:"id" bson:"id"`
+	Title        string         `json:"title" bson:"title"`
+	Status       string         `json:"status" bson:"status,omitempty"`
+	Date         string         `json:"date" bson:"date,omitempty"`
+	Country      string         `json:"country" bson:"country,omitempty"`
+	Barcode      string         `json:"barcode" bson:"barcode,omitempty"`
+	ArtistCredit []ArtistCredit `json:"artist-credit" bson:"artistCredit,omitempty"`
+	LabelInfo    []LabelInfo    `json:"label-info" bson:"labelInfo,omitempty"`
+	ReleaseGroup *ReleaseGroup  `json:"release-group" bson:"releaseGroup,omitempty"`
+	Media        []Medium       `json:"media" bson:"media,omitempty"`
+}
+
+type Medium struct {
+	Position   int     `json:"position" bson:"position"`
+	Title      string  `json:"title" bson:"title,omitempty"`
+	Format     string  `json:"format" bson:"format,omitempty"`
+	TrackCount int     `json:"track-count" bson:"trackCount"`
+	Tracks     []Track `json:"tracks" bson:"tracks,omitempty"`
+}
+
+type Track struct {
+	ID           string         `json:"id" bson:"id"`
+	Number       string         `json:"number" bson:"number"`
+	Position     int            `json:"position" bson:"position"`
+	Title        string         `json:"title" bson:"title"`
+	Length       int            `json:"length" bson:"length"` // milliseconds
+	ArtistCredit []ArtistCredit `json:"artist-credit" bson:"artistCredit,omitempty"`
+	Recording    Recording      `json:"recording" bson:"recording"`
+}
+
+type Recording struct {
+	ID               string         `json:"id" bson:"id"`
+	Title            string         `json:"title" bson:"title"`
+	Length           int            `json:"length" bson:"length"` // milliseconds
+	Disambiguation   string         `json:"disambiguation" bson:"disambiguation,omitempty"`
+	FirstReleaseDate string         `json:"first-release-date" bson:"firstReleaseDate,omitempty"`
+	ISRCs            []string       `json:"isrcs" bson:"isrcs,omitempty"`
+	ArtistCredit     []ArtistCredit `json:"artist-credit" bson:"artistCredit,omitempty"`
+}
+
+type Work struct {
+	ID       string   `json:"id" bson:"id"`
+	Title    string   `json:"title" bson:"title"`
+	Type     string   `json:"type" bson:"type,omitempty"`
+	Language string   `json:"language" bson:"language,omitempty"`
+	ISWCs    []string `json:"iswcs" bson:"iswcs,omitempty"`
+}
+
+// CreditedName joins an artist credit back into the display string,
+// e.g. "Miles Davis & John Coltrane".
+func CreditedName(credits []ArtistCredit) string {
+	name := ""
+	for _, credit := range credits {
+		name += credit.Name + credit.JoinPhrase
+	}
+	return name
+}
+
+// Label returns the first label and catalog number on the release.
+func (r *Release) Label() (string, string) {
+	for _, info := range r.LabelInfo {
+		if info.Label != nil {
+			return info.Label.Name, info.CatalogNumber
+		}
+	}
+	return "", ""
+}
+
+// FindTrack locates a track on the release by track MBID, then recording
+// MBID, then by disc and track position. disc may be 0 when unknown, in
+// which case positional matching is only attempted on single-disc releases.
+func (r *Release) FindTrack(trackID, recordingID string, disc, position int) (*Medium, *Track) {
+	for i := range r.Media {
+		medium := &r.Media[i]
+		for j := range medium.Tracks {
+			track := &medium.Tracks[j]
+			if (trackID != "" && track.ID == trackID) || (recordingID != "" && track.Recording.ID == recordingID) {
+				return medium, track
+			}
+		}
+	}
+
+	if position == 0 || (disc == 0 && len(r.Media) != 1) {
+		return nil, nil
+	}
+	for i := range r.Media {
+		medium := &r.Media[i]
+		if disc != 0 && medium.Position != disc {
+			continue
+		}
+		for j := range medium.Tracks {
+			if medium.Tracks[j].Position == position {
+				return medium, &medium.Tracks[j]
+			}
+		}
+	}
+	return nil, nil
+}
@@ -1,41 +1,189 @@
 package musicbrainz
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 )
 
-const ( 
-	UserAgent  = "Music Meta/1.0 (client@test.com)" 
- 	BaseURL = "https://musicbrainz.org/ws/2"
+const (
+	UserAgent = "Music Meta/1.0 (client@test.com)"
+	BaseURL   = "https://musicbrainz.org/ws/2"
+
+	CoverArtURL = "https://coverartarchive.org"
+
+	DefaultCacheTTL   = 30 * 24 * time.Hour
+	DefaultMaxRetries = 5
 )
 
-func FetchMusicBrainz(apiEndpoint, mbID string) (*map[string]interface{}, error) {
+// ErrNotFound is returned when MusicBrainz has no entity for the given MBID.
+var ErrNotFound = errors.New("musicbrainz: not found")
+
+// Options configures a Client. Zero values fall back to the defaults above.
+type Options struct {
+	BaseURL     string
+	CoverArtURL string
+	UserAgent   string
+	Limiter     *Limiter
+	Cache       Cache
+	CacheTTL    time.Duration
+	MaxRetries  int
+}
+
+// Client is a MusicBrainz web service client that is safe for concurrent use.
+// All requests go through a shared rate limiter and a response cache, and
+// 503/429 responses are retried with backoff honouring Retry-After.
+type Client struct {
+	http        *resty.Client
+	baseURL     string
+	coverArtURL string
+	limiter     *Limiter
+	cache       Cache
+	cacheTTL    time.Duration
+	maxRetries  int
+}
+
+// NewClient creates a MusicBrainz client.
+func NewClient(opts Options) *Client {
+	if opts.BaseURL == "" {
+		opts.BaseURL = BaseURL
+	}
+	if opts.CoverArtURL == "" {
+		opts.CoverArtURL = CoverArtURL
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = UserAgent
+	}
+	if opts.Limiter == nil {
+		opts.Limiter = NewLimiter(1, 1)
+	}
+	if opts.Cache == nil {
+		opts.Cache = NewMemoryCache()
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = DefaultCacheTTL
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+
 	client := resty.New()
-	client.SetHeader("User-Agent", UserAgent)
-	client.SetHeader("Accept", "application/json")
+	client.SetHeader("User-Agent", opts.UserAgent)
+	client.SetTimeout(30 * time.Second)
 
-	log.Printf("Fetching MusicBrainz Artist Data for %s\n", mbID)
-	log.Printf("URL: %s/%s/%s\n", BaseURL, apiEndpoint, mbID)
+	return &Client{
+		http:        client,
+		baseURL:     strings.TrimSuffix(opts.BaseURL, "/"),
+		coverArtURL: strings.TrimSuffix(opts.CoverArtURL, "/"),
+		limiter:     opts.Limiter,
+		cache:       opts.Cache,
+		cacheTTL:    opts.CacheTTL,
+		maxRetries:  opts.MaxRetries,
+	}
+}
 
-	// Define a generic map to capture the full response
-	var fullResponse map[string]interface{}
-	resp, err := client.R().
-		SetQueryParam("fmt", "json").
-		SetResult(&fullResponse).
-		Get(fmt.Sprintf("%s/%s/%s", BaseURL, apiEndpoint, mbID))
+func errEmptyMBID(entity string) error {
+	return fmt.Errorf("musicbrainz: empty %s MBID", entity)
+}
+
+// get performs a cached, rate-limited GET of path relative to the base URL
+// and decodes the JSON body into out.
+func (c *Client) get(path string, params url.Values, out interface{}) error {
+	params.Set("fmt", "json")
+	key := path + "?" + params.Encode()
+
+	body, ok := c.cache.Get(key)
+	if !ok {
+		var err error
+		body, err = c.fetch(fmt.Sprintf("%s/%s", c.baseURL, path), params, "application/json")
+		if err != nil {
+			return err
+		}
+		c.cache.Set(key, body, c.cacheTTL)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("error decoding MusicBrainz response for %s: %w", path, err)
+	}
+	return nil
+}
+
+// fetch GETs endpoint through the rate limiter, retrying on errors and on
+// 503/429 responses.
+func (c *Client) fetch(endpoint string, params url.Values, accept string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		c.limiter.Wait()
+		log.Printf("Fetching %s\n", endpoint)
 
-	log.Println("Response Body:", resp.String())
+		resp, err := c.http.R().SetHeader("Accept", accept).SetQueryParamsFromValues(params).Get(endpoint)
+		if err != nil {
+			if attempt >= c.maxRetries {
+				return nil, fmt.Errorf("error making request to MusicBrainz: %w", err)
+			}
+			time.Sleep(backoff(attempt))
+			continue
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("error making request to MusicBrainz: %w", err)
+		switch status := resp.StatusCode(); {
+		case status == http.StatusNotFound:
+			return nil, ErrNotFound
+		case status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests:
+			if attempt >= c.maxRetries {
+				return nil, fmt.Errorf("error response from MusicBrainz: %s", resp.Status())
+			}
+			wait := retryAfter(resp.Header().Get("Retry-After"))
+			if wait == 0 {
+				wait = backoff(attempt)
+			}
+			log.Printf("MusicBrainz returned %s, retrying in %v\n", resp.Status(), wait)
+			time.Sleep(wait)
+		case resp.IsError():
+			return nil, fmt.Errorf("error response from MusicBrainz: %s", resp.Status())
+		default:
+			return resp.Body(), nil
+		}
 	}
+}
+
+// backoff returns an exponential delay capped at 30 seconds.
+func backoff(attempt int) time.Duration {
+	wait := time.Second << attempt
+	if wait > 30*time.Second || wait <= 0 {
+		wait = 30 * time.Second
+	}
+	return wait
+}
 
-	if resp.IsError() {
-		return nil, fmt.Errorf("error response from MusicBrainz: %s", resp.Status())
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date. It returns 0 when the header is absent or invalid.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
 	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
 
-	return &fullResponse, nil
+func includes(inc []string) url.Values {
+	params := url.Values{}
+	if len(inc) > 0 {
+		// Encodes as "inc=a+b", the form the MusicBrainz docs use.
+		params.Set("inc", strings.Join(inc, " "))
+	}
+	return params
 }
@@ -0,0 +1,137 @@
+package musicbrainz
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer serves artists as JSON named after their MBID, failing
+// with 503 and Retry-After for the first unavailable requests.
+func newTestServer(t *testing.T, unavailable int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if n <= unavailable {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Query().Get("fmt") != "json" {
+			t.Errorf("request %s without fmt=json", r.URL)
+		}
+		mbID := strings.TrimPrefix(r.URL.Path, "/artist/")
+		if mbID == "missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id": %q, "name": "Artist %s"}`, mbID, mbID)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestClientRateLimit(t *testing.T) {
+	server, requests := newTestServer(t, 0)
+	client := NewClient(Options{BaseURL: server.URL, Limiter: NewLimiter(10, 1)})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := client.LookupArtist(fmt.Sprintf("a%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first request uses the burst, the others wait 100ms each
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("4 requests at 10/s took %v, want about 300ms", elapsed)
+	}
+	if n := requests.Load(); n != 4 {
+		t.Errorf("server got %d requests, want 4", n)
+	}
+}
+
+func TestClientRetriesAfter503(t *testing.T) {
+	server, requests := newTestServer(t, 1)
+	client := NewClient(Options{BaseURL: server.URL, Limiter: NewLimiter(100, 10)})
+
+	start := time.Now()
+	artist, err := client.LookupArtist("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artist.Name != "Artist a1" {
+		t.Errorf("got artist %q, want %q", artist.Name, "Artist a1")
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("server got %d requests, want 2", n)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retry came after %v, want the 1s of Retry-After", elapsed)
+	}
+}
+
+func TestClientGivesUpAfterMaxRetries(t *testing.T) {
+	server, requests := newTestServer(t, 100)
+	client := NewClient(Options{BaseURL: server.URL, Limiter: NewLimiter(100, 10), MaxRetries: 1})
+
+	if _, err := client.LookupArtist("a1"); err == nil {
+		t.Fatal("expected an error once retries run out")
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("server got %d requests, want 2", n)
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	server, requests := newTestServer(t, 0)
+	cache := NewMemoryCache()
+	client := NewClient(Options{BaseURL: server.URL, Limiter: NewLimiter(100, 10), Cache: cache})
+
+	for i := 0; i < 3; i++ {
+		artist, err := client.LookupArtist("a1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if artist.ID != "a1" {
+			t.Errorf("got artist %q, want a1", artist.ID)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("server got %d requests for one artist, want 1", n)
+	}
+
+	// A new client sharing the cache doesn't ask again either
+	other := NewClient(Options{BaseURL: server.URL, Limiter: NewLimiter(100, 10), Cache: cache})
+	if _, err := other.LookupArtist("a1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("server got %d requests with a shared cache, want 1", n)
+	}
+
+	// Expired entries are fetched again
+	expiring := NewClient(Options{BaseURL: server.URL, Limiter: NewLimiter(100, 10), CacheTTL: time.Nanosecond})
+	for i := 0; i < 2; i++ {
+		if _, err := expiring.LookupArtist("a2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("server got %d requests, want 3 with an expired entry", n)
+	}
+}
+
+func TestClientNotFound(t *testing.T) {
+	server, _ := newTestServer(t, 0)
+	client := NewClient(Options{BaseURL: server.URL, Limiter: NewLimiter(100, 10)})
+
+	if _, err := client.LookupArtist("missing"); err != ErrNotFound {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+}
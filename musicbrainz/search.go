@@ -0,0 +1,57 @@
+package musicbrainz
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ReleaseSearchResult is a release as returned by the search endpoint, which
+// carries a relevance score and track count but no tracklist.
+type ReleaseSearchResult struct {
+	Release
+	Score      int `json:"score"`
+	TrackCount int `json:"track-count"`
+}
+
+type releaseSearchResponse struct {
+	Count    int                   `json:"count"`
+	Releases []ReleaseSearchResult `json:"releases"`
+}
+
+// SearchReleases searches releases by title and artist name. trackCount,
+// when non-zero, is added as an optional clause so that releases with a
+// matching number of tracks rank higher.
+func (c *Client) SearchReleases(title, artist string, trackCount, limit int) ([]ReleaseSearchResult, error) {
+	var clauses []string
+	if title != "" {
+		clauses = append(clauses, fmt.Sprintf(`release:"%s"`, escapeQuery(title)))
+	}
+	if artist != "" {
+		clauses = append(clauses, fmt.Sprintf(`artist:"%s"`, escapeQuery(artist)))
+	}
+	if len(clauses) == 0 {
+		return nil, fmt.Errorf("musicbrainz: release search needs a title or artist")
+	}
+	query := strings.Join(clauses, " AND ")
+	if trackCount > 0 {
+		query = fmt.Sprintf("(%s) OR (%s AND tracks:%d)", query, query, trackCount)
+	}
+
+	params := url.Values{}
+	params.Set("query", query)
+	if limit > 0 {
+		params.Set("limit", fmt.Sprintf("%d", limit))
+	}
+
+	var response releaseSearchResponse
+	if err := c.get("release", params, &response); err != nil {
+		return nil, err
+	}
+	return response.Releases, nil
+}
+
+// escapeQuery escapes a value for use inside a quoted Lucene phrase.
+func escapeQuery(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+}
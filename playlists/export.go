@@ -0,0 +1,300 @@
+package playlists
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/coverart"
+	"github.com/ksuayan/go-tracks/tracks"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// Export formats.
+const (
+	ExportM3U8 = "m3u8"
+	ExportXSPF = "xspf"
+	ExportJSON = "json"
+)
+
+// Rewrite replaces a path prefix, so that a playlist written here works on
+// a machine where the library is mounted elsewhere.
+type Rewrite struct {
+	From string
+	To   string
+}
+
+// ExportOptions control how track locations are written.
+type ExportOptions struct {
+	Format string
+	// Relative writes paths relative to BaseDir, the directory the playlist
+	// is written to. Otherwise paths are absolute, after Rewrites.
+	Relative bool
+	BaseDir  string
+	Rewrites []Rewrite
+	// CoverDir is the cover art output directory; XSPF and JSON exports
+	// point at the cover of each track in it.
+	CoverDir string
+}
+
+// LoadTracks returns the track documents with the given IDs in that order,
+// skipping tracks that no longer exist.
+func LoadTracks(db *mongo.Database, trackIDs []primitive.ObjectID) ([]map[string]interface{}, error) {
+	ctx := context.Background()
+	cursor, err := db.Collection("tracks").Find(ctx, bson.M{"_id": bson.M{"$in": trackIDs}})
+	if err != nil {
+		return nil, err
+	}
+	var found []map[string]interface{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]map[string]interface{})
+	for _, track := range found {
+		byID[track["_id"].(primitive.ObjectID)] = track
+	}
+	ordered := make([]map[string]interface{}, 0, len(trackIDs))
+	for _, trackID := range trackIDs {
+		if track, ok := byID[trackID]; ok {
+			ordered = append(ordered, track)
+		}
+	}
+	return ordered, nil
+}
+
+// Export writes tracks as a playlist in the chosen format. Tracks split from
+// a single-file rip by a CUE sheet carry their offsets as VLC options in
+// M3U8 and XSPF, and as start and end in JSON.
+func Export(w io.Writer, name string, trackDocs []map[string]interface{}, opts ExportOptions) error {
+	switch opts.Format {
+	case ExportM3U8, "m3u", "":
+		return exportM3U8(w, name, trackDocs, opts)
+	case ExportXSPF:
+		return exportXSPF(w, name, trackDocs, opts)
+	case ExportJSON:
+		return exportJSON(w, name, trackDocs, opts)
+	}
+	return fmt.Errorf("unsupported export format: %s", opts.Format)
+}
+
+func exportM3U8(w io.Writer, name string, trackDocs []map[string]interface{}, opts ExportOptions) error {
+	fmt.Fprintln(w, "#EXTM3U")
+	if name != "" {
+		fmt.Fprintf(w, "#PLAYLIST:%s\n", name)
+	}
+	for _, track := range trackDocs {
+		seconds := int(time.Duration(utils.SafeGetInt64(track, "length")).Round(time.Second).Seconds())
+		fmt.Fprintf(w, "#EXTINF:%d,%s\n", seconds, displayName(track))
+		for _, option := range vlcOptions(track) {
+			fmt.Fprintf(w, "#EXTVLCOPT:%s\n", option)
+		}
+		location, err := opts.location(track)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, location)
+	}
+	return nil
+}
+
+type xspfExport struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	VLCNS   string      `xml:"xmlns:vlc,attr,omitempty"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location  string         `xml:"location"`
+	Title     string         `xml:"title,omitempty"`
+	Creator   string         `xml:"creator,omitempty"`
+	Album     string         `xml:"album,omitempty"`
+	TrackNum  int            `xml:"trackNum,omitempty"`
+	Duration  int64          `xml:"duration,omitempty"`
+	Image     string         `xml:"image,omitempty"`
+	Extension *xspfExtension `xml:"extension,omitempty"`
+}
+
+type xspfExtension struct {
+	Application string   `xml:"application,attr"`
+	Options     []string `xml:"vlc:option"`
+}
+
+const vlcApplication = "http://www.videolan.org/vlc/playlist/0"
+
+func exportXSPF(w io.Writer, name string, trackDocs []map[string]interface{}, opts ExportOptions) error {
+	playlist := xspfExport{Version: "1", XMLNS: "http://xspf.org/ns/0/", Title: name}
+	for _, track := range trackDocs {
+		location, err := opts.location(track)
+		if err != nil {
+			return err
+		}
+		entry := xspfTrack{
+			Location: fileURL(location, opts.Relative),
+			Title:    utils.SafeGetString(track, "title"),
+			Creator:  utils.SafeGetString(track, "artist"),
+			Album:    utils.SafeGetString(track, "album"),
+			TrackNum: utils.SafeGetInt(track, "track"),
+			Duration: time.Duration(utils.SafeGetInt64(track, "length")).Milliseconds(),
+		}
+		if cover := opts.cover(track); cover != "" {
+			entry.Image = fileURL(cover, false)
+		}
+		if options := vlcOptions(track); len(options) > 0 {
+			playlist.VLCNS = vlcApplication
+			entry.Extension = &xspfExtension{Application: vlcApplication, Options: options}
+		}
+		playlist.Tracks = append(playlist.Tracks, entry)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type jsonTrack struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album"`
+	Track    int     `json:"track,omitempty"`
+	Duration float64 `json:"duration"` // seconds
+	Path     string  `json:"path"`
+	Start    float64 `json:"start,omitempty"` // seconds into the file
+	End      float64 `json:"end,omitempty"`
+	CoverArt string  `json:"coverArt,omitempty"`
+}
+
+func exportJSON(w io.Writer, name string, trackDocs []map[string]interface{}, opts ExportOptions) error {
+	out := struct {
+		Name   string      `json:"name"`
+		Tracks []jsonTrack `json:"tracks"`
+	}{Name: name, Tracks: []jsonTrack{}}
+	for _, track := range trackDocs {
+		location, err := opts.location(track)
+		if err != nil {
+			return err
+		}
+		start, end := tracks.Segment(track)
+		id, _ := track["_id"].(primitive.ObjectID)
+		out.Tracks = append(out.Tracks, jsonTrack{
+			ID:       id.Hex(),
+			Title:    utils.SafeGetString(track, "title"),
+			Artist:   utils.SafeGetString(track, "artist"),
+			Album:    utils.SafeGetString(track, "album"),
+			Track:    utils.SafeGetInt(track, "track"),
+			Duration: time.Duration(utils.SafeGetInt64(track, "length")).Seconds(),
+			Path:     location,
+			Start:    start.Seconds(),
+			End:      end.Seconds(),
+			CoverArt: opts.cover(track),
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// location returns the path written for a track.
+func (opts ExportOptions) location(track map[string]interface{}) (string, error) {
+	path, err := filepath.Abs(tracks.FilePath(track))
+	if err != nil {
+		return "", err
+	}
+	if opts.Relative {
+		base, err := filepath.Abs(opts.BaseDir)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Rel(base, path)
+	}
+	return opts.rewrite(path), nil
+}
+
+// rewrite applies the first matching prefix rewrite. Prefixes match whole
+// path elements, so /Volumes/Music doesn't rewrite /Volumes/MusicOld.
+func (opts ExportOptions) rewrite(path string) string {
+	for _, r := range opts.Rewrites {
+		rest, ok := strings.CutPrefix(path, r.From)
+		if !ok {
+			continue
+		}
+		if rest == "" || isSeparator(rest[0]) || (r.From != "" && isSeparator(r.From[len(r.From)-1])) {
+			return r.To + rest
+		}
+	}
+	return path
+}
+
+func isSeparator(c byte) bool {
+	return c == '/' || c == filepath.Separator
+}
+
+// cover returns the path of a track's cover image, if it has one.
+func (opts ExportOptions) cover(track map[string]interface{}) string {
+	hash := utils.SafeGetString(track, "coverArtHash")
+	if hash == "" || opts.CoverDir == "" {
+		return ""
+	}
+	path, err := coverart.GetCoverArtPathFromHash(opts.CoverDir, hash)
+	if err != nil {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return opts.rewrite(path)
+}
+
+// vlcOptions returns the start and stop times of a CUE track in seconds.
+func vlcOptions(track map[string]interface{}) []string {
+	if !tracks.IsCueTrack(track) {
+		return nil
+	}
+	start, end := tracks.Segment(track)
+	options := []string{fmt.Sprintf("start-time=%.3f", start.Seconds())}
+	if end > 0 {
+		options = append(options, fmt.Sprintf("stop-time=%.3f", end.Seconds()))
+	}
+	return options
+}
+
+func displayName(track map[string]interface{}) string {
+	artist, title := utils.SafeGetString(track, "artist"), utils.SafeGetString(track, "title")
+	if artist == "" {
+		return title
+	}
+	return artist + " - " + title
+}
+
+// fileURL turns a path into a file:// URL, or a relative URL reference.
+func fileURL(path string, relative bool) string {
+	u := url.URL{Path: filepath.ToSlash(path)}
+	if !relative {
+		u.Scheme = "file"
+		if !strings.HasPrefix(u.Path, "/") {
+			// Rewritten to a Windows path such as "D:/Music/..."
+			u.Path = "/" + u.Path
+		}
+	}
+	return u.String()
+}
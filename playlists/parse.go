@@ -0,0 +1,226 @@
+package playlists
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Playlist file formats.
+const (
+	FormatM3U  = "m3u"
+	FormatPLS  = "pls"
+	FormatXSPF = "xspf"
+)
+
+// Item is an entry as read from a playlist file: a location plus whatever
+// metadata the format carries.
+type Item struct {
+	Location string
+	Title    string
+	Artist   string
+	Album    string
+	Duration time.Duration
+}
+
+// formats maps playlist file extensions to their format.
+var formats = map[string]string{
+	".m3u":  FormatM3U,
+	".m3u8": FormatM3U,
+	".pls":  FormatPLS,
+	".xspf": FormatXSPF,
+}
+
+// IsPlaylistFile reports whether the extension is a supported playlist format.
+func IsPlaylistFile(extension string) bool {
+	_, ok := formats[strings.ToLower(extension)]
+	return ok
+}
+
+// ParseFile reads a playlist file and returns its title (if it has one),
+// its format and its items in order.
+func ParseFile(path string) (string, string, []Item, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	format := formats[strings.ToLower(filepath.Ext(path))]
+	var title string
+	var items []Item
+	switch format {
+	case FormatM3U:
+		// .m3u is traditionally in the system code page, .m3u8 in UTF-8
+		if !utf8.Valid(data) {
+			if data, err = charmap.Windows1252.NewDecoder().Bytes(data); err != nil {
+				return "", "", nil, err
+			}
+		}
+		title, items = parseM3U(data)
+	case FormatPLS:
+		items = parsePLS(data)
+	case FormatXSPF:
+		title, items, err = parseXSPF(data)
+	default:
+		err = fmt.Errorf("unsupported playlist format: %s", path)
+	}
+	return title, format, items, err
+}
+
+// parseM3U reads plain and extended M3U. "#EXTINF:<seconds>,<artist> - <title>"
+// describes the entry after it; "#PLAYLIST:" names the playlist.
+func parseM3U(data []byte) (string, []Item) {
+	var title string
+	var items []Item
+	var pending Item
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			duration, display, _ := strings.Cut(info, ",")
+			// Attributes such as tvg-id="..." may follow the duration
+			duration, _, _ = strings.Cut(strings.TrimSpace(duration), " ")
+			if seconds, err := strconv.ParseFloat(duration, 64); err == nil && seconds > 0 {
+				pending.Duration = time.Duration(seconds * float64(time.Second))
+			}
+			pending.Artist, pending.Title = splitDisplay(display)
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTALB:"):
+			pending.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#EXTART:"):
+			pending.Artist = strings.TrimSpace(strings.TrimPrefix(line, "#EXTART:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			items = append(items, pending)
+			pending = Item{}
+		}
+	}
+	return title, items
+}
+
+// parsePLS reads the INI-style PLS format: FileN, TitleN and LengthN keys.
+func parsePLS(data []byte) []Item {
+	byIndex := make(map[int]*Item)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		var field string
+		for _, prefix := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, prefix) {
+				field = prefix
+				break
+			}
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil {
+			continue
+		}
+		if byIndex[index] == nil {
+			byIndex[index] = &Item{}
+		}
+		switch field {
+		case "file":
+			byIndex[index].Location = value
+		case "title":
+			byIndex[index].Artist, byIndex[index].Title = splitDisplay(value)
+		case "length":
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				byIndex[index].Duration = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for index := range byIndex {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	var items []Item
+	for _, index := range indexes {
+		if byIndex[index].Location != "" {
+			items = append(items, *byIndex[index])
+		}
+	}
+	return items
+}
+
+type xspfPlaylist struct {
+	Title  string `xml:"title"`
+	Tracks []struct {
+		Location []string `xml:"location"`
+		Title    string   `xml:"title"`
+		Creator  string   `xml:"creator"`
+		Album    string   `xml:"album"`
+		Duration int64    `xml:"duration"` // milliseconds
+	} `xml:"trackList>track"`
+}
+
+// parseXSPF reads an XSPF (XML Shareable Playlist Format) playlist.
+func parseXSPF(data []byte) (string, []Item, error) {
+	var playlist xspfPlaylist
+	if err := xml.Unmarshal(data, &playlist); err != nil {
+		return "", nil, err
+	}
+	var items []Item
+	for _, track := range playlist.Tracks {
+		item := Item{
+			Title:    strings.TrimSpace(track.Title),
+			Artist:   strings.TrimSpace(track.Creator),
+			Album:    strings.TrimSpace(track.Album),
+			Duration: time.Duration(track.Duration) * time.Millisecond,
+		}
+		if len(track.Location) > 0 {
+			item.Location = strings.TrimSpace(track.Location[0])
+		}
+		items = append(items, item)
+	}
+	return strings.TrimSpace(playlist.Title), items, nil
+}
+
+// splitDisplay splits "Artist - Title" as written by most players.
+func splitDisplay(display string) (string, string) {
+	display = strings.TrimSpace(display)
+	if artist, title, ok := strings.Cut(display, " - "); ok {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return "", display
+}
+
+// LocalPath turns a playlist location into a clean local path: file:// URLs
+// are decoded, Windows separators converted and relative paths joined to
+// the playlist's directory. ok is false for remote URLs.
+func LocalPath(location, playlistDir string) (string, bool) {
+	if u, err := url.Parse(location); err == nil && len(u.Scheme) > 1 {
+		if u.Scheme != "file" {
+			return "", false
+		}
+		location = u.Path
+	}
+	location = strings.ReplaceAll(location, `\`, "/")
+	// "C:/Music/..." from a Windows machine can only match by fallback
+	if !filepath.IsAbs(location) && !(len(location) > 1 && location[1] == ':') {
+		location = filepath.Join(playlistDir, location)
+	}
+	return filepath.Clean(location), true
+}
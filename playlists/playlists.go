@@ -0,0 +1,305 @@
+package playlists
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// How an entry was matched to a track.
+const (
+	ResolvedByPath     = "path"
+	ResolvedByHash     = "fileHash"
+	ResolvedByFileName = "fileName"
+	ResolvedByMetadata = "metadata"
+)
+
+// Entries whose duration differs from a metadata match by more than this
+// are not that track.
+const maxDurationDiff = 5 * time.Second
+
+// Entry is one entry of a stored playlist, as read from the file, with the
+// track it resolved to. Unresolved entries have no TrackID.
+type Entry struct {
+	Position   int                `bson:"position"`
+	Location   string             `bson:"location,omitempty"`
+	Title      string             `bson:"title,omitempty"`
+	Artist     string             `bson:"artist,omitempty"`
+	Album      string             `bson:"album,omitempty"`
+	Duration   time.Duration      `bson:"duration,omitempty"`
+	TrackID    primitive.ObjectID `bson:"trackId,omitempty"`
+	ResolvedBy string             `bson:"resolvedBy,omitempty"`
+}
+
+// Playlist is a document in the `playlists` collection. TrackIDs holds the
+// resolved tracks in playlist order. Smart playlists have Rules instead of
+// Entries, and TrackIDs as of when they were last materialized.
+type Playlist struct {
+	ID         primitive.ObjectID   `bson:"_id,omitempty"`
+	Name       string               `bson:"name"`
+	Source     string               `bson:"source,omitempty"`
+	Format     string               `bson:"format,omitempty"`
+	Entries    []Entry              `bson:"entries"`
+	TrackIDs   []primitive.ObjectID `bson:"trackIds"`
+	Unresolved int                  `bson:"unresolved"`
+	ImportedAt time.Time            `bson:"importedAt,omitempty"`
+
+	Rules          *Rule       `bson:"rules,omitempty"`
+	Sort           []SortField `bson:"sort,omitempty"`
+	Limit          int64       `bson:"limit,omitempty"`
+	MaterializedAt time.Time   `bson:"materializedAt,omitempty"`
+}
+
+// ImportDir imports every playlist file under a library root and returns
+// the playlists imported.
+func ImportDir(db *mongo.Database, root string) ([]Playlist, error) {
+	var imported []Playlist
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Error accessing path %s: %v", path, err)
+			return nil
+		}
+		if info.IsDir() || !IsPlaylistFile(filepath.Ext(path)) {
+			return nil
+		}
+		playlist, err := ImportFile(db, root, path)
+		if err != nil {
+			log.Printf("Error importing playlist %s: %v", path, err)
+			return nil
+		}
+		imported = append(imported, *playlist)
+		return nil
+	})
+	return imported, err
+}
+
+// ImportFile parses a playlist file, resolves its entries against the
+// tracks scanned from root and stores it, replacing an earlier import of
+// the same file.
+func ImportFile(db *mongo.Database, root, path string) (*Playlist, error) {
+	title, format, items, err := ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	r, err := newResolver(db, root)
+	if err != nil {
+		return nil, err
+	}
+	playlist := &Playlist{
+		Name:       title,
+		Source:     path,
+		Format:     format,
+		Entries:    []Entry{},
+		TrackIDs:   []primitive.ObjectID{},
+		ImportedAt: time.Now(),
+	}
+	for i, item := range items {
+		entry := Entry{
+			Position: i + 1,
+			Location: item.Location,
+			Title:    item.Title,
+			Artist:   item.Artist,
+			Album:    item.Album,
+			Duration: item.Duration,
+		}
+		entry.TrackID, entry.ResolvedBy, err = r.resolve(item, filepath.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+		if entry.ResolvedBy == "" {
+			playlist.Unresolved++
+		} else {
+			playlist.TrackIDs = append(playlist.TrackIDs, entry.TrackID)
+		}
+		playlist.Entries = append(playlist.Entries, entry)
+	}
+
+	playlistID, _, err := mongodb.UpsertID(db.Collection("playlists"), bson.M{"source": path}, bson.M{"$set": bson.M{
+		"name":       playlist.Name,
+		"format":     playlist.Format,
+		"entries":    playlist.Entries,
+		"trackIds":   playlist.TrackIDs,
+		"unresolved": playlist.Unresolved,
+		"importedAt": playlist.ImportedAt,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	playlist.ID = mongodb.SafeObjectIDFromHex(playlistID)
+	log.Printf("Imported playlist %s: %d entries, %d unresolved\n", playlist.Name, len(playlist.Entries), playlist.Unresolved)
+	return playlist, nil
+}
+
+// FindAll returns the stored playlists, sorted by name.
+func FindAll(db *mongo.Database) ([]Playlist, error) {
+	return find(db, bson.M{})
+}
+
+// FindUnresolved returns the playlists with entries that matched no track.
+func FindUnresolved(db *mongo.Database) ([]Playlist, error) {
+	return find(db, bson.M{"unresolved": bson.M{"$gt": 0}})
+}
+
+// Find returns a playlist by ID or, failing that, by name.
+func Find(db *mongo.Database, idOrName string) (*Playlist, error) {
+	filter := bson.M{"name": idOrName}
+	if id, err := primitive.ObjectIDFromHex(idOrName); err == nil {
+		filter = bson.M{"_id": id}
+	}
+	var playlist Playlist
+	if err := db.Collection("playlists").FindOne(context.Background(), filter).Decode(&playlist); err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+func find(db *mongo.Database, filter bson.M) ([]Playlist, error) {
+	ctx := context.Background()
+	cursor, err := db.Collection("playlists").Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var found []Playlist
+	err = cursor.All(ctx, &found)
+	return found, err
+}
+
+// resolver matches playlist entries to track documents.
+type resolver struct {
+	tracks  *mongo.Collection
+	root    string
+	absRoot string
+}
+
+func newResolver(db *mongo.Database, root string) (*resolver, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &resolver{tracks: db.Collection("tracks"), root: root, absRoot: absRoot}, nil
+}
+
+// candidate is the part of a track document entries are matched on.
+type candidate struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Title    string             `bson:"title"`
+	Artist   string             `bson:"artist"`
+	Length   time.Duration      `bson:"length"`
+	FileHash string             `bson:"fileHash"`
+}
+
+// resolve finds the track for an entry: by its path under the library root,
+// then for stale paths by the hash of the file it points to, by its file
+// name anywhere in the library, and finally by title, artist and duration.
+func (r *resolver) resolve(item Item, playlistDir string) (primitive.ObjectID, string, error) {
+	if path, local := LocalPath(item.Location, playlistDir); local {
+		if rel, err := filepath.Rel(r.absRoot, absPath(path)); err == nil && !strings.HasPrefix(rel, "..") {
+			subDir := filepath.Dir(rel)
+			if subDir == "." {
+				subDir = ""
+			}
+			filter := bson.M{"rootDir": r.root, "subDir": subDir, "fileName": filepath.Base(rel)}
+			if id, ok, err := r.findOne(filter, item, false); ok || err != nil {
+				return id, ResolvedByPath, err
+			}
+		}
+		if hash, err := utils.GetFileHash(path); err == nil {
+			if id, ok, err := r.findOne(bson.M{"fileHash": hash}, item, false); ok || err != nil {
+				return id, ResolvedByHash, err
+			}
+		}
+		if id, ok, err := r.findOne(bson.M{"fileName": filepath.Base(path)}, item, true); ok || err != nil {
+			return id, ResolvedByFileName, err
+		}
+	}
+
+	if item.Title != "" {
+		title := "^" + regexp.QuoteMeta(item.Title) + "$"
+		filter := bson.M{"title": bson.M{"$regex": title, "$options": "i"}}
+		if id, ok, err := r.findOne(filter, item, true); ok || err != nil {
+			return id, ResolvedByMetadata, err
+		}
+	}
+	return primitive.NilObjectID, "", nil
+}
+
+// findOne picks the track matching filter that best fits the entry's
+// metadata. Path and hash matches (not strict) only use the metadata to
+// choose between several tracks, such as the CUE tracks of one file. Looser
+// matches (strict) must fit the metadata, and several candidates are only
+// accepted when they are the same file.
+func (r *resolver) findOne(filter bson.M, item Item, strict bool) (primitive.ObjectID, bool, error) {
+	ctx := context.Background()
+	cursor, err := r.tracks.Find(ctx, filter, options.Find().SetSort(bson.M{"cueTrack": 1}))
+	if err != nil {
+		return primitive.NilObjectID, false, err
+	}
+	var candidates []candidate
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return primitive.NilObjectID, false, err
+	}
+
+	var fitting []candidate
+	for _, c := range candidates {
+		if fits(c, item) {
+			fitting = append(fitting, c)
+		}
+	}
+	switch {
+	case len(fitting) == 1:
+		return fitting[0].ID, true, nil
+	case len(fitting) > 1 && (!strict || sameFile(fitting)):
+		return fitting[0].ID, true, nil
+	case len(candidates) > 0 && !strict:
+		return candidates[0].ID, true, nil
+	}
+	return primitive.NilObjectID, false, nil
+}
+
+// fits reports whether a track agrees with whatever the entry says about
+// its title, artist and duration.
+func fits(c candidate, item Item) bool {
+	if item.Title != "" && utils.FoldKey(c.Title) != utils.FoldKey(item.Title) {
+		return false
+	}
+	if item.Artist != "" && utils.Similarity(utils.FoldKey(c.Artist), utils.FoldKey(item.Artist)) < 0.8 {
+		return false
+	}
+	if item.Duration > 0 && c.Length > 0 && math.Abs(float64(c.Length-item.Duration)) > float64(maxDurationDiff) {
+		return false
+	}
+	return true
+}
+
+func sameFile(candidates []candidate) bool {
+	for _, c := range candidates[1:] {
+		if c.FileHash == "" || c.FileHash != candidates[0].FileHash {
+			return false
+		}
+	}
+	return true
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,549 @@
+package playlists
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Rule is a smart playlist rule: either a predicate (Field, Op, Value) or a
+// group whose rules must all (All) or any (Any) match. The zero Rule
+// matches every track.
+type Rule struct {
+	Field string      `bson:"field,omitempty" json:"field,omitempty"`
+	Op    string      `bson:"op,omitempty" json:"op,omitempty"`
+	Value interface{} `bson:"value" json:"value"`
+	All   []Rule      `bson:"all,omitempty" json:"all,omitempty"`
+	Any   []Rule      `bson:"any,omitempty" json:"any,omitempty"`
+}
+
+// SortField orders smart playlist results.
+type SortField struct {
+	Field string `bson:"field" json:"field"`
+	Desc  bool   `bson:"desc,omitempty" json:"desc,omitempty"`
+}
+
+// Field types, which decide the operators a field supports.
+const (
+	TypeString   = "string"
+	TypeNumber   = "number"
+	TypeDuration = "duration" // given in seconds, stored as a time.Duration
+	TypeDate     = "date"
+	TypeBool     = "bool"
+)
+
+// Operators by field type. Dates take a number of days for inLast and
+// notInLast, and a date ("2006-01-02") for before and after.
+var operators = map[string][]string{
+	TypeString:   {"is", "isNot", "contains", "notContains", "startsWith", "endsWith", "in"},
+	TypeNumber:   {"eq", "ne", "gt", "gte", "lt", "lte", "between"},
+	TypeDuration: {"eq", "ne", "gt", "gte", "lt", "lte", "between"},
+	TypeDate:     {"inLast", "notInLast", "before", "after"},
+	TypeBool:     {"is"},
+}
+
+// Operators returns the operators fields of a type support.
+func Operators(fieldType string) []string {
+	return operators[fieldType]
+}
+
+// Field is a track field rules can test.
+type Field struct {
+	Name string
+	Path string // path in the track document
+	Type string
+	// normalize maps a rule value to how it is stored
+	normalize func(string) string
+}
+
+// Fields is the registry of fields smart playlist rules can use.
+var Fields = map[string]Field{}
+
+func init() {
+	for _, f := range []Field{
+		{Name: "title", Path: "title", Type: TypeString},
+		{Name: "artist", Path: "artist", Type: TypeString},
+		{Name: "album", Path: "album", Type: TypeString},
+		{Name: "albumArtist", Path: "albumArtist", Type: TypeString},
+		{Name: "genre", Path: "genres", Type: TypeString},
+		{Name: "codec", Path: "codec", Type: TypeString},
+		{Name: "format", Path: "fileExtension", Type: TypeString, normalize: func(s string) string {
+			return "." + strings.TrimPrefix(strings.ToLower(s), ".")
+		}},
+		{Name: "folder", Path: "subDir", Type: TypeString},
+		{Name: "year", Path: "year", Type: TypeNumber},
+		{Name: "track", Path: "track", Type: TypeNumber},
+		{Name: "disc", Path: "disc", Type: TypeNumber},
+		{Name: "bitrate", Path: "bitrate", Type: TypeNumber},
+		{Name: "samplerate", Path: "samplerate", Type: TypeNumber},
+		{Name: "bitDepth", Path: "bitDepth", Type: TypeNumber},
+		{Name: "channels", Path: "channels", Type: TypeNumber},
+		{Name: "size", Path: "size", Type: TypeNumber},
+		// playCount and lastPlayed are only set by plays scrobbled through
+		// the Subsonic API; until then every track is unplayed
+		{Name: "playCount", Path: "playCount", Type: TypeNumber},
+		{Name: "length", Path: "length", Type: TypeDuration},
+		{Name: "added", Path: "creationDate", Type: TypeDate},
+		{Name: "modified", Path: "modificationDate", Type: TypeDate},
+		{Name: "lastPlayed", Path: "lastPlayed", Type: TypeDate},
+		{Name: "hasLyrics", Path: "hasLyrics", Type: TypeBool},
+	} {
+		Fields[f.Name] = f
+	}
+}
+
+// Validate checks that every predicate names a known field, an operator
+// that field supports and a usable value.
+func (r Rule) Validate() error {
+	_, err := r.Filter()
+	return err
+}
+
+// Filter compiles the rule to a MongoDB filter over the tracks collection.
+func (r Rule) Filter() (bson.M, error) {
+	return r.filter(time.Now())
+}
+
+func (r Rule) filter(now time.Time) (bson.M, error) {
+	if r.Field == "" {
+		if len(r.All) > 0 && len(r.Any) > 0 {
+			return nil, fmt.Errorf("a rule group has either all or any, not both")
+		}
+		group, key := r.All, "$and"
+		if len(r.Any) > 0 {
+			group, key = r.Any, "$or"
+		}
+		if len(group) == 0 {
+			return bson.M{}, nil
+		}
+		var parts bson.A
+		for _, rule := range group {
+			part, err := rule.filter(now)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		return bson.M{key: parts}, nil
+	}
+
+	field, err := r.field()
+	if err != nil {
+		return nil, err
+	}
+	path := field.Path
+	switch field.Type {
+	case TypeString:
+		values := field.strings(r.Value)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%s %s needs a value", r.Field, r.Op)
+		}
+		if r.Op == "in" {
+			var patterns bson.A
+			for _, value := range values {
+				patterns = append(patterns, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"})
+			}
+			return bson.M{path: bson.M{"$in": patterns}}, nil
+		}
+		pattern := primitive.Regex{Pattern: stringPattern(r.Op, values[0]), Options: "i"}
+		if r.Op == "isNot" || r.Op == "notContains" {
+			return bson.M{path: bson.M{"$not": pattern}}, nil
+		}
+		return bson.M{path: pattern}, nil
+
+	case TypeNumber, TypeDuration:
+		values, err := field.numbers(r.Value, r.Op == "between")
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", r.Field, r.Op, err)
+		}
+		if r.Op == "between" {
+			return bson.M{path: bson.M{"$gte": values[0], "$lte": values[1]}}, nil
+		}
+		return bson.M{path: bson.M{"$" + r.Op: values[0]}}, nil
+
+	case TypeDate:
+		cutoff, err := dateValue(r.Op, r.Value, now)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", r.Field, r.Op, err)
+		}
+		switch r.Op {
+		case "inLast", "after":
+			return bson.M{path: bson.M{"$gte": cutoff}}, nil
+		case "notInLast":
+			// Never played counts as not played recently
+			return bson.M{"$or": bson.A{
+				bson.M{path: bson.M{"$lt": cutoff}},
+				bson.M{path: nil},
+			}}, nil
+		default:
+			return bson.M{path: bson.M{"$lt": cutoff}}, nil
+		}
+
+	case TypeBool:
+		want, ok := r.Value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s is needs true or false", r.Field)
+		}
+		if want {
+			return bson.M{path: true}, nil
+		}
+		return bson.M{path: bson.M{"$ne": true}}, nil
+	}
+	return nil, fmt.Errorf("unknown field type %s", field.Type)
+}
+
+// Match evaluates the rule against a track document in memory, with the
+// same semantics as the compiled filter.
+func (r Rule) Match(track map[string]interface{}) (bool, error) {
+	return r.match(track, time.Now())
+}
+
+func (r Rule) match(track map[string]interface{}, now time.Time) (bool, error) {
+	if r.Field == "" {
+		if len(r.All) > 0 && len(r.Any) > 0 {
+			return false, fmt.Errorf("a rule group has either all or any, not both")
+		}
+		for _, rule := range r.All {
+			if ok, err := rule.match(track, now); !ok || err != nil {
+				return false, err
+			}
+		}
+		for _, rule := range r.Any {
+			if ok, err := rule.match(track, now); ok || err != nil {
+				return ok, err
+			}
+		}
+		return len(r.Any) == 0, nil
+	}
+
+	field, err := r.field()
+	if err != nil {
+		return false, err
+	}
+	value := lookup(track, field.Path)
+	switch field.Type {
+	case TypeString:
+		values := field.strings(r.Value)
+		if len(values) == 0 {
+			return false, fmt.Errorf("%s %s needs a value", r.Field, r.Op)
+		}
+		// Like a regex on an array in MongoDB, any element may match
+		found := false
+		for _, s := range storedStrings(value) {
+			if matchString(r.Op, s, values) {
+				found = true
+				break
+			}
+		}
+		if r.Op == "isNot" || r.Op == "notContains" {
+			return !found, nil
+		}
+		return found, nil
+
+	case TypeNumber, TypeDuration:
+		values, err := field.numbers(r.Value, r.Op == "between")
+		if err != nil {
+			return false, fmt.Errorf("%s %s: %v", r.Field, r.Op, err)
+		}
+		n, ok := toFloat(value)
+		if !ok {
+			return r.Op == "ne", nil
+		}
+		switch r.Op {
+		case "eq":
+			return n == values[0], nil
+		case "ne":
+			return n != values[0], nil
+		case "gt":
+			return n > values[0], nil
+		case "gte":
+			return n >= values[0], nil
+		case "lt":
+			return n < values[0], nil
+		case "lte":
+			return n <= values[0], nil
+		default:
+			return n >= values[0] && n <= values[1], nil
+		}
+
+	case TypeDate:
+		cutoff, err := dateValue(r.Op, r.Value, now)
+		if err != nil {
+			return false, fmt.Errorf("%s %s: %v", r.Field, r.Op, err)
+		}
+		t, ok := toTime(value)
+		switch r.Op {
+		case "inLast", "after":
+			return ok && !t.Before(cutoff), nil
+		case "notInLast":
+			return !ok || t.Before(cutoff), nil
+		default:
+			return ok && t.Before(cutoff), nil
+		}
+
+	case TypeBool:
+		want, ok := r.Value.(bool)
+		if !ok {
+			return false, fmt.Errorf("%s is needs true or false", r.Field)
+		}
+		got, _ := value.(bool)
+		return got == want, nil
+	}
+	return false, fmt.Errorf("unknown field type %s", field.Type)
+}
+
+// SortFilter compiles sort fields to a MongoDB sort document.
+func SortFilter(fields []SortField) (bson.D, error) {
+	var sortDoc bson.D
+	for _, s := range fields {
+		field, ok := Fields[s.Field]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field %q", s.Field)
+		}
+		order := 1
+		if s.Desc {
+			order = -1
+		}
+		sortDoc = append(sortDoc, bson.E{Key: field.Path, Value: order})
+	}
+	return sortDoc, nil
+}
+
+// SortTracks sorts track documents in memory like SortFilter does in
+// MongoDB; missing values sort first.
+func SortTracks(trackDocs []map[string]interface{}, fields []SortField) {
+	sort.SliceStable(trackDocs, func(i, j int) bool {
+		for _, s := range fields {
+			field := Fields[s.Field]
+			a := lookup(trackDocs[i], field.Path)
+			b := lookup(trackDocs[j], field.Path)
+			c := compareValues(a, b)
+			if c != 0 {
+				return (c < 0) != s.Desc
+			}
+		}
+		return false
+	})
+}
+
+func (r Rule) field() (Field, error) {
+	field, ok := Fields[r.Field]
+	if !ok {
+		return Field{}, fmt.Errorf("unknown field %q", r.Field)
+	}
+	for _, op := range operators[field.Type] {
+		if op == r.Op {
+			return field, nil
+		}
+	}
+	return Field{}, fmt.Errorf("%s doesn't support %q; use one of %s", r.Field, r.Op, strings.Join(operators[field.Type], ", "))
+}
+
+// strings returns the rule's value(s) as normalized strings.
+func (f Field) strings(value interface{}) []string {
+	var values []string
+	switch v := value.(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+	case primitive.A:
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+	case nil:
+	default:
+		values = []string{fmt.Sprint(v)}
+	}
+	if f.normalize != nil {
+		for i := range values {
+			values[i] = f.normalize(values[i])
+		}
+	}
+	return values
+}
+
+// numbers returns the rule's value, or the two bounds of between, in the
+// field's stored unit.
+func (f Field) numbers(value interface{}, pair bool) ([]float64, error) {
+	var raw []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		raw = v
+	case primitive.A:
+		raw = v
+	default:
+		raw = []interface{}{v}
+	}
+	if (pair && len(raw) != 2) || (!pair && len(raw) != 1) {
+		if pair {
+			return nil, fmt.Errorf("needs two values")
+		}
+		return nil, fmt.Errorf("needs one value")
+	}
+	values := make([]float64, len(raw))
+	for i, item := range raw {
+		n, ok := toFloat(item)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a number", item)
+		}
+		if f.Type == TypeDuration {
+			n *= float64(time.Second)
+		}
+		values[i] = n
+	}
+	return values, nil
+}
+
+// dateValue returns the cutoff time of a date predicate.
+func dateValue(op string, value interface{}, now time.Time) (time.Time, error) {
+	if op == "inLast" || op == "notInLast" {
+		days, ok := toFloat(value)
+		if !ok {
+			return time.Time{}, fmt.Errorf("needs a number of days")
+		}
+		return now.Add(-time.Duration(days * 24 * float64(time.Hour))), nil
+	}
+	s, _ := value.(string)
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("needs a date as YYYY-MM-DD")
+	}
+	return t, nil
+}
+
+// lookup returns the value at a dotted path in a document.
+func lookup(doc map[string]interface{}, path string) interface{} {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		switch m := value.(type) {
+		case map[string]interface{}:
+			value = m[key]
+		case primitive.M:
+			value = m[key]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func stringPattern(op, value string) string {
+	quoted := regexp.QuoteMeta(value)
+	switch op {
+	case "contains", "notContains":
+		return quoted
+	case "startsWith":
+		return "^" + quoted
+	case "endsWith":
+		return quoted + "$"
+	}
+	return "^" + quoted + "$"
+}
+
+func matchString(op, s string, values []string) bool {
+	s = strings.ToLower(s)
+	for _, value := range values {
+		value = strings.ToLower(value)
+		var ok bool
+		switch op {
+		case "contains", "notContains":
+			ok = strings.Contains(s, value)
+		case "startsWith":
+			ok = strings.HasPrefix(s, value)
+		case "endsWith":
+			ok = strings.HasSuffix(s, value)
+		default:
+			ok = s == value
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func storedStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case primitive.A:
+		return storedStrings([]interface{}(v))
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case []string:
+		return v
+	}
+	return nil
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case time.Duration:
+		return float64(v), true
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case primitive.DateTime:
+		return v.Time(), true
+	}
+	return time.Time{}, false
+}
+
+// compareValues orders two stored values of the same field.
+func compareValues(a, b interface{}) int {
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := toTime(a); ok {
+		if y, ok := toTime(b); ok {
+			return x.Compare(y)
+		}
+	}
+	x, y := fmt.Sprint(a), fmt.Sprint(b)
+	if a == nil {
+		x = ""
+	}
+	if b == nil {
+		y = ""
+	}
+	return strings.Compare(strings.ToLower(x), strings.ToLower(y))
+}
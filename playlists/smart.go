@@ -0,0 +1,143 @@
+package playlists
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/mongodb"
+)
+
+// defaultSort orders smart playlists without a sort of their own.
+var defaultSort = []SortField{{Field: "albumArtist"}, {Field: "album"}, {Field: "disc"}, {Field: "track"}}
+
+// IsSmart reports whether the playlist is defined by rules.
+func (p *Playlist) IsSmart() bool {
+	return p.Rules != nil
+}
+
+// ParseSort turns "artist,-year" into sort fields, - for descending.
+func ParseSort(sortBy string) ([]SortField, error) {
+	var fields []SortField
+	for _, name := range strings.Split(sortBy, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field := SortField{Field: strings.TrimPrefix(name, "-"), Desc: strings.HasPrefix(name, "-")}
+		if _, ok := Fields[field.Field]; !ok {
+			return nil, fmt.Errorf("unknown sort field %q", field.Field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// SaveSmart validates and stores a smart playlist, replacing the rules of
+// an existing smart playlist of the same name.
+func SaveSmart(db *mongo.Database, name string, rules Rule, sortBy []SortField, limit int64) (*Playlist, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := SortFilter(sortBy); err != nil {
+		return nil, err
+	}
+	playlistID, _, err := mongodb.UpsertID(db.Collection("playlists"),
+		bson.M{"name": name, "rules": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"rules": rules, "sort": sortBy, "limit": limit}})
+	if err != nil {
+		return nil, err
+	}
+	return &Playlist{ID: mongodb.SafeObjectIDFromHex(playlistID), Name: name, Rules: &rules, Sort: sortBy, Limit: limit}, nil
+}
+
+// Materialize runs a smart playlist's rules against the tracks collection
+// and stores the resulting track IDs on the playlist. It returns the
+// matching track documents in playlist order.
+func Materialize(db *mongo.Database, p *Playlist) ([]map[string]interface{}, error) {
+	found, err := FindTracks(db, p)
+	if err != nil {
+		return nil, err
+	}
+	p.TrackIDs = make([]primitive.ObjectID, 0, len(found))
+	for _, track := range found {
+		p.TrackIDs = append(p.TrackIDs, track["_id"].(primitive.ObjectID))
+	}
+	p.MaterializedAt = time.Now()
+	_, err = db.Collection("playlists").UpdateOne(context.Background(), bson.M{"_id": p.ID}, bson.M{"$set": bson.M{
+		"trackIds":       p.TrackIDs,
+		"materializedAt": p.MaterializedAt,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Materialized smart playlist %s: %d tracks\n", p.Name, len(p.TrackIDs))
+	return found, nil
+}
+
+// FindTracks runs a smart playlist's rules against the tracks collection
+// like Materialize, without storing the result.
+func FindTracks(db *mongo.Database, p *Playlist) ([]map[string]interface{}, error) {
+	if !p.IsSmart() {
+		return nil, fmt.Errorf("%s is not a smart playlist", p.Name)
+	}
+	filter, err := p.Rules.Filter()
+	if err != nil {
+		return nil, err
+	}
+	sortBy := p.Sort
+	if len(sortBy) == 0 {
+		sortBy = defaultSort
+	}
+	sortDoc, err := SortFilter(sortBy)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(sortDoc)
+	if p.Limit > 0 {
+		opts.SetLimit(p.Limit)
+	}
+
+	ctx := context.Background()
+	cursor, err := db.Collection("tracks").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var found []map[string]interface{}
+	err = cursor.All(ctx, &found)
+	return found, err
+}
+
+// MatchTracks applies a smart playlist's rules to track documents already
+// in memory, as Materialize does in MongoDB.
+func MatchTracks(p *Playlist, trackDocs []map[string]interface{}) ([]map[string]interface{}, error) {
+	if !p.IsSmart() {
+		return nil, fmt.Errorf("%s is not a smart playlist", p.Name)
+	}
+	var matched []map[string]interface{}
+	for _, track := range trackDocs {
+		ok, err := p.Rules.Match(track)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, track)
+		}
+	}
+	sortBy := p.Sort
+	if len(sortBy) == 0 {
+		sortBy = defaultSort
+	}
+	SortTracks(matched, sortBy)
+	if p.Limit > 0 && int64(len(matched)) > p.Limit {
+		matched = matched[:p.Limit]
+	}
+	return matched, nil
+}
@@ -0,0 +1,178 @@
+// Package query is a small query language over tracks, for ad-hoc
+// questions such as
+//
+//	codec:flac samplerate>48k path:Jazz/* year:1950..1960
+//
+// Queries are parsed into a typed syntax tree and compiled to a MongoDB
+// filter over the tracks collection. Fields are those of smart playlist
+// rules, plus path.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node is a node of a parsed query.
+type Node interface {
+	String() string
+}
+
+// And matches tracks matching all of its terms. Terms side by side are
+// joined with And.
+type And struct {
+	Terms []Node
+}
+
+// Or matches tracks matching any of its terms.
+type Or struct {
+	Terms []Node
+}
+
+// Not matches tracks its term doesn't match, written -term or NOT term.
+type Not struct {
+	Term Node
+}
+
+// Compare tests a field: field:value, field=value, field>value and so on.
+type Compare struct {
+	Field string
+	Op    Op
+	Value Value
+}
+
+// Text is a word or phrase without a field, matched against the title,
+// artist, album and album artist.
+type Text struct {
+	Value Value // String or Pattern
+}
+
+// Op is a comparison operator.
+type Op string
+
+// Comparison operators. Match (:) means contains for text, equals for
+// numbers and falls within for dates and ranges.
+const (
+	Match Op = ":"
+	Eq    Op = "="
+	Ne    Op = "!="
+	Gt    Op = ">"
+	Gte   Op = ">="
+	Lt    Op = "<"
+	Lte   Op = "<="
+)
+
+// Value is a typed value, by its written form.
+type Value interface {
+	String() string
+}
+
+// String is a word or a quoted phrase.
+type String string
+
+// Pattern is a word with the wildcards * (any text) and ? (any character).
+type Pattern string
+
+// Number is a number, with an optional k for thousands (48k).
+type Number float64
+
+// Duration is a length of time: 3:30, 90s, 10m, 2h or 30d.
+type Duration time.Duration
+
+// Date is a day, written 2006-01-02.
+type Date time.Time
+
+// Bool is true or false (also yes or no).
+type Bool bool
+
+// Range is an inclusive range low..high of numbers, durations or dates.
+// Either end may be left open (nil).
+type Range struct {
+	Low, High Value
+}
+
+func (n And) String() string {
+	return "(" + joinNodes(n.Terms, " ") + ")"
+}
+
+func (n Or) String() string {
+	return "(" + joinNodes(n.Terms, " OR ") + ")"
+}
+
+func (n Not) String() string {
+	return "-" + n.Term.String()
+}
+
+func (n Compare) String() string {
+	return n.Field + string(n.Op) + n.Value.String()
+}
+
+func (n Text) String() string {
+	return n.Value.String()
+}
+
+func (v String) String() string {
+	return strconv.Quote(string(v))
+}
+
+func (v Pattern) String() string {
+	return string(v)
+}
+
+func (v Number) String() string {
+	return strconv.FormatFloat(float64(v), 'f', -1, 64)
+}
+
+func (v Duration) String() string {
+	return time.Duration(v).String()
+}
+
+func (v Date) String() string {
+	return time.Time(v).Format(dateLayout)
+}
+
+func (v Bool) String() string {
+	return strconv.FormatBool(bool(v))
+}
+
+func (v Range) String() string {
+	var low, high string
+	if v.Low != nil {
+		low = v.Low.String()
+	}
+	if v.High != nil {
+		high = v.High.String()
+	}
+	return low + ".." + high
+}
+
+func joinNodes(nodes []Node, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = node.String()
+	}
+	return strings.Join(parts, sep)
+}
+
+// typeName names the type of a value in errors.
+func typeName(v Value) string {
+	switch v.(type) {
+	case String:
+		return "text"
+	case Pattern:
+		return "a pattern"
+	case Number:
+		return "a number"
+	case Duration:
+		return "a duration"
+	case Date:
+		return "a date"
+	case Bool:
+		return "true or false"
+	case Range:
+		return "a range"
+	}
+	return fmt.Sprintf("%T", v)
+}
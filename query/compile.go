@@ -0,0 +1,256 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/ksuayan/go-tracks/playlists"
+)
+
+// textFields are the fields words without a field are searched in.
+var textFields = []string{"title", "artist", "album", "albumArtist"}
+
+// Filter parses a query and compiles it to a MongoDB filter over the
+// tracks collection.
+func Filter(input string) (bson.M, error) {
+	node, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(node)
+}
+
+// Compile compiles a parsed query to a MongoDB filter over the tracks
+// collection. Comparisons of smart playlist fields compile as the
+// equivalent rules, so both match tracks the same way.
+func Compile(node Node) (bson.M, error) {
+	switch n := node.(type) {
+	case And:
+		return group("$and", n.Terms)
+	case Or:
+		return group("$or", n.Terms)
+	case Not:
+		term, err := Compile(n.Term)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$nor": bson.A{term}}, nil
+	case Text:
+		pattern := textPattern(n.Value, false)
+		var fields bson.A
+		for _, path := range textFields {
+			fields = append(fields, bson.M{path: pattern})
+		}
+		return bson.M{"$or": fields}, nil
+	case Compare:
+		return compileCompare(n)
+	}
+	return nil, fmt.Errorf("unknown query node %T", node)
+}
+
+func group(op string, terms []Node) (bson.M, error) {
+	switch len(terms) {
+	case 0:
+		return bson.M{}, nil
+	case 1:
+		return Compile(terms[0])
+	}
+	var parts bson.A
+	for _, term := range terms {
+		part, err := Compile(term)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return bson.M{op: parts}, nil
+}
+
+func compileCompare(c Compare) (bson.M, error) {
+	rule := func(op string, value interface{}) (bson.M, error) {
+		return playlists.Rule{Field: c.Field, Op: op, Value: value}.Filter()
+	}
+	negate := func(filter bson.M, err error) (bson.M, error) {
+		if err != nil || c.Op != Ne {
+			return filter, err
+		}
+		return bson.M{"$nor": bson.A{filter}}, nil
+	}
+
+	if c.Field == PathField {
+		// path:Jazz is everything under the Jazz folder; patterns and
+		// path=... match whole paths
+		pattern := textPattern(c.Value, true)
+		if dir, isText := c.Value.(String); isText && c.Op != Eq {
+			pattern.Pattern = "^" + regexp.QuoteMeta(strings.TrimSuffix(string(dir), "/")) + "(/|$)"
+		}
+		return negate(bson.M{"$expr": bson.M{"$regexMatch": bson.M{
+			"input": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$subDir", ""}}, ""}},
+				"$fileName",
+				bson.M{"$concat": bson.A{"$subDir", "/", "$fileName"}},
+			}},
+			"regex":   pattern.Pattern,
+			"options": pattern.Options,
+		}}}, nil)
+	}
+
+	switch v := c.Value.(type) {
+	case String:
+		switch c.Op {
+		case Eq:
+			return rule("is", string(v))
+		case Ne:
+			return rule("isNot", string(v))
+		}
+		return rule("contains", string(v))
+
+	case Pattern:
+		pattern := textPattern(v, true)
+		if c.Field == "format" && !strings.HasPrefix(string(v), ".") {
+			// Formats are stored as extensions
+			pattern.Pattern = `^\.` + strings.TrimPrefix(pattern.Pattern, "^")
+		}
+		path := playlists.Fields[c.Field].Path
+		if c.Op == Ne {
+			return bson.M{path: bson.M{"$not": pattern}}, nil
+		}
+		return bson.M{path: pattern}, nil
+
+	case Bool:
+		return rule("is", bool(v) != (c.Op == Ne))
+
+	case Number:
+		return rule(numberOps[c.Op], float64(v))
+
+	case Duration:
+		if FieldType(c.Field) == playlists.TypeDate {
+			// An age: added<30d is in the last 30 days, added>30d before that
+			days := time.Duration(v).Hours() / 24
+			if c.Op == Gt || c.Op == Gte {
+				return rule("notInLast", days)
+			}
+			return rule("inLast", days)
+		}
+		seconds := time.Duration(v).Seconds()
+		if c.Op == Match || c.Op == Ne {
+			// Lengths are stored to the nanosecond; length:3:30 is the
+			// whole second
+			low, err := rule("gte", seconds)
+			if err != nil {
+				return nil, err
+			}
+			high, err := rule("lt", seconds+1)
+			if err != nil {
+				return nil, err
+			}
+			return negate(bson.M{"$and": bson.A{low, high}}, nil)
+		}
+		return rule(numberOps[c.Op], seconds)
+
+	case Date:
+		day := time.Time(v)
+		next := day.AddDate(0, 0, 1)
+		switch c.Op {
+		case Gt:
+			return rule("after", next.Format(dateLayout))
+		case Gte:
+			return rule("after", day.Format(dateLayout))
+		case Lt:
+			return rule("before", day.Format(dateLayout))
+		case Lte:
+			return rule("before", next.Format(dateLayout))
+		}
+		return negate(dateRange(c.Field, day, next))
+
+	case Range:
+		if FieldType(c.Field) == playlists.TypeDate {
+			var low, high time.Time
+			if v.Low != nil {
+				low = time.Time(v.Low.(Date))
+			}
+			if v.High != nil {
+				high = time.Time(v.High.(Date)).AddDate(0, 0, 1)
+			}
+			return negate(dateRange(c.Field, low, high))
+		}
+		low, high := rangeEnd(v.Low), rangeEnd(v.High)
+		switch {
+		case v.Low == nil:
+			return negate(rule("lte", high))
+		case v.High == nil:
+			return negate(rule("gte", low))
+		}
+		return negate(rule("between", []interface{}{low, high}))
+	}
+	return nil, fmt.Errorf("%s: unsupported value %s", c.Field, c.Value)
+}
+
+// numberOps maps operators to the smart playlist operators of numbers.
+var numberOps = map[Op]string{Match: "eq", Eq: "eq", Ne: "ne", Gt: "gt", Gte: "gte", Lt: "lt", Lte: "lte"}
+
+// rangeEnd returns the end of a number or duration range as the smart
+// playlist rule value: a number, or seconds.
+func rangeEnd(v Value) float64 {
+	switch v := v.(type) {
+	case Number:
+		return float64(v)
+	case Duration:
+		return time.Duration(v).Seconds()
+	}
+	return 0
+}
+
+// dateRange matches dates from low up to high, either of which may be
+// zero for no bound.
+func dateRange(field string, low, high time.Time) (bson.M, error) {
+	var parts bson.A
+	if !low.IsZero() {
+		part, err := playlists.Rule{Field: field, Op: "after", Value: low.Format(dateLayout)}.Filter()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if !high.IsZero() {
+		part, err := playlists.Rule{Field: field, Op: "before", Value: high.Format(dateLayout)}.Filter()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 1 {
+		return parts[0].(bson.M), nil
+	}
+	return bson.M{"$and": parts}, nil
+}
+
+// textPattern returns the case-insensitive regex of text or a pattern:
+// whole values when anchored, else anywhere in them.
+func textPattern(v Value, anchored bool) primitive.Regex {
+	var b strings.Builder
+	switch v := v.(type) {
+	case Pattern:
+		for _, r := range string(v) {
+			switch r {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+	case String:
+		b.WriteString(regexp.QuoteMeta(string(v)))
+	}
+	if anchored {
+		return primitive.Regex{Pattern: "^" + b.String() + "$", Options: "i"}
+	}
+	return primitive.Regex{Pattern: b.String(), Options: "i"}
+}
@@ -0,0 +1,424 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ksuayan/go-tracks/playlists"
+)
+
+// PathField is the path of a track under its library root, as
+// subDir/fileName. It's the one field that isn't a smart playlist field.
+const PathField = "path"
+
+const dateLayout = "2006-01-02"
+
+var (
+	predicate     = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)(>=|<=|!=|:|=|>|<)(.*)$`)
+	clockDuration = regexp.MustCompile(`^(\d+):(\d{2})(?::(\d{2}))?$`)
+	dateValue     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// Parse parses a query. Terms side by side must all match; OR between
+// them matches either, - or NOT negates one, and parentheses group them.
+// A term is field:value (or =, !=, >, >=, <, <=), or a word or "quoted
+// phrase" to find in the title, artist, album or album artist. An empty
+// query matches every track.
+func Parse(input string) (Node, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, end: len([]rune(input)) + 1}
+	if len(tokens) == 0 {
+		return And{}, nil
+	}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("column %d: unexpected %q", tok.pos, tok.text)
+	}
+	return node, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord   tokenKind = iota // a word, possibly field:value
+	tokenPhrase                  // a "quoted phrase"
+	tokenMinus
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	value  string // the quoted value of field:"value"
+	quoted bool
+	pos    int // column, from 1
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i + 1})
+			i++
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, token{kind: tokenMinus, text: "-", pos: i + 1})
+			i++
+		case r == '"':
+			text, next, err := readQuoted(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenPhrase, text: text, pos: i + 1})
+			i = next
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()"`, runes[i]) {
+				i++
+			}
+			tok := token{kind: tokenWord, text: string(runes[start:i]), pos: start + 1}
+			// field:"quoted value"
+			if i < len(runes) && runes[i] == '"' && strings.ContainsAny(tok.text[len(tok.text)-1:], ":=<>") {
+				value, next, err := readQuoted(runes, i)
+				if err != nil {
+					return nil, err
+				}
+				tok.value, tok.quoted = value, true
+				i = next
+			}
+			tokens = append(tokens, tok)
+		}
+	}
+	return tokens, nil
+}
+
+// readQuoted reads the quoted string starting at runes[i], with \" and \\
+// escapes, returning it and the index after it.
+func readQuoted(runes []rune, i int) (string, int, error) {
+	var b strings.Builder
+	for j := i + 1; j < len(runes); j++ {
+		switch runes[j] {
+		case '\\':
+			if j+1 < len(runes) {
+				j++
+			}
+			b.WriteRune(runes[j])
+		case '"':
+			return b.String(), j + 1, nil
+		default:
+			b.WriteRune(runes[j])
+		}
+	}
+	return "", 0, fmt.Errorf("column %d: unterminated quote", i+1)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	end    int // column after the end of the input
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return token{}, false
+}
+
+// keyword reports whether the next token is an unquoted OR, AND or NOT.
+func (p *parser) keyword(word string) bool {
+	tok, ok := p.peek()
+	return ok && tok.kind == tokenWord && !tok.quoted && tok.text == word
+}
+
+func (p *parser) or() (Node, error) {
+	var terms []Node
+	for {
+		term, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+		if !p.keyword("OR") {
+			break
+		}
+		p.pos++
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return Or{Terms: terms}, nil
+}
+
+func (p *parser) and() (Node, error) {
+	var terms []Node
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokenRParen || p.keyword("OR") {
+			break
+		}
+		if p.keyword("AND") {
+			p.pos++
+			if next, ok := p.peek(); !ok || next.kind == tokenRParen || p.keyword("OR") || p.keyword("AND") {
+				return nil, fmt.Errorf("column %d: expected a term after AND", tok.pos)
+			}
+			continue
+		}
+		term, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	switch len(terms) {
+	case 0:
+		if tok, ok := p.peek(); ok {
+			return nil, fmt.Errorf("column %d: expected a term before %q", tok.pos, tok.text)
+		}
+		return nil, fmt.Errorf("column %d: expected a term", p.end)
+	case 1:
+		return terms[0], nil
+	}
+	return And{Terms: terms}, nil
+}
+
+func (p *parser) unary() (Node, error) {
+	if tok, _ := p.peek(); tok.kind == tokenMinus || p.keyword("NOT") {
+		p.pos++
+		if _, ok := p.peek(); !ok {
+			return nil, fmt.Errorf("column %d: nothing to negate", tok.pos)
+		}
+		term, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Term: term}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Node, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("column %d: expected a term", p.end)
+	}
+	p.pos++
+	switch tok.kind {
+	case tokenLParen:
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if next, ok := p.peek(); !ok || next.kind != tokenRParen {
+			return nil, fmt.Errorf("column %d: unclosed parenthesis", tok.pos)
+		}
+		p.pos++
+		return node, nil
+	case tokenPhrase:
+		return Text{Value: String(tok.text)}, nil
+	}
+
+	m := predicate.FindStringSubmatch(tok.text)
+	if m == nil {
+		return Text{Value: textValue(tok.text)}, nil
+	}
+	field, ok := lookupField(m[1])
+	if !ok {
+		return nil, fmt.Errorf("column %d: unknown field %q; quote it to search for the text", tok.pos, m[1])
+	}
+	raw := m[3]
+	if tok.quoted {
+		raw = tok.value
+	}
+	if raw == "" && !tok.quoted {
+		return nil, fmt.Errorf("column %d: %s%s needs a value", tok.pos, m[1], m[2])
+	}
+	value, err := parseValue(field, raw, tok.quoted)
+	if err != nil {
+		return nil, fmt.Errorf("column %d: %s: %v", tok.pos, field, err)
+	}
+	c := Compare{Field: field, Op: Op(m[2]), Value: value}
+	if err := checkOp(c); err != nil {
+		return nil, fmt.Errorf("column %d: %v", tok.pos, err)
+	}
+	return c, nil
+}
+
+// lookupField finds a field by name, ignoring case.
+func lookupField(name string) (string, bool) {
+	if strings.EqualFold(name, PathField) {
+		return PathField, true
+	}
+	for fieldName := range playlists.Fields {
+		if strings.EqualFold(fieldName, name) {
+			return fieldName, true
+		}
+	}
+	return "", false
+}
+
+// FieldType returns the type of a field: one of the smart playlist field
+// types.
+func FieldType(field string) string {
+	if field == PathField {
+		return playlists.TypeString
+	}
+	return playlists.Fields[field].Type
+}
+
+// textValue types a word to search for: a Pattern with wildcards, or a
+// String.
+func textValue(raw string) Value {
+	if strings.ContainsAny(raw, "*?") {
+		return Pattern(raw)
+	}
+	return String(raw)
+}
+
+// parseValue types the value of a field. Text fields take text or a
+// pattern, unless quoted; other fields take values of their type, or a
+// range of them.
+func parseValue(field, raw string, quoted bool) (Value, error) {
+	fieldType := FieldType(field)
+	if fieldType == playlists.TypeString {
+		if quoted {
+			return String(raw), nil
+		}
+		return textValue(raw), nil
+	}
+	if fieldType != playlists.TypeBool {
+		if low, high, ok := strings.Cut(raw, ".."); ok {
+			var r Range
+			var err error
+			if low != "" {
+				if r.Low, err = parseScalar(fieldType, low); err != nil {
+					return nil, err
+				}
+			}
+			if high != "" {
+				if r.High, err = parseScalar(fieldType, high); err != nil {
+					return nil, err
+				}
+			}
+			if r.Low == nil && r.High == nil {
+				return nil, fmt.Errorf("a range needs at least one end")
+			}
+			if _, isAge := r.Low.(Duration); isAge && fieldType == playlists.TypeDate {
+				return nil, fmt.Errorf("date ranges take dates, not ages")
+			}
+			if _, isAge := r.High.(Duration); isAge && fieldType == playlists.TypeDate {
+				return nil, fmt.Errorf("date ranges take dates, not ages")
+			}
+			return r, nil
+		}
+	}
+	return parseScalar(fieldType, raw)
+}
+
+func parseScalar(fieldType, raw string) (Value, error) {
+	switch fieldType {
+	case playlists.TypeNumber:
+		if n, ok := parseNumber(raw); ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("%q is not a number", raw)
+	case playlists.TypeDuration:
+		if d, ok := parseDuration(raw); ok {
+			return d, nil
+		}
+		if n, ok := parseNumber(raw); ok {
+			return Duration(float64(n) * float64(time.Second)), nil
+		}
+		return nil, fmt.Errorf("%q is not a duration such as 3:30, 90s or 10m", raw)
+	case playlists.TypeDate:
+		if dateValue.MatchString(raw) {
+			if t, err := time.Parse(dateLayout, raw); err == nil {
+				return Date(t), nil
+			}
+		}
+		if d, ok := parseDuration(raw); ok && !clockDuration.MatchString(raw) {
+			return d, nil
+		}
+		return nil, fmt.Errorf("%q is not a date (2006-01-02) or an age such as 30d", raw)
+	case playlists.TypeBool:
+		switch strings.ToLower(raw) {
+		case "true", "yes":
+			return Bool(true), nil
+		case "false", "no":
+			return Bool(false), nil
+		}
+		return nil, fmt.Errorf("%q is not true or false", raw)
+	}
+	return nil, fmt.Errorf("unknown field type %s", fieldType)
+}
+
+// parseNumber parses a number, with an optional k for thousands.
+func parseNumber(raw string) (Number, bool) {
+	scale := 1.0
+	if strings.HasSuffix(raw, "k") || strings.HasSuffix(raw, "K") {
+		raw, scale = raw[:len(raw)-1], 1000
+	}
+	n, err := strconv.ParseFloat(raw, 64)
+	return Number(n * scale), err == nil
+}
+
+// parseDuration parses 3:30 and 1:02:03, and durations with a unit: s, m,
+// h, d for days, or combinations Go understands, such as 1h30m.
+func parseDuration(raw string) (Duration, bool) {
+	if m := clockDuration.FindStringSubmatch(raw); m != nil {
+		a, _ := strconv.Atoi(m[1])
+		b, _ := strconv.Atoi(m[2])
+		if m[3] == "" {
+			return Duration(time.Duration(a)*time.Minute + time.Duration(b)*time.Second), true
+		}
+		c, _ := strconv.Atoi(m[3])
+		return Duration(time.Duration(a)*time.Hour + time.Duration(b)*time.Minute + time.Duration(c)*time.Second), true
+	}
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		return Duration(n * float64(24*time.Hour)), err == nil
+	}
+	if raw == "" || !unicode.IsDigit(rune(raw[0])) {
+		return 0, false
+	}
+	d, err := time.ParseDuration(raw)
+	return Duration(d), err == nil
+}
+
+// checkOp checks that a comparison's operator suits its value.
+func checkOp(c Compare) error {
+	switch v := c.Value.(type) {
+	case String, Pattern, Bool:
+		if c.Op != Match && c.Op != Eq && c.Op != Ne {
+			return fmt.Errorf("%s takes :, = or != with %s", c.Field, typeName(v))
+		}
+	case Range:
+		if c.Op != Match && c.Op != Eq && c.Op != Ne {
+			return fmt.Errorf("%s takes :, = or != with a range", c.Field)
+		}
+	case Duration:
+		// An age: added<30d means added in the last 30 days
+		if FieldType(c.Field) == playlists.TypeDate && (c.Op == Eq || c.Op == Ne) {
+			return fmt.Errorf("%s takes :, <, <=, > or >= with an age", c.Field)
+		}
+	}
+	return nil
+}
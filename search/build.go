@@ -0,0 +1,152 @@
+package search
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// collections holds the collection of each kind of document.
+var collections = map[string]string{KindTrack: "tracks", KindAlbum: "albums", KindArtist: "artists"}
+
+// Build indexes the tracks, albums and artists of the database. Tracks are
+// searched by title, artist, album, album artist, composer, genre and
+// lyrics; albums by name, album artist and genres; artists by name and
+// aliases.
+func Build(db *mongo.Database) (*Index, error) {
+	ctx := context.Background()
+
+	texts := make(map[primitive.ObjectID]string)
+	err := each(ctx, db.Collection("lyrics"), bson.M{"text": 1}, func(doc map[string]interface{}) {
+		if id, ok := doc["_id"].(primitive.ObjectID); ok {
+			texts[id] = utils.SafeGetString(doc, "text")
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []Document
+	trackFields := bson.M{"title": 1, "artist": 1, "album": 1, "albumArtist": 1, "composer": 1, "genre": 1, "ffprobe.format.tags": 1}
+	err = each(ctx, db.Collection("tracks"), trackFields, func(track map[string]interface{}) {
+		id, _ := track["_id"].(primitive.ObjectID)
+		// Tracks scanned before composers were stored still have the tag
+		composer := utils.SafeGetString(track, "composer")
+		if composer == "" {
+			composer = utils.FindTagValue(utils.TrackTags(track), "composer")
+		}
+		docs = append(docs, Document{Kind: KindTrack, ID: id, Fields: map[string]string{
+			"title":       utils.SafeGetString(track, "title"),
+			"artist":      utils.SafeGetString(track, "artist"),
+			"album":       utils.SafeGetString(track, "album"),
+			"albumArtist": utils.SafeGetString(track, "albumArtist"),
+			"composer":    composer,
+			"genre":       utils.SafeGetString(track, "genre"),
+			"lyrics":      texts[id],
+		}})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(ctx, db.Collection("albums"), bson.M{"name": 1, "albumArtist": 1, "stats.genres": 1}, func(album map[string]interface{}) {
+		id, _ := album["_id"].(primitive.ObjectID)
+		var genres interface{}
+		if stats, ok := utils.SafeGet(album, "stats"); ok {
+			genres = stats.(map[string]interface{})["genres"]
+		}
+		docs = append(docs, Document{Kind: KindAlbum, ID: id, Fields: map[string]string{
+			"name":        utils.SafeGetString(album, "name"),
+			"albumArtist": utils.SafeGetString(album, "albumArtist"),
+			"genre":       joinStrings(genres),
+		}})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = each(ctx, db.Collection("artists"), bson.M{"name": 1, "aliases": 1}, func(artist map[string]interface{}) {
+		id, _ := artist["_id"].(primitive.ObjectID)
+		docs = append(docs, Document{Kind: KindArtist, ID: id, Fields: map[string]string{
+			"name":    utils.SafeGetString(artist, "name"),
+			"aliases": joinStrings(artist["aliases"]),
+		}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return New(docs), nil
+}
+
+// Load finds the documents of hits, in order. Documents removed since the
+// index was built are nil.
+func Load(db *mongo.Database, hits []Hit) ([]map[string]interface{}, error) {
+	ids := make(map[string][]primitive.ObjectID)
+	for _, hit := range hits {
+		ids[hit.Kind] = append(ids[hit.Kind], hit.ID)
+	}
+	found := make(map[primitive.ObjectID]map[string]interface{})
+	for kind, kindIDs := range ids {
+		opts := options.Find()
+		if kind == KindTrack {
+			opts.SetProjection(bson.M{"ffprobe": 0})
+		}
+		cursor, err := db.Collection(collections[kind]).Find(context.Background(), bson.M{"_id": bson.M{"$in": kindIDs}}, opts)
+		if err != nil {
+			return nil, err
+		}
+		var docs []map[string]interface{}
+		if err := cursor.All(context.Background(), &docs); err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			found[doc["_id"].(primitive.ObjectID)] = doc
+		}
+	}
+	docs := make([]map[string]interface{}, len(hits))
+	for i, hit := range hits {
+		docs[i] = found[hit.ID]
+	}
+	return docs, nil
+}
+
+// each calls fn with every document of a collection.
+func each(ctx context.Context, coll *mongo.Collection, projection bson.M, fn func(map[string]interface{})) error {
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc map[string]interface{}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		fn(doc)
+	}
+	return cursor.Err()
+}
+
+// joinStrings joins the strings of a stored array, one per line.
+func joinStrings(value interface{}) string {
+	var values []interface{}
+	switch v := value.(type) {
+	case primitive.A:
+		values = v
+	case []interface{}:
+		values = v
+	}
+	var result []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return strings.Join(result, "\n")
+}
@@ -0,0 +1,305 @@
+// Package search is full-text search over the library: an in-memory
+// inverted index of tracks (with their lyrics), albums and artists, built
+// from the database. Matching ignores case and diacritics, expands words to
+// the terms they prefix and tolerates typos, and ranks with BM25 weighted by
+// field.
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// Kinds of documents.
+const (
+	KindTrack  = "track"
+	KindAlbum  = "album"
+	KindArtist = "artist"
+)
+
+// DefaultBoosts weighs matches by field: a match in a title counts more
+// than one in the lyrics.
+var DefaultBoosts = map[string]float64{
+	"title":       3,
+	"name":        3,
+	"artist":      2,
+	"albumArtist": 1.5,
+	"album":       1.5,
+	"aliases":     1.5,
+	"composer":    1,
+	"genre":       1,
+	"lyrics":      0.5,
+}
+
+// Weights of expanded terms relative to exact matches.
+const (
+	prefixWeight  = 0.7
+	typoWeight    = 0.6 // per edit: 0.6 for one typo, 0.36 for two
+	maxExpansions = 50
+)
+
+// BM25 parameters.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Document is a document to index: its fields by name. Fields with several
+// values, such as aliases, are joined into one text.
+type Document struct {
+	Kind   string
+	ID     primitive.ObjectID
+	Fields map[string]string
+}
+
+// Hit is a search result.
+type Hit struct {
+	Kind  string             `json:"kind"`
+	ID    primitive.ObjectID `json:"id"`
+	Score float64            `json:"score"`
+}
+
+// Options restrict a search.
+type Options struct {
+	// Kinds limits results to some kinds of documents; all by default.
+	Kinds []string
+	// Limit bounds the results of each kind; unlimited when 0.
+	Limit int
+	// Boosts weighs fields, DefaultBoosts when nil. Fields without a
+	// boost aren't searched.
+	Boosts map[string]float64
+}
+
+// Index is an inverted index: for each term, the fields of documents
+// containing it. It's read-only once built, and safe for concurrent use.
+type Index struct {
+	docs     []docRef
+	fields   []string
+	postings map[string][]posting
+	terms    []string // sorted, for prefix and typo expansion
+	avgLen   []float64
+	built    time.Time
+}
+
+type docRef struct {
+	kind string
+	id   primitive.ObjectID
+}
+
+// posting is an occurrence of a term in a field of a document.
+type posting struct {
+	doc    int32
+	field  uint8
+	count  uint16 // occurrences of the term in the field
+	length uint16 // terms in the field
+}
+
+// Terms splits text into index terms: folded words, as in utils.FoldKey.
+func Terms(text string) []string {
+	return strings.Fields(utils.FoldKey(text))
+}
+
+// New builds an index of documents.
+func New(docs []Document) *Index {
+	ix := &Index{postings: make(map[string][]posting), built: time.Now()}
+	fieldNums := make(map[string]uint8)
+	var totalLen []int
+	var fieldDocs []int
+	for _, doc := range docs {
+		n := int32(len(ix.docs))
+		ix.docs = append(ix.docs, docRef{doc.Kind, doc.ID})
+		for name, text := range doc.Fields {
+			terms := Terms(text)
+			if len(terms) == 0 {
+				continue
+			}
+			field, ok := fieldNums[name]
+			if !ok {
+				field = uint8(len(ix.fields))
+				fieldNums[name] = field
+				ix.fields = append(ix.fields, name)
+				totalLen = append(totalLen, 0)
+				fieldDocs = append(fieldDocs, 0)
+			}
+			totalLen[field] += len(terms)
+			fieldDocs[field]++
+
+			counts := make(map[string]int)
+			for _, term := range terms {
+				counts[term]++
+			}
+			length := uint16(min(len(terms), math.MaxUint16))
+			for term, count := range counts {
+				ix.postings[term] = append(ix.postings[term], posting{doc: n, field: field, count: uint16(min(count, math.MaxUint16)), length: length})
+			}
+		}
+	}
+	for term := range ix.postings {
+		ix.terms = append(ix.terms, term)
+	}
+	sort.Strings(ix.terms)
+	for field := range ix.fields {
+		ix.avgLen = append(ix.avgLen, float64(totalLen[field])/float64(fieldDocs[field]))
+	}
+	return ix
+}
+
+// Len returns the number of documents indexed.
+func (ix *Index) Len() int {
+	return len(ix.docs)
+}
+
+// Built returns when the index was built.
+func (ix *Index) Built() time.Time {
+	return ix.built
+}
+
+// Search finds the documents matching every word of the query, best first.
+// A word matches a term equal to it, a term it's a prefix of, or a term a
+// typo or two away (one from four letters, two from eight).
+func (ix *Index) Search(query string, opts Options) []Hit {
+	boosts := opts.Boosts
+	if boosts == nil {
+		boosts = DefaultBoosts
+	}
+	fieldBoosts := make([]float64, len(ix.fields))
+	for i, name := range ix.fields {
+		fieldBoosts[i] = boosts[name]
+	}
+	kinds := make(map[string]bool)
+	for _, kind := range opts.Kinds {
+		kinds[kind] = true
+	}
+
+	var total map[int32]float64
+	seen := make(map[string]bool)
+	for _, word := range Terms(query) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+
+		// A document scores its best match of the word
+		scores := make(map[int32]float64)
+		for term, weight := range ix.expand(word) {
+			postings := ix.postings[term]
+			idf := math.Log(1 + (float64(len(ix.docs))-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for _, p := range postings {
+				boost := fieldBoosts[p.field]
+				if boost == 0 || (len(kinds) > 0 && !kinds[ix.docs[p.doc].kind]) {
+					continue
+				}
+				tf := float64(p.count)
+				norm := tf * (k1 + 1) / (tf + k1*(1-b+b*float64(p.length)/ix.avgLen[p.field]))
+				if score := weight * boost * idf * norm; score > scores[p.doc] {
+					scores[p.doc] = score
+				}
+			}
+		}
+
+		if total == nil {
+			total = scores
+			continue
+		}
+		for doc := range total {
+			if score, ok := scores[doc]; ok {
+				total[doc] += score
+			} else {
+				delete(total, doc)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(total))
+	for doc, score := range total {
+		ref := ix.docs[doc]
+		hits = append(hits, Hit{Kind: ref.kind, ID: ref.id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID.Hex() < hits[j].ID.Hex()
+	})
+	if opts.Limit > 0 {
+		perKind := make(map[string]int)
+		limited := hits[:0]
+		for _, hit := range hits {
+			if perKind[hit.Kind] < opts.Limit {
+				perKind[hit.Kind]++
+				limited = append(limited, hit)
+			}
+		}
+		hits = limited
+	}
+	return hits
+}
+
+// expand returns the terms a query word matches, with their weights.
+func (ix *Index) expand(word string) map[string]float64 {
+	terms := make(map[string]float64)
+	if _, ok := ix.postings[word]; ok {
+		terms[word] = 1
+	}
+
+	// Terms the word is a prefix of, the most common first when there
+	// are too many
+	if utf8.RuneCountInString(word) >= 2 {
+		start := sort.SearchStrings(ix.terms, word)
+		var prefixed []string
+		for _, term := range ix.terms[start:] {
+			if !strings.HasPrefix(term, word) {
+				break
+			}
+			if term != word {
+				prefixed = append(prefixed, term)
+			}
+		}
+		if len(prefixed) > maxExpansions {
+			sort.SliceStable(prefixed, func(i, j int) bool {
+				return len(ix.postings[prefixed[i]]) > len(ix.postings[prefixed[j]])
+			})
+			prefixed = prefixed[:maxExpansions]
+		}
+		for _, term := range prefixed {
+			terms[term] = prefixWeight
+		}
+	}
+
+	// Terms a typo or two away, sharing the first letter
+	length := utf8.RuneCountInString(word)
+	maxEdits := 0
+	switch {
+	case length >= 8:
+		maxEdits = 2
+	case length >= 4:
+		maxEdits = 1
+	}
+	if maxEdits == 0 {
+		return terms
+	}
+	first, _ := utf8.DecodeRuneInString(word)
+	initial := string(first)
+	for _, term := range ix.terms[sort.SearchStrings(ix.terms, initial):] {
+		if !strings.HasPrefix(term, initial) {
+			break
+		}
+		if _, ok := terms[term]; ok {
+			continue
+		}
+		if diff := utf8.RuneCountInString(term) - length; diff > maxEdits || diff < -maxEdits {
+			continue
+		}
+		if edits := utils.Levenshtein(word, term); edits <= maxEdits {
+			terms[term] = math.Pow(typoWeight, float64(edits))
+		}
+	}
+	return terms
+}
@@ -0,0 +1,74 @@
+package tracks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ksuayan/go-tracks/musicbrainz"
+	"github.com/ksuayan/go-tracks/utils"
+)
+
+// EnrichTrack merges MusicBrainz data into the track's `musicbrainz`
+// sub-document. The track is located on release (when given) by MBID or
+// position; otherwise its recording is looked up directly. The work, if the
+// track is tagged with one, is fetched as well.
+func EnrichTrack(db *mongo.Database, mb *musicbrainz.Client, track map[string]interface{}, release *musicbrainz.Release) error {
+	ids := musicbrainz.IDsFromTrack(track)
+	set := bson.M{}
+
+	var mbTrack *musicbrainz.Track
+	var medium *musicbrainz.Medium
+	if release != nil {
+		medium, mbTrack = release.FindTrack(ids.TrackID, ids.RecordingID, utils.SafeGetInt(track, "disc"), utils.SafeGetInt(track, "track"))
+	}
+
+	switch {
+	case mbTrack != nil:
+		set["musicbrainz.releaseId"] = release.ID
+		set["musicbrainz.trackId"] = mbTrack.ID
+		set["musicbrainz.recordingId"] = mbTrack.Recording.ID
+		set["musicbrainz.title"] = mbTrack.Title
+		set["musicbrainz.number"] = mbTrack.Number
+		set["musicbrainz.position"] = mbTrack.Position
+		set["musicbrainz.discNumber"] = medium.Position
+		set["musicbrainz.discTitle"] = medium.Title
+		set["musicbrainz.length"] = mbTrack.Length
+		set["musicbrainz.artistCredit"] = mbTrack.ArtistCredit
+		set["musicbrainz.artist"] = musicbrainz.CreditedName(mbTrack.ArtistCredit)
+	case ids.RecordingID != "":
+		recording, err := mb.LookupRecording(ids.RecordingID)
+		if err != nil {
+			return err
+		}
+		set["musicbrainz.title"] = recording.Title
+		set["musicbrainz.length"] = recording.Length
+		set["musicbrainz.isrcs"] = recording.ISRCs
+		set["musicbrainz.artistCredit"] = recording.ArtistCredit
+		set["musicbrainz.artist"] = musicbrainz.CreditedName(recording.ArtistCredit)
+	}
+
+	if ids.WorkID != "" {
+		work, err := mb.LookupWork(ids.WorkID)
+		if err != nil {
+			log.Printf("Error fetching MusicBrainz work %s: %v\n", ids.WorkID, err)
+		} else {
+			set["musicbrainz.work"] = work
+		}
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+	set["musicbrainz.enrichedAt"] = time.Now()
+
+	_, err := db.Collection("tracks").UpdateOne(context.Background(), bson.M{"_id": track["_id"]}, bson.M{"$set": set})
+	if err != nil {
+		return fmt.Errorf("error enriching track with ID %v: %v", track["_id"], err)
+	}
+	return nil
+}
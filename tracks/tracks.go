@@ -3,9 +3,12 @@ package tracks
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/ksuayan/go-tracks/coverart"
 	"github.com/ksuayan/go-tracks/mongodb"
+	"github.com/ksuayan/go-tracks/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -20,25 +23,75 @@ func UpdateTracks(db *mongo.Database, track map[string] interface{}) error {
 	artistObjectID := mongodb.SafeObjectIDFromHex(artistID)
 	albumObjectID := mongodb.SafeObjectIDFromHex(albumID)
 
-	coverArt, err := coverart.GetCoverArtPathFromHash("", coverArtHash)
-	if err != nil {
-		return fmt.Errorf("error getting cover art path for trackID %v: %v", track["_id"], err)
+	coverArt := ""
+	if coverArtHash != "" {
+		var err error
+		coverArt, err = coverart.GetCoverArtPathFromHash("", coverArtHash)
+		if err != nil {
+			return fmt.Errorf("error getting cover art path for trackID %v: %v", track["_id"], err)
+		}
+	}
+	set := bson.M{
+		"coverArtHash": coverArtHash,
+		"coverArt":     coverArt, 
+		"artistID":     artistObjectID,
+		"albumID":      albumObjectID,
+		"artistCredits": track["artistCredits"],
+		"status":       "cover",
+	}
+	if genreIDs, ok := track["genreIDs"]; ok {
+		set["genres"] = track["genres"]
+		set["genreIDs"] = genreIDs
 	}
-	_, err = db.Collection("tracks").UpdateOne(
+	_, err := db.Collection("tracks").UpdateOne(
 		context.Background(),
 		bson.M{"_id": track["_id"]},
-		bson.M{
-			"$set": bson.M{
-				"coverArtHash": coverArtHash,
-				"coverArt":     coverArt, 
-				"artistID":     artistObjectID,
-				"albumID":      albumObjectID,
-				"status":       "cover",
-			},
-		},
+		bson.M{"$set": set},
 	)
 	if err != nil {
 		return fmt.Errorf("error updating database for track with ID %v: %v", track["_id"], err)
 	}
 	return nil
 }
+
+
+// ArtistFilter matches tracks crediting an artist in any role, so featured
+// appearances and remixes show up alongside the artist's own tracks.
+func ArtistFilter(artistID string) bson.M {
+	objectID := mongodb.SafeObjectIDFromHex(artistID)
+	return bson.M{"$or": []bson.M{
+		{"artistID": objectID},
+		{"artistCredits.artistId": objectID},
+	}}
+}
+
+// FindByArtist returns every track crediting the artist.
+func FindByArtist(db *mongo.Database, artistID string) ([]bson.M, error) {
+	cursor, err := db.Collection("tracks").Find(context.Background(), ArtistFilter(artistID))
+	if err != nil {
+		return nil, err
+	}
+	var found []bson.M
+	if err := cursor.All(context.Background(), &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// FilePath returns the path of a track's audio file.
+func FilePath(track map[string]interface{}) string {
+	return filepath.Join(utils.SafeGetString(track, "rootDir"), utils.SafeGetString(track, "subDir"), utils.SafeGetString(track, "fileName"))
+}
+
+// Segment returns where a track starts and ends within its audio file. Both
+// are 0 for ordinary tracks; tracks split from a single-file rip by a CUE
+// sheet start at their index, and end is 0 when they run to the end of the
+// file. Anything that plays, exports or measures tracks must honour these.
+func Segment(track map[string]interface{}) (start, end time.Duration) {
+	return time.Duration(utils.SafeGetInt64(track, "start")), time.Duration(utils.SafeGetInt64(track, "end"))
+}
+
+// IsCueTrack reports whether a track is a virtual track within a larger file.
+func IsCueTrack(track map[string]interface{}) bool {
+	return utils.SafeGetInt(track, "cueTrack") > 0
+}
@@ -0,0 +1,185 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksuayan/go-tracks/diskcache"
+)
+
+// segmentPoll is how often a segmenting run looks for finished segments.
+const segmentPoll = 200 * time.Millisecond
+
+// SegmentArgs returns the ffmpeg arguments cutting a job's output into
+// files of length d in dir, numbered from 0. The segments come from one
+// encode, so that AAC priming happens once at the start of the stream
+// rather than as a gap at every segment boundary.
+func SegmentArgs(job Job, d time.Duration, dir string) []string {
+	return append(encodeArgs(job),
+		"-f", "segment", "-segment_time", seconds(d), "-segment_format", job.Profile.Format,
+		filepath.Join(dir, "%d."+job.Profile.Extension))
+}
+
+// segmentRun is a running ffmpeg segmenting a job.
+type segmentRun struct {
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced as segments are cached
+	done    bool
+	err     error
+}
+
+// state returns a channel closed on the next change, and whether the run
+// has finished.
+func (r *segmentRun) state() (<-chan struct{}, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changed, r.done, r.err
+}
+
+func (r *segmentRun) notify(done bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.done, r.err = done, err
+}
+
+// Segment returns segment n of a job's output cut into segments of length
+// d, cached as job.CacheKey/<n>.<extension>. The first request for a
+// segment that isn't cached starts one ffmpeg run for the whole job, which
+// caches every segment as it is finished and keeps going when that
+// request is cancelled; later requests wait for their segment.
+func (t *Transcoder) Segment(ctx context.Context, cache *diskcache.Cache, job Job, d time.Duration, n int) (string, error) {
+	key := fmt.Sprintf("%s/%d.%s", job.CacheKey, n, job.Profile.Extension)
+	// A second run covers segments evicted before they were served
+	for attempt := 0; attempt < 2; attempt++ {
+		if path, ok := cache.Get(key); ok {
+			return path, nil
+		}
+		run := t.segmentRun(cache, job, d)
+		for {
+			changed, done, err := run.state()
+			if path, ok := cache.Get(key); ok {
+				return path, nil
+			}
+			if done {
+				if err != nil {
+					return "", err
+				}
+				break
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+	}
+	return "", fmt.Errorf("ffmpeg produced no segment %d", n)
+}
+
+// segmentRun returns the run segmenting a job, starting it if needed.
+func (t *Transcoder) segmentRun(cache *diskcache.Cache, job Job, d time.Duration) *segmentRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if run, ok := t.runs[job.CacheKey]; ok {
+		return run
+	}
+	if t.runs == nil {
+		t.runs = make(map[string]*segmentRun)
+	}
+	run := &segmentRun{changed: make(chan struct{})}
+	t.runs[job.CacheKey] = run
+	go func() {
+		err := t.segment(cache, job, d, run)
+		t.mu.Lock()
+		delete(t.runs, job.CacheKey)
+		t.mu.Unlock()
+		run.notify(true, err)
+	}()
+	return run
+}
+
+// segment runs ffmpeg for a job into a temporary directory, moving each
+// segment into cache once ffmpeg has moved on to the next one.
+func (t *Transcoder) segment(cache *diskcache.Cache, job Job, d time.Duration, run *segmentRun) error {
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	dir, err := os.MkdirTemp("", "gt-hls-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command("ffmpeg", SegmentArgs(job, d, dir)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	file := func(n int) string {
+		return filepath.Join(dir, fmt.Sprintf("%d.%s", n, job.Profile.Extension))
+	}
+	store := func(n int) error {
+		_, err := cache.Put(fmt.Sprintf("%s/%d.%s", job.CacheKey, n, job.Profile.Extension), func(w io.Writer) error {
+			f, err := os.Open(file(n))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		})
+		os.Remove(file(n))
+		return err
+	}
+
+	ticker := time.NewTicker(segmentPoll)
+	defer ticker.Stop()
+	next := 0
+	for {
+		select {
+		case err := <-exited:
+			if err != nil {
+				log.Printf("Error segmenting %s to %s: %v %s\n", job.Path, job.Profile.Name, err, stderr.String())
+				return err
+			}
+			for ; ; next++ {
+				if _, err := os.Stat(file(next)); err != nil {
+					return nil
+				}
+				if err := store(next); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			stored := false
+			for ; ; next++ {
+				if _, err := os.Stat(file(next + 1)); err != nil {
+					break
+				}
+				if err := store(next); err != nil {
+					cmd.Process.Kill()
+					<-exited
+					return err
+				}
+				stored = true
+			}
+			if stored {
+				run.notify(false, nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package transcode converts audio for streaming with ffmpeg.
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksuayan/go-tracks/diskcache"
+)
+
+// Profile is an ffmpeg output format for streaming.
+type Profile struct {
+	Name        string
+	Codec       string // ffmpeg audio encoder, or "copy"
+	Bitrate     int    // kbit/s for constant bitrate; 0 for Quality
+	Quality     string // VBR quality (-q:a), e.g. "0" for MP3 V0
+	Format      string // ffmpeg muxer
+	Extension   string
+	ContentType string
+	// SourceCodec is the codec (as reported by ffprobe) this profile
+	// produces; sources already in it at or below Bitrate are sent as is.
+	SourceCodec string
+	// Args are extra ffmpeg output options.
+	Args []string
+}
+
+// Profiles are the built-in streaming profiles, by name.
+var Profiles = map[string]Profile{
+	"opus128": {Name: "opus128", Codec: "libopus", Bitrate: 128, Format: "ogg", Extension: "opus", ContentType: "audio/ogg", SourceCodec: "opus"},
+	"opus64":  {Name: "opus64", Codec: "libopus", Bitrate: 64, Format: "ogg", Extension: "opus", ContentType: "audio/ogg", SourceCodec: "opus"},
+	"mp3-320": {Name: "mp3-320", Codec: "libmp3lame", Bitrate: 320, Format: "mp3", Extension: "mp3", ContentType: "audio/mpeg", SourceCodec: "mp3"},
+	"mp3-v0":  {Name: "mp3-v0", Codec: "libmp3lame", Quality: "0", Format: "mp3", Extension: "mp3", ContentType: "audio/mpeg", SourceCodec: "mp3"},
+	"aac256":  {Name: "aac256", Codec: "aac", Bitrate: 256, Format: "adts", Extension: "aac", ContentType: "audio/aac", SourceCodec: "aac"},
+}
+
+// HLSProfiles are the variants of HLS streams, as MPEG-TS segments of AAC,
+// from the lowest bitrate up.
+var HLSProfiles = []Profile{
+	{Name: "aac128", Codec: "aac", Bitrate: 128, Format: "mpegts", Extension: "ts", ContentType: "video/mp2t", Args: []string{"-muxdelay", "0"}},
+	{Name: "aac256", Codec: "aac", Bitrate: 256, Format: "mpegts", Extension: "ts", ContentType: "video/mp2t", Args: []string{"-muxdelay", "0"}},
+}
+
+// FindHLS returns an HLS variant by name.
+func FindHLS(name string) (Profile, bool) {
+	for _, p := range HLSProfiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Lossless profiles cut tracks out of single-file rips without changing
+// their quality: MP3 is copied, anything else is encoded to FLAC.
+var (
+	ProfileFLAC    = Profile{Name: "flac", Codec: "flac", Format: "flac", Extension: "flac", ContentType: "audio/flac"}
+	ProfileMP3Copy = Profile{Name: "mp3-copy", Codec: "copy", Format: "mp3", Extension: "mp3", ContentType: "audio/mpeg"}
+)
+
+// Lossless returns the profile for cutting a file with the extension.
+func Lossless(extension string) Profile {
+	if strings.EqualFold(extension, ".mp3") {
+		return ProfileMP3Copy
+	}
+	return ProfileFLAC
+}
+
+// Find returns a profile by name.
+func Find(name string) (Profile, error) {
+	if p, ok := Profiles[strings.ToLower(name)]; ok {
+		return p, nil
+	}
+	return Profile{}, fmt.Errorf("unknown profile %q; use one of %s", name, strings.Join(Names(), ", "))
+}
+
+// Names returns the profile names, sorted.
+func Names() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Needed reports whether a source in codec at bitrate (bit/s, as stored on
+// tracks) has to be transcoded, rather than being sent as is. Sources
+// already in the profile's codec only are when they exceed its bitrate.
+func (p Profile) Needed(codec string, bitrate int) bool {
+	if p.SourceCodec == "" || !strings.EqualFold(codec, p.SourceCodec) {
+		return true
+	}
+	return p.Bitrate > 0 && bitrate > p.Bitrate*1000
+}
+
+// Job is one file, or a segment of it, to transcode.
+type Job struct {
+	Path    string
+	Start   time.Duration
+	End     time.Duration // 0 for the end of the file
+	Profile Profile
+	// CacheKey stores the output in the cache; see CacheKey.
+	CacheKey string
+}
+
+// CacheKey is the cache key of a file, or of a CUE track in it, in a
+// profile. Files are identified by their hash so that moved or renamed
+// files keep their transcodes.
+func CacheKey(fileHash string, cueTrack int, profile Profile) string {
+	if len(fileHash) < 2 {
+		return ""
+	}
+	name := fileHash + "-" + profile.Name
+	if cueTrack > 0 {
+		name += fmt.Sprintf("-%d", cueTrack)
+	}
+	return fileHash[:2] + "/" + name + "." + profile.Extension
+}
+
+// Args returns the ffmpeg arguments of a job, writing to stdout.
+func Args(job Job) []string {
+	return append(encodeArgs(job), "-f", job.Profile.Format, "pipe:1")
+}
+
+// encodeArgs returns the ffmpeg arguments of a job up to its output.
+func encodeArgs(job Job) []string {
+	args := []string{"-loglevel", "error", "-nostdin"}
+	if job.Start > 0 {
+		args = append(args, "-ss", seconds(job.Start))
+	}
+	args = append(args, "-i", job.Path)
+	if job.End > job.Start {
+		args = append(args, "-t", seconds(job.End-job.Start))
+	}
+	args = append(args, "-map", "0:a:0", "-map_metadata", "-1", "-c:a", job.Profile.Codec)
+	if job.Profile.Bitrate > 0 {
+		args = append(args, "-b:a", fmt.Sprintf("%dk", job.Profile.Bitrate))
+	}
+	if job.Profile.Quality != "" {
+		args = append(args, "-q:a", job.Profile.Quality)
+	}
+	return append(args, job.Profile.Args...)
+}
+
+// Transcoder runs ffmpeg with a bound on how many run at once, optionally
+// keeping the output in a disk cache.
+type Transcoder struct {
+	slots chan struct{}
+	cache *diskcache.Cache
+
+	mu   sync.Mutex
+	runs map[string]*segmentRun // by CacheKey
+}
+
+// New creates a transcoder running at most maxConcurrent ffmpeg processes.
+// cache may be nil.
+func New(maxConcurrent int, cache *diskcache.Cache) *Transcoder {
+	return &Transcoder{slots: make(chan struct{}, max(1, maxConcurrent)), cache: cache}
+}
+
+// Cached returns the path of a cached transcode.
+func (t *Transcoder) Cached(key string) (string, bool) {
+	if t.cache == nil || key == "" {
+		return "", false
+	}
+	return t.cache.Get(key)
+}
+
+// Transcode writes the job's output to w as ffmpeg produces it, waiting for
+// a free slot first. Cancelling ctx, as when the client disconnects, stops
+// ffmpeg. With a cache and a CacheKey, a complete output is also cached.
+func (t *Transcoder) Transcode(ctx context.Context, w io.Writer, job Job) error {
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-t.slots }()
+
+	if t.cache == nil || job.CacheKey == "" {
+		return run(ctx, w, job)
+	}
+	_, err := t.cache.Put(job.CacheKey, func(file io.Writer) error {
+		return run(ctx, io.MultiWriter(file, w), job)
+	})
+	return err
+}
+
+func run(ctx context.Context, w io.Writer, job Job) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", Args(job)...)
+	cmd.Stdout = w
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Error transcoding %s to %s: %v %s\n", job.Path, job.Profile.Name, err, stderr.String())
+		return err
+	}
+	return nil
+}
+
+// seconds formats a duration for ffmpeg.
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,97 @@
+// Package users keeps the accounts allowed to use the streaming APIs.
+package users
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// User is an account in the `users` collection. Subsonic token
+// authentication hashes the password with a salt chosen by the client, so
+// the password itself has to be kept; keep the database private.
+type User struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Name      string             `bson:"name"`
+	Password  string             `bson:"password"`
+	Admin     bool               `bson:"admin"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// Save creates a user, or changes the password and role of an existing one.
+func Save(db *mongo.Database, name, password string, admin bool) error {
+	if name == "" || password == "" {
+		return fmt.Errorf("a user needs a name and a password")
+	}
+	_, err := db.Collection("users").UpdateOne(context.Background(),
+		bson.M{"name": name},
+		bson.M{
+			"$set":         bson.M{"password": password, "admin": admin},
+			"$setOnInsert": bson.M{"createdAt": time.Now()},
+		},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// Remove deletes a user.
+func Remove(db *mongo.Database, name string) error {
+	res, err := db.Collection("users").DeleteOne(context.Background(), bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("no user %s", name)
+	}
+	return nil
+}
+
+// Find returns a user by name.
+func Find(db *mongo.Database, name string) (*User, error) {
+	var user User
+	if err := db.Collection("users").FindOne(context.Background(), bson.M{"name": name}).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// All returns every user, sorted by name.
+func All(db *mongo.Database) ([]User, error) {
+	ctx := context.Background()
+	cursor, err := db.Collection("users").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var found []User
+	err = cursor.All(ctx, &found)
+	return found, err
+}
+
+// CheckPassword checks a password as sent by Subsonic clients: plain, or
+// hex-encoded with an "enc:" prefix.
+func (u *User) CheckPassword(password string) bool {
+	if encoded, ok := strings.CutPrefix(password, "enc:"); ok {
+		decoded, err := hex.DecodeString(encoded)
+		if err != nil {
+			return false
+		}
+		password = string(decoded)
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(u.Password)) == 1
+}
+
+// CheckToken checks a Subsonic token, the MD5 of the password followed by
+// the salt.
+func (u *User) CheckToken(token, salt string) bool {
+	sum := md5.Sum([]byte(u.Password + salt))
+	expected := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(strings.ToLower(token)), []byte(expected)) == 1
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// discFolder matches the usual names of per-disc subfolders: "CD1", "CD 2",
+// "Disc 1", "disk_02", "Disc 1 of 3", "CD2 - Live in Berlin", "Disc 3 (Bonus)".
+var discFolder = regexp.MustCompile(`(?i)^(?:cd|disc|disk)\s*[-_.]?\s*(\d{1,3})(?:\s*(?:of|/)\s*\d+)?(?:\s*[-_:.]\s*(.+?)|\s*[(\[](.+?)[)\]])?\s*$`)
+
+// ParseDiscFolder parses a disc subfolder name into the disc number and the
+// disc subtitle, if any. ok is false for folders that aren't disc folders.
+func ParseDiscFolder(name string) (disc int, subtitle string, ok bool) {
+	match := discFolder.FindStringSubmatch(strings.TrimSpace(name))
+	if match == nil {
+		return 0, "", false
+	}
+	disc, _ = strconv.Atoi(match[1])
+	subtitle = strings.TrimSpace(match[2] + match[3])
+	return disc, subtitle, true
+}
+
+// AlbumDir returns the directory of an album given a track's subDir: the
+// parent directory when the track sits in a disc subfolder, or subDir itself.
+func AlbumDir(subDir string) string {
+	if _, _, ok := ParseDiscFolder(path.Base(subDir)); ok && subDir != "" {
+		if dir := path.Dir(subDir); dir != "." {
+			return dir
+		}
+		return ""
+	}
+	return subDir
+}
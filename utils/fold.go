@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+var caseFolder = cases.Fold()
+
+// FoldKey normalizes a name for matching: NFKC, case folding, diacritic
+// folding ("Björk" = "Bjork"), "&" read as "and", punctuation dropped and
+// whitespace collapsed. Names made only of punctuation ("!!!") keep it.
+func FoldKey(s string) string {
+	key := norm.NFKC.String(s)
+	key = caseFolder.String(key)
+	key = FoldDiacritics(key)
+	key = strings.ReplaceAll(key, "&", " and ")
+
+	var b strings.Builder
+	for _, r := range key {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		}
+	}
+	key = strings.Join(strings.Fields(b.String()), " ")
+
+	if key == "" {
+		return strings.TrimSpace(caseFolder.String(s))
+	}
+	return key
+}
+
+// FoldDiacritics removes combining marks, so "é" becomes "e".
+func FoldDiacritics(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+	return folded
+}
@@ -8,9 +8,12 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // getFileCreationDate retrieves the creation date (birth time) of a file using syscall.Stat_t.
@@ -78,6 +81,86 @@ func SafeGet(myMap map[string]interface{}, keys ...string) (interface{}, bool) {
 	return current, true
 }
 
+// SafeGetInt reads an integer field from a decoded document. Go ints are
+// stored as int32 or int64 depending on their size, and JSON numbers decode
+// as float64, so all of those are accepted.
+func SafeGetInt(myMap map[string]interface{}, key string) int {
+	switch value := myMap[key].(type) {
+	case int:
+		return value
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	case float64:
+		return int(value)
+	}
+	return 0
+}
+
+// SafeGetInt64 is SafeGetInt for values that may not fit in an int32, such
+// as durations.
+func SafeGetInt64(myMap map[string]interface{}, key string) int64 {
+	switch value := myMap[key].(type) {
+	case int:
+		return int64(value)
+	case int32:
+		return int64(value)
+	case int64:
+		return value
+	case float64:
+		return int64(value)
+	}
+	return 0
+}
+
+// SafeGetString reads a string field from a decoded document, returning ""
+// when the field is missing or not a string.
+func SafeGetString(myMap map[string]interface{}, key string) string {
+	value, _ := myMap[key].(string)
+	return value
+}
+
+// TrackTags returns the ffprobe tags of a track document as strings,
+// merged like ffprobe.AllTags: stream tags, which is where Ogg and Opus keep
+// theirs, overridden by container tags.
+func TrackTags(track map[string]interface{}) map[string]string {
+	tags := make(map[string]string)
+	probe, ok := SafeGet(track, "ffprobe")
+	if !ok {
+		return tags
+	}
+	var streams []interface{}
+	switch v := probe.(map[string]interface{})["streams"].(type) {
+	case []interface{}:
+		streams = v
+	case primitive.A:
+		streams = v
+	}
+	for _, stream := range streams {
+		if raw, ok := stream.(map[string]interface{}); ok {
+			addStringTags(tags, raw["tags"])
+		}
+	}
+	if raw, ok := SafeGet(track, "ffprobe", "format", "tags"); ok {
+		addStringTags(tags, raw)
+	}
+	return tags
+}
+
+// addStringTags copies the string values of a decoded tags document.
+func addStringTags(tags map[string]string, raw interface{}) {
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key, value := range m {
+		if s, ok := value.(string); ok {
+			tags[key] = s
+		}
+	}
+}
+
 // SafeGetTagValue is a helper function that safely retrieves a tag value from a map.
 func SafeGetTagValue( tags map[string] string, tagName string) (string){
 	tagValue, ok := tags[tagName]
@@ -87,6 +170,52 @@ func SafeGetTagValue( tags map[string] string, tagName string) (string){
 	return tagValue
 }
 
+// FindTagValue returns the first non-empty value for any of the given tag names.
+// Tag names are compared case-insensitively and ignoring spaces, dashes and
+// underscores, since ffprobe reports the same tag differently per container
+// (e.g. "MUSICBRAINZ_ALBUMID" for FLAC, "MusicBrainz Album Id" for MP3).
+func FindTagValue(tags map[string]string, tagNames ...string) string {
+	for _, tagName := range tagNames {
+		want := normalizeTagName(tagName)
+		for key, value := range tags {
+			if normalizeTagName(key) == want && strings.TrimSpace(value) != "" {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
+
+// WithoutTags returns a copy of tags without the named tags, compared as in
+// FindTagValue.
+func WithoutTags(tags map[string]string, tagNames ...string) map[string]string {
+	drop := make(map[string]bool)
+	for _, tagName := range tagNames {
+		drop[normalizeTagName(tagName)] = true
+	}
+	kept := make(map[string]string)
+	for key, value := range tags {
+		if !drop[normalizeTagName(key)] {
+			kept[key] = value
+		}
+	}
+	return kept
+}
+
+func normalizeTagName(name string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name))
+}
+
+// ParsePosition parses a track or disc number tag such as "3", "03/12" or
+// "3 of 12" into the number and the total, returning 0 for missing parts.
+func ParsePosition(value string) (int, int) {
+	value = strings.ReplaceAll(strings.ToLower(value), " of ", "/")
+	number, total, _ := strings.Cut(value, "/")
+	n, _ := strconv.Atoi(strings.TrimSpace(number))
+	t, _ := strconv.Atoi(strings.TrimSpace(total))
+	return n, t
+}
+
 func GetSubDir(filePath, rootDir, fileName string) string {
 	// Ensure the filePath starts with rootDir
 	if !strings.HasPrefix(filePath, rootDir) {
@@ -120,4 +249,38 @@ func SecondsToDuration(seconds float64) (int, int, int, float64) {
 	fractionalSeconds := math.Mod(seconds, 1)
 
 	return hours, minutes, secs, fractionalSeconds
-}
\ No newline at end of file
+}
+
+// Levenshtein returns the edit distance between two strings, counted in runes.
+func Levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Similarity returns a score between 0 and 1 for how alike two strings are,
+// ignoring case and surrounding whitespace.
+func Similarity(a, b string) float64 {
+	a = strings.ToLower(strings.TrimSpace(a))
+	b = strings.ToLower(strings.TrimSpace(b))
+	longest := max(len([]rune(a)), len([]rune(b)))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(Levenshtein(a, b))/float64(longest)
+}
@@ -12,17 +12,30 @@ import (
 	"github.com/ksuayan/go-tracks/albums"
 	"github.com/ksuayan/go-tracks/artists"
 	"github.com/ksuayan/go-tracks/coverart"
+	"github.com/ksuayan/go-tracks/genres"
+	"github.com/ksuayan/go-tracks/lyrics"
+	"github.com/ksuayan/go-tracks/musicbrainz"
 	"github.com/ksuayan/go-tracks/tracks"
 )
 
-const (
-	mbEnabled = false
-)
+// Config holds the settings shared by all workers.
+type Config struct {
+	// OutputDir is the root of the content-addressed cover art tree.
+	OutputDir string
+	// MusicBrainz may be nil to skip lookups. When set, the same client (and
+	// so the same rate limiter) must be shared by every worker.
+	MusicBrainz *musicbrainz.Client
+	// CoverSize is the Cover Art Archive size fetched for albums without art.
+	CoverSize string
+	// Genres maps genre tags to canonical genres.
+	Genres *genres.Taxonomy
+}
 
 // Worker function for processing tracks
-func Worker(tasks <-chan map[string]interface{}, db *mongo.Database, outputDir string, wg *sync.WaitGroup) {
+func Worker(tasks <-chan map[string]interface{}, db *mongo.Database, cfg Config, wg *sync.WaitGroup) {
 	defer wg.Done()
-	
+	mb := cfg.MusicBrainz
+
 	for track := range tasks {
 		// Validate required fields
 
@@ -34,34 +47,64 @@ func Worker(tasks <-chan map[string]interface{}, db *mongo.Database, outputDir s
 		// fmt.Printf("Processing file: %s\n", filePath)
 
 		// Extract Cover Art
-		coverArtHash, coverArtPath, err := coverart.ExtractCoverArt(db, track, outputDir)
+		coverArtHash, _, err := coverart.ExtractCoverArt(db, track, cfg.OutputDir)
 		if err != nil {
-			log.Printf("Error extracting cover art for %s: %v\n", coverArtPath, err)
-			continue
+			// Tracks without art still get artists and albums
+			log.Printf("Error extracting cover art for %s: %v\n", filePath, err)
 		}
 		track["coverArtHash"] = coverArtHash
 
 		// Update Artist
-		artistID, err := artists.UpdateArtists(db, track, mbEnabled)
+		artistID, err := artists.UpdateArtists(db, track, mb)
 		if err != nil {
 			log.Printf("Error updating artist for %s: %v\n", filePath, err)
 			continue
 		}
 		track["artistID"] = artistID 
 
+		// Update Genres
+		if _, err := genres.UpdateGenres(db, cfg.Genres, track); err != nil {
+			log.Printf("Error updating genres for %s: %v\n", filePath, err)
+		}
+
 		// Update Album
-		albumID, err := albums.UpdateAlbums(db, track)
+		albumID, err := albums.UpdateAlbums(db, track, mb)
 		if err != nil {
 			log.Printf("Error updating album for %s: %v\n", filePath, err)
 			continue
 		}
 		track["albumID"] = albumID
 
+		// Enrich Album and Track from MusicBrainz
+		if mb != nil {
+			release, err := albums.EnrichAlbum(db, mb, albumID, musicbrainz.IDsFromTrack(track))
+			if err != nil {
+				log.Printf("Error enriching album for %s: %v\n", filePath, err)
+			}
+			if err := tracks.EnrichTrack(db, mb, track, release); err != nil {
+				log.Printf("Error enriching track for %s: %v\n", filePath, err)
+			}
+		}
+
+		// Use the album's art, fetched from the Cover Art Archive if need be
+		if coverArtHash == "" {
+			albumArtHash, err := albums.FetchMissingCoverArt(db, mb, albumID, cfg.OutputDir, cfg.CoverSize)
+			if err != nil {
+				log.Printf("Error fetching cover art for %s: %v\n", filePath, err)
+			}
+			track["coverArtHash"] = albumArtHash
+		}
+
 		// Update Track Metadata
 		err = tracks.UpdateTracks(db, track)
 		if err != nil {
 			log.Printf("Error updating track metadata for %s: %v\n", filePath, err)
 		}
+
+		// Extract Lyrics
+		if _, err := lyrics.UpdateLyrics(db, track); err != nil {
+			log.Printf("Error extracting lyrics for %s: %v\n", filePath, err)
+		}
 	}
 }
 